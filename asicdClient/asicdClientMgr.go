@@ -24,6 +24,7 @@
 package asicdClient
 
 import (
+	"context"
 	"utils/asicdClient/flexswitch"
 	"utils/asicdClient/ovs"
 	"utils/commonDefs"
@@ -67,6 +68,16 @@ type AsicdClientIntf interface {
 	BPDUGuardDetected(ifindex int32, enable bool) error
 }
 
+//client making its calls on behalf of ctx, logging them with the trace ID of ctx.
+//The client itself for the plugins which do not support contexts
+func WithContext(client AsicdClientIntf, ctx context.Context) AsicdClientIntf {
+	switch c := client.(type) {
+	case *flexswitch.FSAsicdClientMgr:
+		return c.WithContext(ctx)
+	}
+	return client
+}
+
 func NewAsicdClientInit(plugin string, paramsFile string, asicdHdl commonDefs.AsicdClientStruct) AsicdClientIntf {
 	if plugin == "Flexswitch" {
 		clientHdl := flexswitch.GetAsicdThriftClientHdl(paramsFile, asicdHdl.Logger)
@@ -75,7 +86,7 @@ func NewAsicdClientInit(plugin string, paramsFile string, asicdHdl commonDefs.As
 			return nil
 		}
 		flexswitch.InitFSAsicdSubscriber(asicdHdl)
-		return &flexswitch.FSAsicdClientMgr{clientHdl}
+		return &flexswitch.FSAsicdClientMgr{ClientHdl: clientHdl}
 	} else if plugin == "OvsDB" {
 		ovs.InitOvsAsicdSubscriber(asicdHdl)
 		return &ovs.OvsAsicdClientMgr{100}
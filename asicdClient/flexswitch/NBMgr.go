@@ -34,7 +34,7 @@ import (
 
 var asicdSubSocket *nanomsg.SubSocket
 
-type processMsg func(uint8, []byte, *logging.Writer) (commonDefs.AsicdNotifyMsg, error)
+type processMsg func(uint8, []byte, logging.LoggerIntf) (commonDefs.AsicdNotifyMsg, error)
 
 var AsicdMsgMap map[uint8]processMsg = map[uint8]processMsg{
 	asicdCommonDefs.NOTIFY_L2INTF_STATE_CHANGE:       processL2IntdStateNotifyMsg,
@@ -57,7 +57,7 @@ var AsicdMsgMap map[uint8]processMsg = map[uint8]processMsg{
 	asicdCommonDefs.NOTIFY_IPV4_ROUTE_DELETE_FAILURE: processIPv4RouteAddDelNotifyMsg,
 }
 
-func processL2IntdStateNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Writer) (commonDefs.AsicdNotifyMsg, error) {
+func processL2IntdStateNotifyMsg(rxMsgType uint8, rxMsg []byte, logger logging.LoggerIntf) (commonDefs.AsicdNotifyMsg, error) {
 	var l2Msg asicdCommonDefs.L2IntfStateNotifyMsg
 	var msg commonDefs.AsicdNotifyMsg
 	err := json.Unmarshal(rxMsg, &l2Msg)
@@ -73,7 +73,7 @@ func processL2IntdStateNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.
 	return msg, nil
 }
 
-func processL3IntfStateNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Writer) (commonDefs.AsicdNotifyMsg, error) {
+func processL3IntfStateNotifyMsg(rxMsgType uint8, rxMsg []byte, logger logging.LoggerIntf) (commonDefs.AsicdNotifyMsg, error) {
 	var l3Msg asicdCommonDefs.L3IntfStateNotifyMsg
 	var msg commonDefs.AsicdNotifyMsg
 	err := json.Unmarshal(rxMsg, &l3Msg)
@@ -90,7 +90,7 @@ func processL3IntfStateNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.
 	return msg, nil
 }
 
-func processVlanNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Writer) (commonDefs.AsicdNotifyMsg, error) {
+func processVlanNotifyMsg(rxMsgType uint8, rxMsg []byte, logger logging.LoggerIntf) (commonDefs.AsicdNotifyMsg, error) {
 	var vlanMsg asicdCommonDefs.VlanNotifyMsg
 	var msg commonDefs.AsicdNotifyMsg
 	err := json.Unmarshal(rxMsg, &vlanMsg)
@@ -108,7 +108,7 @@ func processVlanNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Writer)
 	return msg, nil
 }
 
-func processLogicalIntfNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Writer) (commonDefs.AsicdNotifyMsg, error) {
+func processLogicalIntfNotifyMsg(rxMsgType uint8, rxMsg []byte, logger logging.LoggerIntf) (commonDefs.AsicdNotifyMsg, error) {
 	var logicalMsg asicdCommonDefs.LogicalIntfNotifyMsg
 	var msg commonDefs.AsicdNotifyMsg
 	err := json.Unmarshal(rxMsg, &logicalMsg)
@@ -125,7 +125,7 @@ func processLogicalIntfNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.
 
 }
 
-func processIPv4IntfNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Writer) (commonDefs.AsicdNotifyMsg, error) {
+func processIPv4IntfNotifyMsg(rxMsgType uint8, rxMsg []byte, logger logging.LoggerIntf) (commonDefs.AsicdNotifyMsg, error) {
 	var ipv4Msg asicdCommonDefs.IPv4IntfNotifyMsg
 	var msg commonDefs.AsicdNotifyMsg
 	err := json.Unmarshal(rxMsg, &ipv4Msg)
@@ -142,7 +142,7 @@ func processIPv4IntfNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Wri
 	return msg, nil
 }
 
-func processIPv6IntfNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Writer) (commonDefs.AsicdNotifyMsg, error) {
+func processIPv6IntfNotifyMsg(rxMsgType uint8, rxMsg []byte, logger logging.LoggerIntf) (commonDefs.AsicdNotifyMsg, error) {
 	var ipv6Msg asicdCommonDefs.IPv6IntfNotifyMsg
 	var msg commonDefs.AsicdNotifyMsg
 	err := json.Unmarshal(rxMsg, &ipv6Msg)
@@ -159,7 +159,7 @@ func processIPv6IntfNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Wri
 	return msg, nil
 }
 
-func processLagNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Writer) (commonDefs.AsicdNotifyMsg, error) {
+func processLagNotifyMsg(rxMsgType uint8, rxMsg []byte, logger logging.LoggerIntf) (commonDefs.AsicdNotifyMsg, error) {
 	var lagMsg asicdCommonDefs.LagNotifyMsg
 	var msg commonDefs.AsicdNotifyMsg
 	err := json.Unmarshal(rxMsg, &lagMsg)
@@ -177,7 +177,7 @@ func processLagNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Writer)
 	return msg, nil
 }
 
-func processIPv4NbrMacMoveNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Writer) (commonDefs.AsicdNotifyMsg, error) {
+func processIPv4NbrMacMoveNotifyMsg(rxMsgType uint8, rxMsg []byte, logger logging.LoggerIntf) (commonDefs.AsicdNotifyMsg, error) {
 	var macMoveMsg asicdCommonDefs.IPv4NbrMacMoveNotifyMsg
 	var msg commonDefs.AsicdNotifyMsg
 	err := json.Unmarshal(rxMsg, &macMoveMsg)
@@ -193,12 +193,12 @@ func processIPv4NbrMacMoveNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *loggi
 	return msg, err
 }
 
-func processIPv4RouteAddDelNotifyMsg(rxMsgType uint8, rxMsg []byte, logger *logging.Writer) (commonDefs.AsicdNotifyMsg, error) {
+func processIPv4RouteAddDelNotifyMsg(rxMsgType uint8, rxMsg []byte, logger logging.LoggerIntf) (commonDefs.AsicdNotifyMsg, error) {
 	var msg commonDefs.AsicdNotifyMsg
 	return msg, nil
 }
 
-func listenForASICdUpdates(address string, logger *logging.Writer) (err error) {
+func listenForASICdUpdates(address string, logger logging.LoggerIntf) (err error) {
 	if asicdSubSocket, err = nanomsg.NewSubSocket(); err != nil {
 		logger.Err(fmt.Sprintln("Failed to create ASICd subscribe socket, error:", err))
 		return err
@@ -28,6 +28,7 @@ import (
 	"asicd/pluginManager/pluginCommon"
 	"asicdInt"
 	"asicdServices"
+	"context"
 	"encoding/json"
 	"fmt"
 	"git.apache.org/thrift.git/lib/go/thrift"
@@ -55,8 +56,9 @@ type CfgFileJson struct {
 }
 
 type ClientJson struct {
-	Name string `json:Name`
-	Port int    `json:Port`
+	Name     string `json:Name`
+	Port     int    `json:Port`
+	TraceIds bool   `json:"TraceIds"` //the server takes trace IDs with the calls, see ipcutils.TraceProcessor
 }
 
 type ClientBase struct {
@@ -67,40 +69,88 @@ type ClientBase struct {
 
 type FSAsicdClientMgr struct {
 	ClientHdl *asicdServices.ASICDServicesClient
+	ctx       context.Context //context of the calls, for the trace ID in the logs
+}
+
+//copy of the client making its calls on behalf of ctx
+func (asicdClientMgr *FSAsicdClientMgr) WithContext(ctx context.Context) *FSAsicdClientMgr {
+	return &FSAsicdClientMgr{ClientHdl: asicdClientMgr.ClientHdl, ctx: ctx}
 }
 
 // need to ensure that we are go/thread safe
 var asicdmutex *sync.Mutex = &sync.Mutex{}
-var Logger *logging.Writer
+var Logger logging.LoggerIntf
 
-func (asicdClientMgr *FSAsicdClientMgr) CreateIPv4Neighbor(ipAddr, macAddr string, vlanId, ifIdx int32) (int32, error) {
+//output protocol of the asicd client, nil unless asicd takes trace IDs
+var asicdTraceProtocol *ipcutils.TraceProtocol
+
+//makes the asicd call for method, logged with the trace ID of the client context.
+//If asicd takes trace IDs, the ID is passed with the call. The calls are serialized
+//on asicdmutex, which also keeps the shared trace protocol on the ID of this call
+func (asicdClientMgr *FSAsicdClientMgr) ipcCall(method string, call func() error) error {
+	done := ipcutils.TraceIPCCall(asicdClientMgr.ctx, "asicd", method)
 	asicdmutex.Lock()
-	val, err := asicdClientMgr.ClientHdl.CreateIPv4Neighbor(ipAddr, macAddr, vlanId, ifIdx)
+	if asicdTraceProtocol != nil {
+		asicdTraceProtocol.SetTraceId(logging.TraceIdFromContext(asicdClientMgr.ctx))
+	}
+	err := call()
 	asicdmutex.Unlock()
+	done(err)
+	return err
+}
+
+func (asicdClientMgr *FSAsicdClientMgr) CreateIPv4Neighbor(ipAddr, macAddr string, vlanId, ifIdx int32) (int32, error) {
+	var val int32
+	err := asicdClientMgr.ipcCall("CreateIPv4Neighbor", func() (err error) {
+		val, err = asicdClientMgr.ClientHdl.CreateIPv4Neighbor(ipAddr, macAddr, vlanId, ifIdx)
+		return err
+	})
 	return val, err
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) UpdateIPv4Neighbor(ipAddr, macAddr string, vlanId, ifIdx int32) (int32, error) {
-	asicdmutex.Lock()
-	val, err := asicdClientMgr.ClientHdl.UpdateIPv4Neighbor(ipAddr, macAddr, vlanId, ifIdx)
-	asicdmutex.Unlock()
+	var val int32
+	err := asicdClientMgr.ipcCall("UpdateIPv4Neighbor", func() (err error) {
+		val, err = asicdClientMgr.ClientHdl.UpdateIPv4Neighbor(ipAddr, macAddr, vlanId, ifIdx)
+		return err
+	})
 	return val, err
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) DeleteIPv4Neighbor(ipAddr string) (int32, error) {
-	return asicdClientMgr.ClientHdl.DeleteIPv4Neighbor(ipAddr, "00:00:00:00:00:00", 0, 0)
+	var val int32
+	err := asicdClientMgr.ipcCall("DeleteIPv4Neighbor", func() (err error) {
+		val, err = asicdClientMgr.ClientHdl.DeleteIPv4Neighbor(ipAddr, "00:00:00:00:00:00", 0, 0)
+		return err
+	})
+	return val, err
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) CreateIPv6Neighbor(ipAddr, macAddr string, vlanId, ifIdx int32) (int32, error) {
-	return asicdClientMgr.ClientHdl.CreateIPv6Neighbor(ipAddr, macAddr, vlanId, ifIdx)
+	var val int32
+	err := asicdClientMgr.ipcCall("CreateIPv6Neighbor", func() (err error) {
+		val, err = asicdClientMgr.ClientHdl.CreateIPv6Neighbor(ipAddr, macAddr, vlanId, ifIdx)
+		return err
+	})
+	return val, err
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) UpdateIPv6Neighbor(ipAddr, macAddr string, vlanId, ifIdx int32) (int32, error) {
-	return asicdClientMgr.ClientHdl.UpdateIPv6Neighbor(ipAddr, macAddr, vlanId, ifIdx)
+	var val int32
+	err := asicdClientMgr.ipcCall("UpdateIPv6Neighbor", func() (err error) {
+		val, err = asicdClientMgr.ClientHdl.UpdateIPv6Neighbor(ipAddr, macAddr, vlanId, ifIdx)
+		return err
+	})
+	return val, err
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) DeleteIPv6Neighbor(ipAddr string) (int32, error) {
-	return asicdClientMgr.ClientHdl.DeleteIPv6Neighbor(ipAddr, "00:00:00:00:00:00", 0, 0)
+	var val int32
+	err := asicdClientMgr.ipcCall("DeleteIPv6Neighbor", func() (err error) {
+		val, err = asicdClientMgr.ClientHdl.DeleteIPv6Neighbor(ipAddr, "00:00:00:00:00:00", 0, 0)
+		return err
+	})
+	return val, err
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) convertAsicdIP4InfoToCommonInfo(info asicdServices.IPv4IntfState) *commonDefs.IPv4IntfState {
@@ -158,9 +208,11 @@ func (asicdClientMgr *FSAsicdClientMgr) convertAsicdPortStateInfoToCommonInfo(in
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) GetBulkIPv4IntfState(curMark, count int) (*commonDefs.IPv4IntfStateGetInfo, error) {
-	asicdmutex.Lock()
-	bulkInfo, err := asicdClientMgr.ClientHdl.GetBulkIPv4IntfState(asicdServices.Int(curMark), asicdServices.Int(count))
-	asicdmutex.Unlock()
+	var bulkInfo *asicdServices.IPv4IntfStateGetInfo
+	err := asicdClientMgr.ipcCall("GetBulkIPv4IntfState", func() (err error) {
+		bulkInfo, err = asicdClientMgr.ClientHdl.GetBulkIPv4IntfState(asicdServices.Int(curMark), asicdServices.Int(count))
+		return err
+	})
 	if bulkInfo == nil {
 		return nil, err
 	}
@@ -186,9 +238,11 @@ func (asicdClientMgr *FSAsicdClientMgr) GetBulkIPv4IntfState(curMark, count int)
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) GetBulkPort(curMark, count int) (*commonDefs.PortGetInfo, error) {
-	asicdmutex.Lock()
-	bulkInfo, err := asicdClientMgr.ClientHdl.GetBulkPort(asicdServices.Int(curMark), asicdServices.Int(count))
-	asicdmutex.Unlock()
+	var bulkInfo *asicdServices.PortGetInfo
+	err := asicdClientMgr.ipcCall("GetBulkPort", func() (err error) {
+		bulkInfo, err = asicdClientMgr.ClientHdl.GetBulkPort(asicdServices.Int(curMark), asicdServices.Int(count))
+		return err
+	})
 	if bulkInfo == nil {
 		return nil, err
 	}
@@ -215,9 +269,11 @@ func (asicdClientMgr *FSAsicdClientMgr) GetBulkPort(curMark, count int) (*common
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) GetBulkPortState(curMark, count int) (*commonDefs.PortStateGetInfo, error) {
-	asicdmutex.Lock()
-	bulkInfo, err := asicdClientMgr.ClientHdl.GetBulkPortState(asicdServices.Int(curMark), asicdServices.Int(count))
-	asicdmutex.Unlock()
+	var bulkInfo *asicdServices.PortStateGetInfo
+	err := asicdClientMgr.ipcCall("GetBulkPortState", func() (err error) {
+		bulkInfo, err = asicdClientMgr.ClientHdl.GetBulkPortState(asicdServices.Int(curMark), asicdServices.Int(count))
+		return err
+	})
 	if bulkInfo == nil {
 		return nil, err
 	}
@@ -252,10 +308,11 @@ func (asicdClientMgr *FSAsicdClientMgr) GetBulkPortState(curMark, count int) (*c
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) GetBulkVlanState(curMark, count int) (*commonDefs.VlanStateGetInfo, error) {
-
-	asicdmutex.Lock()
-	bulkInfo, err := asicdClientMgr.ClientHdl.GetBulkVlanState(asicdServices.Int(curMark), asicdServices.Int(count))
-	asicdmutex.Unlock()
+	var bulkInfo *asicdServices.VlanStateGetInfo
+	err := asicdClientMgr.ipcCall("GetBulkVlanState", func() (err error) {
+		bulkInfo, err = asicdClientMgr.ClientHdl.GetBulkVlanState(asicdServices.Int(curMark), asicdServices.Int(count))
+		return err
+	})
 	if bulkInfo == nil {
 		return nil, err
 	}
@@ -298,7 +355,11 @@ func (asicdClientMgr *FSAsicdClientMgr) GetAllVlanState() ([]*commonDefs.VlanSta
 	count := 100
 	vlanStateInfo := make([]*commonDefs.VlanState, 0)
 	for {
-		bulkInfo, err := asicdClientMgr.ClientHdl.GetBulkVlanState(asicdServices.Int(curMark), asicdServices.Int(count))
+		var bulkInfo *asicdServices.VlanStateGetInfo
+		err := asicdClientMgr.ipcCall("GetBulkVlanState", func() (err error) {
+			bulkInfo, err = asicdClientMgr.ClientHdl.GetBulkVlanState(asicdServices.Int(curMark), asicdServices.Int(count))
+			return err
+		})
 		if bulkInfo == nil {
 			return nil, err
 		}
@@ -318,7 +379,11 @@ func (asicdClientMgr *FSAsicdClientMgr) GetAllVlan() ([]*commonDefs.Vlan, error)
 	count := 100
 	vlanInfo := make([]*commonDefs.Vlan, 0)
 	for {
-		bulkInfo, err := asicdClientMgr.ClientHdl.GetBulkVlan(asicdInt.Int(curMark), asicdInt.Int(count))
+		var bulkInfo *asicdInt.VlanGetInfo
+		err := asicdClientMgr.ipcCall("GetBulkVlan", func() (err error) {
+			bulkInfo, err = asicdClientMgr.ClientHdl.GetBulkVlan(asicdInt.Int(curMark), asicdInt.Int(count))
+			return err
+		})
 		if bulkInfo == nil {
 			return nil, err
 		}
@@ -334,7 +399,11 @@ func (asicdClientMgr *FSAsicdClientMgr) GetAllVlan() ([]*commonDefs.Vlan, error)
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) GetBulkVlan(curMark, count int) (*commonDefs.VlanGetInfo, error) {
-	bulkInfo, err := asicdClientMgr.ClientHdl.GetBulkVlan(asicdInt.Int(curMark), asicdInt.Int(count))
+	var bulkInfo *asicdInt.VlanGetInfo
+	err := asicdClientMgr.ipcCall("GetBulkVlan", func() (err error) {
+		bulkInfo, err = asicdClientMgr.ClientHdl.GetBulkVlan(asicdInt.Int(curMark), asicdInt.Int(count))
+		return err
+	})
 	if bulkInfo == nil {
 		return nil, err
 	}
@@ -352,7 +421,7 @@ func (asicdClientMgr *FSAsicdClientMgr) GetBulkVlan(curMark, count int) (*common
 	return &vlanInfo, nil
 }
 
-func GetAsicdThriftClientHdl(paramsFile string, logger *logging.Writer) *asicdServices.ASICDServicesClient {
+func GetAsicdThriftClientHdl(paramsFile string, logger logging.LoggerIntf) *asicdServices.ASICDServicesClient {
 	var asicdClient AsicdClient
 	Logger = logger
 	logger.Debug(fmt.Sprintln("Inside connectToServers...paramsFile", paramsFile))
@@ -393,7 +462,13 @@ func GetAsicdThriftClientHdl(paramsFile string, logger *logging.Writer) *asicdSe
 
 			}
 			logger.Info("Connected to Asicd")
-			asicdClient.ClientHdl = asicdServices.NewASICDServicesClientFactory(asicdClient.Transport, asicdClient.PtrProtocolFactory)
+			if client.TraceIds {
+				asicdTraceProtocol = ipcutils.NewTraceProtocol(asicdClient.PtrProtocolFactory.GetProtocol(asicdClient.Transport))
+				asicdClient.ClientHdl = asicdServices.NewASICDServicesClientProtocol(asicdClient.Transport,
+					asicdClient.PtrProtocolFactory.GetProtocol(asicdClient.Transport), asicdTraceProtocol)
+			} else {
+				asicdClient.ClientHdl = asicdServices.NewASICDServicesClientFactory(asicdClient.Transport, asicdClient.PtrProtocolFactory)
+			}
 			return asicdClient.ClientHdl
 		}
 	}
@@ -405,7 +480,11 @@ func (asicdClientMgr *FSAsicdClientMgr) GetAllPortState() ([]*commonDefs.PortSta
 	count := 100
 	portState := make([]*commonDefs.PortState, 0)
 	for {
-		bulkInfo, err := asicdClientMgr.ClientHdl.GetBulkPortState(asicdServices.Int(curMark), asicdServices.Int(count))
+		var bulkInfo *asicdServices.PortStateGetInfo
+		err := asicdClientMgr.ipcCall("GetBulkPortState", func() (err error) {
+			bulkInfo, err = asicdClientMgr.ClientHdl.GetBulkPortState(asicdServices.Int(curMark), asicdServices.Int(count))
+			return err
+		})
 		if bulkInfo == nil {
 			return nil, err
 		}
@@ -422,7 +501,11 @@ func (asicdClientMgr *FSAsicdClientMgr) GetAllPortState() ([]*commonDefs.PortSta
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) GetPort(intfRef string) (*commonDefs.Port, error) {
-	portInfo, err := asicdClientMgr.ClientHdl.GetPort(intfRef)
+	var portInfo *asicdServices.Port
+	err := asicdClientMgr.ipcCall("GetPort", func() (err error) {
+		portInfo, err = asicdClientMgr.ClientHdl.GetPort(intfRef)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -450,8 +533,12 @@ func (asicdClientMgr *FSAsicdClientMgr) GetAllIPv6IntfState() ([]*commonDefs.IPv
 	count := 100
 	ipv6Info := make([]*commonDefs.IPv6IntfState, 0)
 	for {
-		bulkInfo, err := asicdClientMgr.ClientHdl.GetBulkIPv6IntfState(asicdServices.Int(curMark),
-			asicdServices.Int(count))
+		var bulkInfo *asicdServices.IPv6IntfStateGetInfo
+		err := asicdClientMgr.ipcCall("GetBulkIPv6IntfState", func() (err error) {
+			bulkInfo, err = asicdClientMgr.ClientHdl.GetBulkIPv6IntfState(asicdServices.Int(curMark),
+				asicdServices.Int(count))
+			return err
+		})
 		if bulkInfo == nil {
 			return nil, err
 		}
@@ -476,10 +563,12 @@ func (asicdClientMgr *FSAsicdClientMgr) GetAllIPv4IntfState() ([]*commonDefs.IPv
 	count := 100
 	ipv4Info := make([]*commonDefs.IPv4IntfState, 0)
 	for {
-		asicdmutex.Lock()
-		bulkInfo, err := asicdClientMgr.ClientHdl.GetBulkIPv4IntfState(asicdServices.Int(curMark),
-			asicdServices.Int(count))
-		asicdmutex.Unlock()
+		var bulkInfo *asicdServices.IPv4IntfStateGetInfo
+		err := asicdClientMgr.ipcCall("GetBulkIPv4IntfState", func() (err error) {
+			bulkInfo, err = asicdClientMgr.ClientHdl.GetBulkIPv4IntfState(asicdServices.Int(curMark),
+				asicdServices.Int(count))
+			return err
+		})
 		if bulkInfo == nil {
 			return nil, err
 		}
@@ -504,9 +593,7 @@ func (asicdClientMgr *FSAsicdClientMgr) GetAllIPv4IntfState() ([]*commonDefs.IPv
  */
 func (asicdClientMgr *FSAsicdClientMgr) DetermineRouterId() string {
 	rtrId := "0.0.0.0"
-	asicdmutex.Lock()
 	allipv4Intfs, err := asicdClientMgr.GetAllIPv4IntfState()
-	asicdmutex.Unlock()
 	if err != nil {
 		return rtrId
 	}
@@ -565,9 +652,11 @@ func asicDPortBmpFormatGet(distPortList []string) string {
 func (asicdClientMgr *FSAsicdClientMgr) GetPortLinkStatus(pId int32) bool {
 
 	if asicdClientMgr.ClientHdl != nil {
-		asicdmutex.Lock()
-		bulkInfo, err := asicdClientMgr.ClientHdl.GetBulkPortState(asicdServices.Int(asicdCommonDefs.MIN_SYS_PORTS), asicdServices.Int(asicdCommonDefs.MAX_SYS_PORTS))
-		asicdmutex.Unlock()
+		var bulkInfo *asicdServices.PortStateGetInfo
+		err := asicdClientMgr.ipcCall("GetBulkPortState", func() (err error) {
+			bulkInfo, err = asicdClientMgr.ClientHdl.GetBulkPortState(asicdServices.Int(asicdCommonDefs.MIN_SYS_PORTS), asicdServices.Int(asicdCommonDefs.MAX_SYS_PORTS))
+			return err
+		})
 		if err == nil && bulkInfo.Count != 0 {
 			objCount := int64(bulkInfo.Count)
 			for i := int64(0); i < objCount; i++ {
@@ -591,7 +680,11 @@ func (asicdClientMgr *FSAsicdClientMgr) CreateStgBridge(vlanList []uint16) int32
 		}
 		//asicdmutex.Lock()
 		// default vlan is already created in opennsl
-		stgid, err := asicdClientMgr.ClientHdl.CreateStg(vl)
+		var stgid int32
+		err := asicdClientMgr.ipcCall("CreateStg", func() (err error) {
+			stgid, err = asicdClientMgr.ClientHdl.CreateStg(vl)
+			return err
+		})
 		//asicdmutex.Unlock()
 		if err == nil {
 			for _, v := range vl {
@@ -602,9 +695,10 @@ func (asicdClientMgr *FSAsicdClientMgr) CreateStgBridge(vlanList []uint16) int32
 						MacAddrMask: "FF:FF:FF:FF:FF:FF",
 						VlanId:      int32(v),
 					}
-					asicdmutex.Lock()
-					asicdClientMgr.ClientHdl.EnablePacketReception(&protocolmac)
-					asicdmutex.Unlock()
+					asicdClientMgr.ipcCall("EnablePacketReception", func() (err error) {
+						_, err = asicdClientMgr.ClientHdl.EnablePacketReception(&protocolmac)
+						return err
+					})
 				}
 			}
 			return stgid
@@ -635,15 +729,19 @@ func (asicdClientMgr *FSAsicdClientMgr) DeleteStgBridge(stgid int32, vlanList []
 				}
 
 				Logger.Info(fmt.Sprintf("Deleting PVST MAC entry %#v", protocolmac))
-				asicdmutex.Lock()
-				asicdClientMgr.ClientHdl.DisablePacketReception(&protocolmac)
-				asicdmutex.Unlock()
+				asicdClientMgr.ipcCall("DisablePacketReception", func() (err error) {
+					_, err = asicdClientMgr.ClientHdl.DisablePacketReception(&protocolmac)
+					return err
+				})
 			}
 		}
 		Logger.Info(fmt.Sprintf("Deleting Stg Group %d with vlans %#v", stgid, vl))
 
 		//asicdmutex.Lock()
-		_, err := asicdClientMgr.ClientHdl.DeleteStg(stgid)
+		err := asicdClientMgr.ipcCall("DeleteStg", func() (err error) {
+			_, err = asicdClientMgr.ClientHdl.DeleteStg(stgid)
+			return err
+		})
 		//asicdmutex.Unlock()
 		if err != nil {
 			return err
@@ -654,20 +752,20 @@ func (asicdClientMgr *FSAsicdClientMgr) DeleteStgBridge(stgid int32, vlanList []
 
 func (asicdClientMgr *FSAsicdClientMgr) SetStgPortState(stgid int32, ifindex int32, state int) error {
 	if asicdClientMgr.ClientHdl != nil {
-		asicdmutex.Lock()
-		_, err := asicdClientMgr.ClientHdl.SetPortStpState(stgid, ifindex, int32(state))
-		asicdmutex.Unlock()
-		return err
+		return asicdClientMgr.ipcCall("SetPortStpState", func() (err error) {
+			_, err = asicdClientMgr.ClientHdl.SetPortStpState(stgid, ifindex, int32(state))
+			return err
+		})
 	}
 	return nil
 }
 
 func (asicdClientMgr *FSAsicdClientMgr) FlushStgFdb(stgid int32) error {
 	if asicdClientMgr.ClientHdl != nil {
-		asicdmutex.Lock()
-		_, err := asicdClientMgr.ClientHdl.FlushFdbStgGroup(stgid)
-		asicdmutex.Unlock()
-		return err
+		return asicdClientMgr.ipcCall("FlushFdbStgGroup", func() (err error) {
+			_, err = asicdClientMgr.ClientHdl.FlushFdbStgGroup(stgid)
+			return err
+		})
 	}
 	return nil
 }
@@ -678,10 +776,10 @@ func (asicdClientMgr *FSAsicdClientMgr) BPDUGuardDetected(ifindex int32, enable
 		if enable {
 			state = "UP"
 		}
-		asicdmutex.Lock()
-		_, err := asicdClientMgr.ClientHdl.ErrorDisablePort(ifindex, state, "STP BPDU GUARD")
-		asicdmutex.Unlock()
-		return err
+		return asicdClientMgr.ipcCall("ErrorDisablePort", func() (err error) {
+			_, err = asicdClientMgr.ClientHdl.ErrorDisablePort(ifindex, state, "STP BPDU GUARD")
+			return err
+		})
 	}
 	return nil
 }
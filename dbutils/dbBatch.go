@@ -0,0 +1,182 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// dbBatch.go
+package dbutils
+
+import (
+	"errors"
+	"fmt"
+	"github.com/garyburd/redigo/redis"
+	"models/objects"
+)
+
+type batchCmd struct {
+	cmd  string
+	args []interface{}
+}
+
+//commands queued locally and sent in one round trip by Flush. An atomic batch is wrapped in
+//MULTI/EXEC so that readers never see part of it applied. The object writes are recorded from
+//the object methods, objects whose writes depend on the replies of reads can't be batched
+type Batch struct {
+	db     *DBUtil
+	atomic bool
+	cmds   []batchCmd
+}
+
+func (db *DBUtil) NewBatch(atomic bool) *Batch {
+	return &Batch{db: db, atomic: atomic}
+}
+
+func (b *Batch) Send(cmd string, args ...interface{}) {
+	b.cmds = append(b.cmds, batchCmd{cmd, args})
+}
+
+func (b *Batch) StoreObject(obj objects.ConfigObj) error {
+	return obj.StoreObjectInDb(batchConn{b})
+}
+
+func (b *Batch) DeleteObject(obj objects.ConfigObj) error {
+	return obj.DeleteObjectFromDb(batchConn{b})
+}
+
+//replaces the stored object, the attributes not set in obj are removed
+func (b *Batch) ReplaceObject(obj objects.ConfigObj) error {
+	if err := b.DeleteObject(obj); err != nil {
+		return err
+	}
+	return b.StoreObject(obj)
+}
+
+//number of queued commands
+func (b *Batch) Len() int {
+	return len(b.cmds)
+}
+
+func (b *Batch) Reset() {
+	b.cmds = nil
+}
+
+//sends the queued commands and returns their replies. The batch is emptied, also on failure.
+//An error reply fails the flush. A command rejected when queued aborts an atomic batch, a command
+//failing in EXEC doesn't roll back the others, as with any Redis transaction
+func (b *Batch) Flush() ([]interface{}, error) {
+	cmds := b.cmds
+	b.cmds = nil
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	conn, err := b.db.GetConn()
+	if err != nil {
+		return nil, err
+	}
+	defer b.db.PutConn(conn)
+	if b.atomic {
+		if err = conn.Send("MULTI"); err != nil {
+			return nil, err
+		}
+	}
+	for _, cmd := range cmds {
+		if err = conn.Send(cmd.cmd, cmd.args...); err != nil {
+			return nil, err
+		}
+	}
+	var reply interface{}
+	if b.atomic {
+		reply, err = conn.Do("EXEC")
+	} else {
+		reply, err = conn.Do("")
+	}
+	if err != nil {
+		return nil, err
+	}
+	replies, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	for idx, reply := range replies {
+		if replyErr, ok := reply.(redis.Error); ok {
+			return replies, errors.New(fmt.Sprintln("Batch command", cmds[idx].cmd, "failed:", replyErr))
+		}
+	}
+	return replies, nil
+}
+
+//records the commands of the object methods in the batch
+type batchConn struct {
+	b *Batch
+}
+
+func (c batchConn) Close() error {
+	return nil
+}
+
+func (c batchConn) Err() error {
+	return nil
+}
+
+//the command is queued, there is no reply until the batch is flushed
+func (c batchConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "" {
+		c.b.Send(cmd, args...)
+	}
+	return nil, nil
+}
+
+func (c batchConn) Send(cmd string, args ...interface{}) error {
+	c.b.Send(cmd, args...)
+	return nil
+}
+
+func (c batchConn) Flush() error {
+	return nil
+}
+
+func (c batchConn) Receive() (interface{}, error) {
+	return nil, errors.New("Replies of batched commands are returned by Batch.Flush")
+}
+
+//stores the objects in one round trip, readers see either none or all of them
+func (db *DBUtil) StoreObjectsInDb(objs []objects.ConfigObj) error {
+	batch := db.NewBatch(true)
+	for _, obj := range objs {
+		if err := batch.StoreObject(obj); err != nil {
+			return err
+		}
+	}
+	_, err := batch.Flush()
+	return err
+}
+
+//deletes the objects in one round trip, readers see either none or all of them deleted
+func (db *DBUtil) DeleteObjectsFromDb(objs []objects.ConfigObj) error {
+	batch := db.NewBatch(true)
+	for _, obj := range objs {
+		if err := batch.DeleteObject(obj); err != nil {
+			return err
+		}
+	}
+	_, err := batch.Flush()
+	return err
+}
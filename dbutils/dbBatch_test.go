@@ -0,0 +1,152 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package dbutils
+
+import (
+	"context"
+	"github.com/garyburd/redigo/redis"
+	"models/objects"
+	"reflect"
+	"testing"
+)
+
+//state object writing itself as a hash, as the generated objects do
+type testStateObj struct {
+	objects.ConfigObj
+	Name  string
+	Speed int
+}
+
+func (obj testStateObj) GetKey() string {
+	return "TestState#" + obj.Name
+}
+
+func (obj testStateObj) StoreObjectInDb(dbHdl redis.Conn) error {
+	_, err := dbHdl.Do("HMSET", obj.GetKey(), "Name", obj.Name, "Speed", obj.Speed)
+	return err
+}
+
+func (obj testStateObj) DeleteObjectFromDb(dbHdl redis.Conn) error {
+	_, err := dbHdl.Do("DEL", obj.GetKey())
+	return err
+}
+
+func connectTestDBUtil(t *testing.T, server *fakeRedis) *DBUtil {
+	db := newTestDBUtil(server.address)
+	if err := db.ConnectContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+//commands received after the connect PING
+func batchCommands(server *fakeRedis) []string {
+	cmds := server.commands()
+	for idx, cmd := range cmds {
+		if cmd == "PING" {
+			continue
+		}
+		return cmds[idx:]
+	}
+	return nil
+}
+
+func TestStoreObjectsInDbIsOneTransaction(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	defer server.stop()
+	db := connectTestDBUtil(t, server)
+	defer db.Disconnect()
+
+	objs := []objects.ConfigObj{testStateObj{Name: "fpPort1", Speed: 10}, testStateObj{Name: "fpPort2", Speed: 40}}
+	if err := db.StoreObjectsInDb(objs); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"MULTI",
+		"HMSET TestState#fpPort1 Name fpPort1 Speed 10",
+		"HMSET TestState#fpPort2 Name fpPort2 Speed 40",
+		"EXEC",
+	}
+	if cmds := batchCommands(server); !reflect.DeepEqual(cmds, expected) {
+		t.Fatal("Expected", expected, "got", cmds)
+	}
+}
+
+func TestBatchReplaceObjectAndPipeline(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	defer server.stop()
+	db := connectTestDBUtil(t, server)
+	defer db.Disconnect()
+
+	batch := db.NewBatch(false)
+	batch.ReplaceObject(testStateObj{Name: "fpPort1", Speed: 100})
+	batch.Send("PUBLISH", "state", "fpPort1")
+	if batch.Len() != 3 {
+		t.Fatal("Expected 3 queued commands, got", batch.Len())
+	}
+	replies, err := batch.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 3 || batch.Len() != 0 {
+		t.Fatal("Expected 3 replies and an empty batch, got", replies, batch.Len())
+	}
+	expected := []string{
+		"DEL TestState#fpPort1",
+		"HMSET TestState#fpPort1 Name fpPort1 Speed 100",
+		"PUBLISH state fpPort1",
+	}
+	if cmds := batchCommands(server); !reflect.DeepEqual(cmds, expected) {
+		t.Fatal("Expected", expected, "got", cmds)
+	}
+	if replies, err := batch.Flush(); replies != nil || err != nil {
+		t.Fatal("Expected flushing an empty batch to do nothing, got", replies, err)
+	}
+}
+
+func TestBatchFlushReportsErrorReplies(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	defer server.stop()
+	db := connectTestDBUtil(t, server)
+	defer db.Disconnect()
+
+	for _, atomic := range []bool{true, false} {
+		batch := db.NewBatch(atomic)
+		batch.StoreObject(testStateObj{Name: "fpPort1"})
+		batch.Send("FAIL")
+		if _, err := batch.Flush(); err == nil {
+			t.Fatal("Expected the error reply to fail the flush, atomic", atomic)
+		}
+	}
+	if !db.IsConnected() {
+		t.Fatal("Expected an error reply to keep the connection up")
+	}
+}
+
+func TestBatchFailsWhenNotConnected(t *testing.T) {
+	db := newTestDBUtil(closedAddress(t))
+	if err := db.StoreObjectsInDb([]objects.ConfigObj{testStateObj{Name: "fpPort1"}}); err == nil {
+		t.Fatal("Expected an error before connecting")
+	}
+}
@@ -0,0 +1,79 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// dbChannels.go
+package dbutils
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"time"
+)
+
+const (
+	DB_CHANNEL_PING_INTERVAL = 30 * time.Second             //the channel subscriptions are pinged this often
+	DB_CHANNEL_PING_TIMEOUT  = 3 * DB_CHANNEL_PING_INTERVAL //no reply to the pings for this long means the DB is gone
+)
+
+//passes the messages published on the channels matching the glob style pattern to handler, until
+//stopCh is closed or the connection fails. It doesn't subscribe again, the caller does after an error
+func (db *DBUtil) PSubscribeChannels(pattern string, stopCh <-chan bool, handler func(channel string, msg []byte)) error {
+	conn, err := db.config.Dial()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err = psc.PSubscribe(pattern); err != nil {
+		return err
+	}
+	doneCh := make(chan bool)
+	defer close(doneCh)
+	go func() {
+		ticker := time.NewTicker(DB_CHANNEL_PING_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				psc.Ping("")
+			case <-stopCh:
+				psc.Close()
+				return
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+	for {
+		switch reply := psc.ReceiveWithTimeout(DB_CHANNEL_PING_TIMEOUT).(type) {
+		case redis.PMessage:
+			handler(reply.Channel, reply.Data)
+		case error:
+			select {
+			case <-stopCh:
+				return nil
+			default:
+				return reply
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package dbutils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPSubscribeChannels(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	defer server.stop()
+	server.config["notify-keyspace-events"] = "KA"
+	db := connectTestDBUtil(t, server)
+	defer db.Disconnect()
+
+	msgs := make(chan string, 16)
+	stopCh := make(chan bool)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- db.PSubscribeChannels("[^_]*", stopCh, func(channel string, msg []byte) {
+			msgs <- channel + " " + string(msg)
+		})
+	}()
+	for subscribed := false; !subscribed; time.Sleep(10 * time.Millisecond) {
+		for _, cmd := range server.commands() {
+			subscribed = subscribed || strings.HasPrefix(cmd, "PSUBSCRIBE")
+		}
+	}
+	db.StoreObjectInDb(testStateObj{Name: "fpPort1", Speed: 10})
+	db.Publish("PUBLISH", "ASICD", "event")
+	expectChanges(t, msgs, "ASICD event")
+	expectNoChange(t, msgs)
+	close(stopCh)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal("Unexpected error after stopping", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The subscription didn't stop")
+	}
+}
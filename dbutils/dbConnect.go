@@ -0,0 +1,175 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// dbConnect.go
+package dbutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/garyburd/redigo/redis"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	DB_CONNECT_MIN_BACKOFF = 100 * time.Millisecond
+	DB_CONNECT_MAX_BACKOFF = DB_CONNECT_TIME_INTERVAL * time.Second
+)
+
+//returned by ConnectContext when the context ends before the DB answers
+type DBConnectError struct {
+	network  string
+	address  string
+	Attempts int
+	LastErr  error //failure of the last attempt
+	Err      error //error of the context, context.DeadlineExceeded or context.Canceled
+}
+
+func (e DBConnectError) Error() string {
+	return fmt.Sprintf("Failed to connect to DB at %s%s after %d attempts: %v (last error: %v)",
+		e.network, e.address, e.Attempts, e.Err, e.LastErr)
+}
+
+//connects to the DB, retrying with an exponential backoff until the DB answers or the context ends
+func (db *DBUtil) ConnectContext(ctx context.Context) error {
+	if db.IsConnected() {
+		return nil
+	}
+	db.stateLock.Lock()
+	if db.pool == nil {
+		db.pool = db.newPool()
+	}
+	db.stateLock.Unlock()
+	backoff := DB_CONNECT_MIN_BACKOFF
+	retryCount := 0
+	for {
+		retryCount += 1
+		err := db.ping(ctx)
+		if err == nil {
+			db.stateLock.Lock()
+			if db.stopCh == nil {
+				db.stopCh = make(chan bool)
+			}
+			db.Conn = &pooledConn{db}
+			db.stateLock.Unlock()
+			db.connUp()
+			return nil
+		}
+		if retryCount%DB_CONNECT_RETRY_LOG_COUNT == 0 && db.logger != nil {
+			db.logger.Err(fmt.Sprintln("Failed to dial out to Redis server. Retrying connection. Num retries = ", retryCount, "err:", err))
+		}
+		select {
+		case <-ctx.Done():
+			connectErr := DBConnectError{db.network, db.address, retryCount, err, ctx.Err()}
+			if db.logger != nil {
+				db.logger.Err(connectErr.Error())
+			}
+			return connectErr
+		case <-time.After(jitter(backoff)):
+		}
+		backoff = nextBackoff(backoff, DB_CONNECT_MAX_BACKOFF)
+	}
+}
+
+//closed once the DB is connected. A new channel is returned after the connection is lost
+func (db *DBUtil) Ready() <-chan struct{} {
+	db.stateLock.Lock()
+	defer db.stateLock.Unlock()
+	return db.readyChan()
+}
+
+//waits until the DB is connected or the context ends
+func (db *DBUtil) WaitReady(ctx context.Context) error {
+	select {
+	case <-db.Ready():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//called with the state lock held
+func (db *DBUtil) readyChan() chan struct{} {
+	if db.readyCh == nil {
+		db.readyCh = make(chan struct{})
+	}
+	return db.readyCh
+}
+
+//called with the state lock held, on the transitions of connected
+func (db *DBUtil) setReady(ready bool) {
+	readyCh := db.readyChan()
+	select {
+	case <-readyCh:
+		if !ready {
+			db.readyCh = make(chan struct{})
+		}
+	default:
+		if ready {
+			close(readyCh)
+		}
+	}
+}
+
+//dials a connection of its own and checks that the DB answers, the dial gives up when the
+//context ends
+func (db *DBUtil) ping(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: db.config.DialTimeout, KeepAlive: 5 * time.Minute}
+	conn, err := db.dial(true, redis.DialNetDial(func(network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	//ping to ensure that that the server is up and running
+	//this is the suggested way to determine that redis is 'ready'
+	response, err := redis.String(conn.Do("PING"))
+	if err != nil {
+		return err
+	}
+	if response != "PONG" {
+		return errors.New(fmt.Sprintf("Unexpected response from Redis server %#v", response))
+	}
+	return nil
+}
+
+func nextBackoff(backoff, max time.Duration) time.Duration {
+	if backoff *= 2; backoff > max {
+		return max
+	}
+	return backoff
+}
+
+//a random duration between half the backoff and the backoff, so that the daemons restarted
+//together don't retry in lockstep
+func jitter(backoff time.Duration) time.Duration {
+	half := int64(backoff / 2)
+	if half <= 0 {
+		return backoff
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
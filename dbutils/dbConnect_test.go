@@ -0,0 +1,140 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package dbutils
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+//address nothing listens on
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+func TestConnectContextGivesUpAtDeadline(t *testing.T) {
+	db := newTestDBUtil(closedAddress(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := db.ConnectContext(ctx)
+	if err == nil {
+		t.Fatal("Expected an error with nothing listening")
+	}
+	connectErr, ok := err.(DBConnectError)
+	if !ok {
+		t.Fatalf("Expected a DBConnectError, got %T %v", err, err)
+	}
+	if connectErr.Err != context.DeadlineExceeded || connectErr.Attempts < 2 || connectErr.LastErr == nil {
+		t.Fatal("Unexpected error", connectErr)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatal("Connect returned", elapsed, "after the deadline")
+	}
+	if db.IsConnected() {
+		t.Fatal("Expected the DB not to be connected")
+	}
+	if _, err := db.GetValFromDB("key", "field"); err == nil {
+		t.Fatal("Expected the calls to fail before connecting")
+	}
+}
+
+func TestConnectContextCanceled(t *testing.T) {
+	db := newTestDBUtil(closedAddress(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+	}()
+	err := db.ConnectContext(ctx)
+	if connectErr, ok := err.(DBConnectError); !ok || connectErr.Err != context.Canceled {
+		t.Fatal("Expected the connect to be canceled, got", err)
+	}
+}
+
+func TestReadyClosedOnceConnected(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	defer server.stop()
+	db := newTestDBUtil(server.address)
+	ready := db.Ready()
+	select {
+	case <-ready:
+		t.Fatal("Ready before connecting")
+	default:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	if err := db.WaitReady(ctx); err != context.DeadlineExceeded {
+		t.Fatal("Expected the wait to time out, got", err)
+	}
+	cancel()
+
+	waitErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		waitErr <- db.WaitReady(ctx)
+	}()
+	if err := db.ConnectContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Disconnect()
+	if err := <-waitErr; err != nil {
+		t.Fatal("Wait failed:", err)
+	}
+	select {
+	case <-ready:
+	default:
+		t.Fatal("Expected the channel returned before connecting to be closed")
+	}
+	if err := db.ConnectContext(context.Background()); err != nil {
+		t.Fatal("Expected connecting again to be a no-op, got", err)
+	}
+
+	db.Disconnect()
+	select {
+	case <-db.Ready():
+		t.Fatal("Ready after disconnecting")
+	default:
+	}
+}
+
+func TestJitterStaysWithinBackoff(t *testing.T) {
+	backoff := 200 * time.Millisecond
+	for i := 0; i < 100; i++ {
+		if d := jitter(backoff); d < backoff/2 || d > backoff {
+			t.Fatal("Jitter", d, "out of range for", backoff)
+		}
+	}
+	if nextBackoff(backoff, 300*time.Millisecond) != 300*time.Millisecond {
+		t.Fatal("Expected the backoff to be capped")
+	}
+}
@@ -0,0 +1,360 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// dbPool.go
+package dbutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/garyburd/redigo/redis"
+	"time"
+)
+
+const (
+	DB_POOL_MAX_IDLE         = 8
+	DB_POOL_MAX_ACTIVE       = 64
+	DB_POOL_IDLE_TIMEOUT     = 4 * time.Minute
+	DB_HEALTH_CHECK_INTERVAL = 30 * time.Second //idle connections older than this are pinged before use
+	DB_RECONNECT_MIN_BACKOFF = 100 * time.Millisecond
+	DB_RECONNECT_MAX_BACKOFF = 10 * time.Second
+)
+
+var (
+	errNoPendingReply = errors.New("Receive without a pending reply, the commands are sent with Send")
+	errStaleConn      = errors.New("Connection dialed before the DB connection was lost")
+)
+
+//pooled connection tagged with the connection generation it was dialed in
+type genConn struct {
+	redis.Conn
+	gen int
+}
+
+//redis.DoWithTimeout and redis.ReceiveWithTimeout need the methods of the dialed connection
+func (c *genConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+}
+
+func (c *genConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
+
+//called when the connection to the DB is lost and when it is back, so that the daemon can resync
+type DBConnStateFunc func(connected bool)
+
+func (db *DBUtil) SetConnStateFunc(connStateFunc DBConnStateFunc) {
+	db.stateLock.Lock()
+	db.connStateFunc = connStateFunc
+	db.stateLock.Unlock()
+}
+
+func (db *DBUtil) IsConnected() bool {
+	db.stateLock.Lock()
+	defer db.stateLock.Unlock()
+	return db.connected
+}
+
+//timeout of the DB calls in place of the read and write timeouts of the config, applied to the
+//connections dialed afterwards
+func (db *DBUtil) SetCallTimeout(timeout time.Duration) {
+	db.stateLock.Lock()
+	db.callTimeout = timeout
+	db.stateLock.Unlock()
+}
+
+func (db *DBUtil) newPool() *redis.Pool {
+	return &redis.Pool{
+		Dial:        func() (redis.Conn, error) { return db.dial(false) },
+		MaxIdle:     DB_POOL_MAX_IDLE,
+		MaxActive:   DB_POOL_MAX_ACTIVE,
+		IdleTimeout: DB_POOL_IDLE_TIMEOUT,
+		Wait:        true,
+		TestOnBorrow: func(conn redis.Conn, t time.Time) error {
+			//connections dialed before the DB was lost are dead
+			if c, ok := conn.(*genConn); ok && c.gen != db.connGeneration() {
+				return errStaleConn
+			}
+			if time.Since(t) < DB_HEALTH_CHECK_INTERVAL {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+}
+
+//dials a connection for the pool. While the DB is down only the reconnect loop dials, the
+//calls fail at once instead of each waiting for the connect timeout
+func (db *DBUtil) dial(probe bool, options ...redis.DialOption) (redis.Conn, error) {
+	db.stateLock.Lock()
+	reconnecting := db.reconnecting
+	timeout := db.callTimeout
+	gen := db.connGen
+	db.stateLock.Unlock()
+	if reconnecting && !probe {
+		return nil, DBNotConnectedError{db.network, db.address}
+	}
+	if timeout > 0 {
+		options = append(options, redis.DialReadTimeout(timeout), redis.DialWriteTimeout(timeout))
+	}
+	conn, err := db.config.Dial(options...)
+	if err != nil {
+		if !probe {
+			db.connLost(err)
+		}
+		return nil, err
+	}
+	return &genConn{conn, gen}, nil
+}
+
+func (db *DBUtil) connGeneration() int {
+	db.stateLock.Lock()
+	defer db.stateLock.Unlock()
+	return db.connGen
+}
+
+//connection of the pool, to be returned with PutConn. Meant for the calls needing a connection
+//of their own, pipelines and transactions
+func (db *DBUtil) GetConn() (redis.Conn, error) {
+	db.stateLock.Lock()
+	pool := db.pool
+	db.stateLock.Unlock()
+	if pool == nil {
+		return nil, DBNotConnectedError{db.network, db.address}
+	}
+	conn := pool.Get()
+	if err := conn.Err(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (db *DBUtil) PutConn(conn redis.Conn) {
+	if err := conn.Err(); err != nil {
+		db.connLost(err)
+	}
+	conn.Close()
+}
+
+//runs a command with a timeout other than the call timeout, e.g. for the blocking commands
+func (db *DBUtil) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	conn, err := db.GetConn()
+	if err != nil {
+		return nil, err
+	}
+	defer db.PutConn(conn)
+	return redis.DoWithTimeout(conn, timeout, cmd, args...)
+}
+
+func (db *DBUtil) connLost(err error) {
+	db.stateLock.Lock()
+	if db.reconnecting || db.stopCh == nil {
+		db.stateLock.Unlock()
+		return
+	}
+	wasConnected := db.connected
+	db.connected = false
+	db.setReady(false)
+	db.reconnecting = true
+	db.connGen++
+	connStateFunc := db.connStateFunc
+	stopCh := db.stopCh
+	db.stateLock.Unlock()
+	if db.logger != nil {
+		db.logger.Err(fmt.Sprintln("Lost the connection to the DB at", db.network, db.address, "err:", err))
+	}
+	if wasConnected && connStateFunc != nil {
+		connStateFunc(false)
+	}
+	go db.reconnect(stopCh)
+}
+
+//pings the DB with an exponential backoff until it answers
+func (db *DBUtil) reconnect(stopCh chan bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	backoff := DB_RECONNECT_MIN_BACKOFF
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jitter(backoff)):
+		}
+		if db.ping(ctx) == nil {
+			db.connUp()
+			return
+		}
+		backoff = nextBackoff(backoff, DB_RECONNECT_MAX_BACKOFF)
+	}
+}
+
+func (db *DBUtil) connUp() {
+	db.stateLock.Lock()
+	wasConnected := db.connected
+	db.connected = true
+	db.setReady(true)
+	db.reconnecting = false
+	connStateFunc := db.connStateFunc
+	db.stateLock.Unlock()
+	if db.logger != nil {
+		db.logger.Info(fmt.Sprintln("Connected to the DB at", db.network, db.address))
+	}
+	if !wasConnected && connStateFunc != nil {
+		connStateFunc(true)
+	}
+}
+
+//connection running the calls on the pool, nil until connected
+func (db *DBUtil) conn() redis.Conn {
+	db.stateLock.Lock()
+	defer db.stateLock.Unlock()
+	return db.Conn
+}
+
+//runs a command on a connection of the pool, in place of the Do of the embedded Conn that is
+//replaced when connecting and disconnecting
+func (db *DBUtil) Do(cmd string, args ...interface{}) (interface{}, error) {
+	conn := db.conn()
+	if conn == nil {
+		return nil, DBNotConnectedError{db.network, db.address}
+	}
+	return conn.Do(cmd, args...)
+}
+
+//connection of the pool pinned by the first Send on the DB handle, it goes back to the pool once
+//the replies of the commands sent are received or it fails. Returns nil if none is pinned and
+//send is false
+func (db *DBUtil) pinnedConn(send bool) (redis.Conn, error) {
+	db.pipeLock.Lock()
+	defer db.pipeLock.Unlock()
+	if db.pipeConn == nil {
+		if !send {
+			return nil, nil
+		}
+		conn, err := db.GetConn()
+		if err != nil {
+			return nil, err
+		}
+		db.pipeConn = conn
+	}
+	if send {
+		db.pipePending++
+	}
+	return db.pipeConn, nil
+}
+
+func (db *DBUtil) unpinConn(conn redis.Conn, received int) {
+	db.pipeLock.Lock()
+	if db.pipeConn != conn {
+		//released by Disconnect
+		db.pipeLock.Unlock()
+		return
+	}
+	db.pipePending -= received
+	if db.pipePending > 0 && conn.Err() == nil {
+		db.pipeLock.Unlock()
+		return
+	}
+	db.pipeConn = nil
+	db.pipePending = 0
+	db.pipeLock.Unlock()
+	db.PutConn(conn)
+}
+
+func (db *DBUtil) releasePinnedConn() {
+	db.pipeLock.Lock()
+	conn := db.pipeConn
+	db.pipeConn = nil
+	db.pipePending = 0
+	db.pipeLock.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+//runs each call on a connection of the pool, set as the embedded redis.Conn of DBUtil. Send,
+//Flush and Receive share the pinned connection, a pipeline is not meant to be shared by several
+//goroutines more than on a single connection
+type pooledConn struct {
+	db *DBUtil
+}
+
+func (c *pooledConn) Close() error {
+	return nil
+}
+
+func (c *pooledConn) Err() error {
+	if !c.db.IsConnected() {
+		return DBNotConnectedError{c.db.network, c.db.address}
+	}
+	return nil
+}
+
+func (c *pooledConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	conn, err := c.db.GetConn()
+	if err != nil {
+		return nil, err
+	}
+	defer c.db.PutConn(conn)
+	return conn.Do(cmd, args...)
+}
+
+func (c *pooledConn) Send(cmd string, args ...interface{}) error {
+	conn, err := c.db.pinnedConn(true)
+	if err != nil {
+		return err
+	}
+	err = conn.Send(cmd, args...)
+	c.db.unpinConn(conn, 0)
+	return err
+}
+
+func (c *pooledConn) Flush() error {
+	conn, err := c.db.pinnedConn(false)
+	if conn == nil {
+		return err
+	}
+	err = conn.Flush()
+	c.db.unpinConn(conn, 0)
+	return err
+}
+
+func (c *pooledConn) Receive() (interface{}, error) {
+	conn, _ := c.db.pinnedConn(false)
+	if conn == nil {
+		return nil, errNoPendingReply
+	}
+	reply, err := conn.Receive()
+	c.db.unpinConn(conn, 1)
+	return reply, err
+}
@@ -0,0 +1,420 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package dbutils
+
+import (
+	"bufio"
+	"github.com/garyburd/redigo/redis"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"utils/dbutils/redisConfig"
+)
+
+//in memory server of the hash, key and pub/sub commands used by DBUtil. FAIL is answered with
+//an error and the commands it doesn't know with OK. The commands between MULTI and EXEC are
+//queued and run by EXEC. The keyspace notifications are published when enabled
+type fakeRedis struct {
+	address  string
+	listener net.Listener
+	lock     sync.Mutex
+	conns    []*fakeConn
+	cmds     []string
+	hashes   map[string]map[string]string
+	config   map[string]string
+}
+
+type fakeConn struct {
+	net.Conn
+	writeLock sync.Mutex
+	patterns  []string
+}
+
+func (conn *fakeConn) write(reply string) {
+	conn.writeLock.Lock()
+	conn.Write([]byte(reply))
+	conn.writeLock.Unlock()
+}
+
+func newFakeRedis(t *testing.T, address string) *fakeRedis {
+	server := &fakeRedis{
+		address: address,
+		hashes:  make(map[string]map[string]string),
+		config:  map[string]string{"notify-keyspace-events": ""},
+	}
+	server.restart(t)
+	return server
+}
+
+//listens again on the same address, the stored keys are kept
+func (server *fakeRedis) restart(t *testing.T) {
+	listener, err := net.Listen("tcp", server.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.listener = listener
+	server.address = listener.Addr().String()
+	go server.serve(listener)
+}
+
+func (server *fakeRedis) serve(listener net.Listener) {
+	for {
+		netConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn := &fakeConn{Conn: netConn}
+		server.lock.Lock()
+		server.conns = append(server.conns, conn)
+		server.lock.Unlock()
+		go server.serveConn(conn)
+	}
+}
+
+func (server *fakeRedis) serveConn(conn *fakeConn) {
+	reader := bufio.NewReader(conn)
+	var queued [][]string
+	inMulti := false
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		server.lock.Lock()
+		server.cmds = append(server.cmds, strings.Join(args, " "))
+		reply := ""
+		switch {
+		case cmd == "MULTI":
+			inMulti = true
+			queued = nil
+			reply = "+OK\r\n"
+		case cmd == "EXEC":
+			reply = "*" + strconv.Itoa(len(queued)) + "\r\n"
+			for _, queuedArgs := range queued {
+				reply += server.exec(conn, queuedArgs)
+			}
+			inMulti = false
+		case inMulti:
+			queued = append(queued, args)
+			reply = "+QUEUED\r\n"
+		default:
+			reply = server.exec(conn, args)
+		}
+		server.lock.Unlock()
+		conn.write(reply)
+	}
+}
+
+func bulkArray(vals ...string) string {
+	reply := "*" + strconv.Itoa(len(vals)) + "\r\n"
+	for _, val := range vals {
+		reply += "$" + strconv.Itoa(len(val)) + "\r\n" + val + "\r\n"
+	}
+	return reply
+}
+
+//called with the server lock held
+func (server *fakeRedis) exec(conn *fakeConn, args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "FAIL":
+		return "-ERR failed\r\n"
+	case "HMSET", "HSET":
+		hash, ok := server.hashes[args[1]]
+		if !ok {
+			hash = make(map[string]string)
+			server.hashes[args[1]] = hash
+		}
+		for idx := 2; idx+1 < len(args); idx += 2 {
+			hash[args[idx]] = args[idx+1]
+		}
+		server.notify(args[1], "hset")
+	case "HGETALL":
+		var vals []string
+		for field, val := range server.hashes[args[1]] {
+			vals = append(vals, field, val)
+		}
+		return bulkArray(vals...)
+	case "DEL":
+		count := 0
+		for _, key := range args[1:] {
+			if _, ok := server.hashes[key]; ok {
+				delete(server.hashes, key)
+				server.notify(key, "del")
+				count++
+			}
+		}
+		return ":" + strconv.Itoa(count) + "\r\n"
+	case "EXISTS":
+		if _, ok := server.hashes[args[1]]; ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "KEYS":
+		var keys []string
+		for key := range server.hashes {
+			if ok, _ := path.Match(args[1], key); ok {
+				keys = append(keys, key)
+			}
+		}
+		return bulkArray(keys...)
+	case "SCAN":
+		//the cursor is the index of the next key in the sorted keys
+		var keys []string
+		for key := range server.hashes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		cursor, _ := strconv.Atoi(args[1])
+		count, _ := strconv.Atoi(args[5])
+		var matched []string
+		for ; cursor < len(keys) && count > 0; cursor, count = cursor+1, count-1 {
+			if ok, _ := path.Match(args[3], keys[cursor]); ok {
+				matched = append(matched, keys[cursor])
+			}
+		}
+		if cursor >= len(keys) {
+			cursor = 0
+		}
+		return "*2\r\n" + bulkArray(strconv.Itoa(cursor))[4:] + bulkArray(matched...)
+	case "CONFIG":
+		if strings.ToUpper(args[1]) == "GET" {
+			return bulkArray(args[2], server.config[args[2]])
+		}
+		server.config[args[2]] = args[3]
+	case "PUBLISH":
+		return ":" + strconv.Itoa(server.publish(args[1], args[2])) + "\r\n"
+	case "PSUBSCRIBE":
+		conn.patterns = append(conn.patterns, args[1])
+		reply := bulkArray("psubscribe", args[1])
+		return "*3" + reply[2:] + ":" + strconv.Itoa(len(conn.patterns)) + "\r\n"
+	}
+	return "+OK\r\n"
+}
+
+//called with the server lock held
+func (server *fakeRedis) notify(key, event string) {
+	if !strings.Contains(server.config["notify-keyspace-events"], "K") {
+		return
+	}
+	server.publish("__keyspace@0__:"+key, event)
+}
+
+//called with the server lock held, returns the number of receivers
+func (server *fakeRedis) publish(channel, msg string) int {
+	count := 0
+	for _, conn := range server.conns {
+		for _, pattern := range conn.patterns {
+			if ok, _ := path.Match(pattern, channel); ok {
+				conn.write(bulkArray("pmessage", pattern, channel, msg))
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if _, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSpace(arg))
+	}
+	return args, nil
+}
+
+func (server *fakeRedis) commands() []string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return append([]string(nil), server.cmds...)
+}
+
+//closes the listener and all the connections
+func (server *fakeRedis) stop() {
+	server.listener.Close()
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+	server.conns = nil
+}
+
+func newTestDBUtil(address string) *DBUtil {
+	config := redisConfig.DefaultRedisConfig()
+	config.Address = address
+	return NewDBUtilWithConfig(nil, config)
+}
+
+func waitForState(t *testing.T, states chan bool, want bool) {
+	select {
+	case state := <-states:
+		if state != want {
+			t.Fatal("Expected connection state", want, "got", state)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for connection state", want)
+	}
+}
+
+func TestDBUtilReconnectsAfterServerRestart(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	db := newTestDBUtil(server.address)
+	states := make(chan bool, 4)
+	db.SetConnStateFunc(func(connected bool) { states <- connected })
+	db.Connect()
+	defer db.Disconnect()
+	waitForState(t, states, true)
+
+	if err := db.StoreValInDb("key", "val", "field"); err != nil {
+		t.Fatal("Store failed:", err)
+	}
+	server.stop()
+	if err := db.StoreValInDb("key", "val", "field"); err == nil {
+		t.Fatal("Expected an error with the server down")
+	}
+	waitForState(t, states, false)
+	if db.IsConnected() {
+		t.Fatal("Expected the DB to be marked down")
+	}
+	if _, err := db.GetValFromDB("key", "field"); err == nil {
+		t.Fatal("Expected the calls to fail fast while reconnecting")
+	}
+
+	server.restart(t)
+	defer server.stop()
+	waitForState(t, states, true)
+	if err := db.StoreValInDb("key", "val", "field"); err != nil {
+		t.Fatal("Store failed after reconnect:", err)
+	}
+	found := false
+	for _, cmd := range server.commands() {
+		if cmd == "HMSET key field val" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Store not sent to the restarted server:", server.commands())
+	}
+}
+
+func TestDBUtilServesConcurrentCalls(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	defer server.stop()
+	db := newTestDBUtil(server.address)
+	db.Connect()
+	defer db.Disconnect()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := db.StoreValInDb("key", i, "field"); err != nil {
+				t.Error("Store failed:", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if reply, err := db.DoWithTimeout(time.Second, "PING"); err != nil || reply != "PONG" {
+		t.Fatal("Expected PONG from DoWithTimeout, got", reply, err)
+	}
+	//the pipeline of the shared handle runs on a pinned connection, returned after the replies
+	db.Send("HSET", "key", "field", "val")
+	db.Send("HGETALL", "key")
+	if err := db.Flush(); err != nil {
+		t.Fatal("Flush failed:", err)
+	}
+	if reply, err := db.Receive(); err != nil || reply != "OK" {
+		t.Fatal("Expected OK from the first reply, got", reply, err)
+	}
+	if reply, err := redis.StringMap(db.Receive()); err != nil || reply["field"] != "val" {
+		t.Fatal("Expected the hash from the second reply, got", reply, err)
+	}
+	if db.pipeConn != nil {
+		t.Error("Pinned connection not returned to the pool")
+	}
+	if _, err := db.Receive(); err != errNoPendingReply {
+		t.Error("Expected Receive without a pending reply to fail, got", err)
+	}
+}
+
+func TestDBUtilCallsWhileDisconnecting(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	defer server.stop()
+	db := newTestDBUtil(server.address)
+	db.Connect()
+
+	stopCh := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stopCh:
+					return
+				default:
+				}
+				db.StoreValInDb("key", "val", "field")
+				db.Publish("PUBLISH", "channel", "msg")
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		db.Disconnect()
+		if err := db.Connect(); err != nil {
+			t.Fatal("Connect failed:", err)
+		}
+	}
+	close(stopCh)
+	wg.Wait()
+	db.Disconnect()
+	if _, err := db.Do("PING"); err == nil {
+		t.Fatal("Expected the calls to fail after disconnecting")
+	}
+}
@@ -0,0 +1,421 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// dbSubscribe.go
+package dbutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/garyburd/redigo/redis"
+	"models/objects"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DB_KEYSPACE_EVENTS_CONFIG = "notify-keyspace-events"
+	DB_SUBSCRIBE_PING_TIMEOUT = 3 * DB_HEALTH_CHECK_INTERVAL //no reply to the pings for this long means the DB is gone
+	DB_SCAN_COUNT             = 1000                         //keys the server looks at for each SCAN call
+)
+
+//callbacks of a subscription, called one at a time from the subscription goroutine. The callbacks
+//not set are skipped. A deleted object can't be read anymore, OnDelete gets its last known version
+type DBObjHandler struct {
+	OnAdd    func(key string, obj objects.ConfigObj)
+	OnUpdate func(key string, oldObj, obj objects.ConfigObj)
+	OnDelete func(key string, obj objects.ConfigObj)
+}
+
+//changes of the objects stored under a key pattern, made by any writer of the DB. It relies on the
+//keyspace notifications of the server, e.g. notify-keyspace-events KA in redis.conf, see
+//SetKeyspaceEventsEnable to have the subscription enable them. After the subscription connection
+//is lost, it subscribes again and reports the changes missed meanwhile
+type DBSubscription struct {
+	db      *DBUtil
+	pattern string
+	obj     objects.ConfigObj //decodes the objects, through GetObjectFromDb
+	handler DBObjHandler
+	objs    map[string]objects.ConfigObj //last known version of the objects, by key
+
+	lock   sync.Mutex
+	conn   redis.Conn
+	closed bool
+	stopCh chan bool
+	doneCh chan bool
+}
+
+//key pattern of the objects of the type of obj, e.g. Port#* for the ports
+func ObjKeyPattern(obj objects.ConfigObj) string {
+	key := obj.GetKey()
+	if idx := strings.Index(key, "#"); idx >= 0 {
+		return key[:idx+1] + "*"
+	}
+	return reflect.TypeOf(obj).Name() + "#*"
+}
+
+//subscribes to the changes of the objects of the type of obj
+func (db *DBUtil) SubscribeObjects(obj objects.ConfigObj, handler DBObjHandler) (*DBSubscription, error) {
+	return db.SubscribeKeys(ObjKeyPattern(obj), obj, handler)
+}
+
+//subscribes to the changes of the objects with a key matching the glob style pattern, decoded as
+//obj. The DB has to be connected. The objects stored when subscribing are not reported, they are
+//the starting point of the changes
+func (db *DBUtil) SubscribeKeys(pattern string, obj objects.ConfigObj, handler DBObjHandler) (*DBSubscription, error) {
+	if !db.IsConnected() {
+		return nil, DBNotConnectedError{db.network, db.address}
+	}
+	sub := &DBSubscription{
+		db:      db,
+		pattern: pattern,
+		obj:     obj,
+		handler: handler,
+		objs:    make(map[string]objects.ConfigObj),
+		stopCh:  make(chan bool),
+		doneCh:  make(chan bool),
+	}
+	psc, err := sub.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	if err = sub.sync(false); err != nil {
+		psc.Close()
+		return nil, err
+	}
+	go sub.run(psc)
+	return sub, nil
+}
+
+func (sub *DBSubscription) Pattern() string {
+	return sub.pattern
+}
+
+//stops the callbacks, not to be called from a callback
+func (sub *DBSubscription) Close() {
+	sub.lock.Lock()
+	if sub.closed {
+		sub.lock.Unlock()
+		return
+	}
+	sub.closed = true
+	close(sub.stopCh)
+	if sub.conn != nil {
+		sub.conn.Close()
+	}
+	sub.lock.Unlock()
+	<-sub.doneCh
+}
+
+func (sub *DBSubscription) isClosed() bool {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	return sub.closed
+}
+
+//channel pattern of the keyspace notifications of the keys
+func (sub *DBSubscription) channel() string {
+	return "__keyspace@" + strconv.Itoa(sub.db.config.DbIndex) + "__:" + sub.pattern
+}
+
+//dials a connection of its own, the pub/sub connections can't serve other calls
+func (sub *DBSubscription) subscribe() (redis.PubSubConn, error) {
+	conn, err := sub.db.config.Dial()
+	if err != nil {
+		return redis.PubSubConn{}, err
+	}
+	if err = checkKeyspaceEvents(conn, sub.db.keyspaceEventsEnabled()); err != nil && sub.db.logger != nil {
+		sub.db.logger.Warning(fmt.Sprintln("Keyspace notifications not enabled, err:", err))
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	if err = psc.PSubscribe(sub.channel()); err == nil {
+		switch reply := psc.ReceiveWithTimeout(sub.db.config.ReadTimeout).(type) {
+		case redis.Subscription:
+		case error:
+			err = reply
+		default:
+			err = errors.New(fmt.Sprintf("Unexpected reply to PSUBSCRIBE %#v", reply))
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return redis.PubSubConn{}, err
+	}
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if sub.closed {
+		conn.Close()
+		return redis.PubSubConn{}, errors.New("Subscription closed")
+	}
+	sub.conn = conn
+	return psc, nil
+}
+
+//lets the subscriptions add the keyspace events they need to the ones the server publishes, off
+//by default. The server config is shared by all its clients, the events already published are kept
+func (db *DBUtil) SetKeyspaceEventsEnable(enable bool) {
+	db.stateLock.Lock()
+	db.enableKeyspaceEvents = enable
+	db.stateLock.Unlock()
+}
+
+func (db *DBUtil) keyspaceEventsEnabled() bool {
+	db.stateLock.Lock()
+	defer db.stateLock.Unlock()
+	return db.enableKeyspaceEvents
+}
+
+//checks that the server publishes the keyspace events of the generic and hash commands, the
+//missing ones are added to the events of the server when enable is set
+func checkKeyspaceEvents(conn redis.Conn, enable bool) error {
+	reply, err := redis.Strings(conn.Do("CONFIG", "GET", DB_KEYSPACE_EVENTS_CONFIG))
+	if err != nil {
+		return err
+	}
+	events := ""
+	if len(reply) == 2 {
+		events = reply[1]
+	}
+	missing := ""
+	if !strings.Contains(events, "K") {
+		missing += "K"
+	}
+	if !strings.Contains(events, "A") {
+		for _, class := range []string{"g", "h"} {
+			if !strings.Contains(events, class) {
+				missing += class
+			}
+		}
+	}
+	if missing == "" {
+		return nil
+	}
+	if !enable {
+		return errors.New(fmt.Sprintln("Keyspace events", missing, "not published by the server"))
+	}
+	_, err = conn.Do("CONFIG", "SET", DB_KEYSPACE_EVENTS_CONFIG, events+missing)
+	return err
+}
+
+func (sub *DBSubscription) run(psc redis.PubSubConn) {
+	defer close(sub.doneCh)
+	for {
+		err := sub.receive(psc)
+		psc.Close()
+		if sub.isClosed() {
+			return
+		}
+		if sub.db.logger != nil {
+			sub.db.logger.Err(fmt.Sprintln("Lost the subscription to", sub.pattern, "err:", err))
+		}
+		var ok bool
+		if psc, ok = sub.resubscribe(); !ok {
+			return
+		}
+	}
+}
+
+//processes the notifications until the connection fails, pinging the server so that a dead
+//connection is detected
+func (sub *DBSubscription) receive(psc redis.PubSubConn) error {
+	pingStopCh := make(chan bool)
+	defer close(pingStopCh)
+	go func() {
+		ticker := time.NewTicker(DB_HEALTH_CHECK_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				psc.Ping("")
+			case <-pingStopCh:
+				return
+			}
+		}
+	}()
+	prefix := strings.TrimSuffix(sub.channel(), sub.pattern)
+	for {
+		switch reply := psc.ReceiveWithTimeout(DB_SUBSCRIBE_PING_TIMEOUT).(type) {
+		case redis.PMessage:
+			sub.process(strings.TrimPrefix(reply.Channel, prefix), string(reply.Data))
+		case error:
+			return reply
+		}
+	}
+}
+
+//subscribes again once the DB is back, then reports what changed meanwhile
+func (sub *DBSubscription) resubscribe() (redis.PubSubConn, bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-sub.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	backoff := DB_RECONNECT_MIN_BACKOFF
+	for {
+		select {
+		case <-ctx.Done():
+			return redis.PubSubConn{}, false
+		case <-time.After(jitter(backoff)):
+		}
+		backoff = nextBackoff(backoff, DB_RECONNECT_MAX_BACKOFF)
+		if sub.db.WaitReady(ctx) != nil {
+			return redis.PubSubConn{}, false
+		}
+		psc, err := sub.subscribe()
+		if err != nil {
+			continue
+		}
+		if err = sub.sync(true); err != nil {
+			psc.Close()
+			continue
+		}
+		if sub.db.logger != nil {
+			sub.db.logger.Info(fmt.Sprintln("Subscribed again to", sub.pattern))
+		}
+		return psc, true
+	}
+}
+
+func (sub *DBSubscription) process(key, event string) {
+	//the object is read again whatever the command, a del may be followed by the store of the
+	//new version, as done by Batch.ReplaceObject, and is then reported as an update
+	conn, err := sub.db.GetConn()
+	if err != nil {
+		sub.logReadErr(key, err)
+		return
+	}
+	defer sub.db.PutConn(conn)
+	exists, err := redis.Bool(conn.Do("EXISTS", key))
+	if err != nil {
+		sub.logReadErr(key, err)
+		return
+	}
+	if !exists {
+		sub.deleted(key)
+		return
+	}
+	obj, err := sub.obj.GetObjectFromDb(key, conn)
+	if err != nil {
+		sub.logReadErr(key, err)
+		return
+	}
+	sub.changed(key, obj)
+}
+
+//reads all the objects, reporting the differences with the known ones when notify is set
+func (sub *DBSubscription) sync(notify bool) error {
+	conn, err := sub.db.GetConn()
+	if err != nil {
+		return err
+	}
+	defer sub.db.PutConn(conn)
+	keys, err := scanKeys(conn, sub.pattern)
+	if err != nil {
+		return err
+	}
+	objs := make(map[string]objects.ConfigObj, len(keys))
+	for _, key := range keys {
+		obj, err := sub.obj.GetObjectFromDb(key, conn)
+		if err != nil {
+			if conn.Err() != nil {
+				return err
+			}
+			sub.logReadErr(key, err)
+			continue
+		}
+		objs[key] = obj
+	}
+	if !notify {
+		sub.objs = objs
+		return nil
+	}
+	for key := range sub.objs {
+		if _, ok := objs[key]; !ok {
+			sub.deleted(key)
+		}
+	}
+	for key, obj := range objs {
+		sub.changed(key, obj)
+	}
+	return nil
+}
+
+//keys matching the pattern, read with SCAN so that the server isn't blocked by a large DB
+func scanKeys(conn redis.Conn, pattern string) ([]string, error) {
+	var keys []string
+	cursor := 0
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", DB_SCAN_COUNT))
+		if err != nil {
+			return nil, err
+		}
+		var batch []string
+		if _, err = redis.Scan(reply, &cursor, &batch); err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+func (sub *DBSubscription) changed(key string, obj objects.ConfigObj) {
+	oldObj, known := sub.objs[key]
+	if known && reflect.DeepEqual(oldObj, obj) {
+		return
+	}
+	sub.objs[key] = obj
+	if !known {
+		if sub.handler.OnAdd != nil {
+			sub.handler.OnAdd(key, obj)
+		}
+	} else if sub.handler.OnUpdate != nil {
+		sub.handler.OnUpdate(key, oldObj, obj)
+	}
+}
+
+func (sub *DBSubscription) deleted(key string) {
+	obj, known := sub.objs[key]
+	if !known {
+		return
+	}
+	delete(sub.objs, key)
+	if sub.handler.OnDelete != nil {
+		sub.handler.OnDelete(key, obj)
+	}
+}
+
+func (sub *DBSubscription) logReadErr(key string, err error) {
+	if sub.db.logger != nil {
+		sub.db.logger.Err(fmt.Sprintln("Failed to read object", key, "of the subscription to", sub.pattern, "err:", err))
+	}
+}
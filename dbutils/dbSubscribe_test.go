@@ -0,0 +1,218 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package dbutils
+
+import (
+	"errors"
+	"fmt"
+	"github.com/garyburd/redigo/redis"
+	"models/objects"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (obj testStateObj) GetObjectFromDb(key string, dbHdl redis.Conn) (objects.ConfigObj, error) {
+	vals, err := redis.StringMap(dbHdl.Do("HGETALL", key))
+	if err != nil {
+		return obj, err
+	}
+	if len(vals) == 0 {
+		return obj, errors.New("Object not found")
+	}
+	speed, _ := strconv.Atoi(vals["Speed"])
+	return testStateObj{Name: vals["Name"], Speed: speed}, nil
+}
+
+//records the callbacks as "op key speed", the old speed first for the updates
+func recordingHandler() (DBObjHandler, chan string) {
+	changes := make(chan string, 16)
+	speed := func(obj objects.ConfigObj) int { return obj.(testStateObj).Speed }
+	return DBObjHandler{
+		OnAdd: func(key string, obj objects.ConfigObj) {
+			changes <- fmt.Sprint("add ", key, " ", speed(obj))
+		},
+		OnUpdate: func(key string, oldObj, obj objects.ConfigObj) {
+			changes <- fmt.Sprint("update ", key, " ", speed(oldObj), " ", speed(obj))
+		},
+		OnDelete: func(key string, obj objects.ConfigObj) {
+			changes <- fmt.Sprint("delete ", key, " ", speed(obj))
+		},
+	}, changes
+}
+
+func expectChanges(t *testing.T, changes chan string, expected ...string) {
+	var got []string
+	for len(got) < len(expected) {
+		select {
+		case change := <-changes:
+			got = append(got, change)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected", expected, "got", got)
+		}
+	}
+	for _, change := range expected {
+		found := false
+		for _, gotChange := range got {
+			found = found || gotChange == change
+		}
+		if !found {
+			t.Fatal("Expected", expected, "got", got)
+		}
+	}
+}
+
+func expectNoChange(t *testing.T, changes chan string) {
+	select {
+	case change := <-changes:
+		t.Fatal("Unexpected change", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSubscribeObjectsReportsChanges(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	defer server.stop()
+	server.config["notify-keyspace-events"] = "Ex"
+	db := connectTestDBUtil(t, server)
+	defer db.Disconnect()
+	db.SetKeyspaceEventsEnable(true)
+	db.StoreObjectInDb(testStateObj{Name: "fpPort1", Speed: 10})
+
+	handler, changes := recordingHandler()
+	sub, err := db.SubscribeObjects(testStateObj{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if sub.Pattern() != "TestState#*" {
+		t.Fatal("Unexpected pattern", sub.Pattern())
+	}
+	server.lock.Lock()
+	events := server.config["notify-keyspace-events"]
+	server.lock.Unlock()
+	if !strings.HasPrefix(events, "Ex") || !strings.Contains(events, "K") {
+		t.Fatal("Expected the keyspace notifications to be added to the server events, got", events)
+	}
+
+	db.StoreObjectInDb(testStateObj{Name: "fpPort2", Speed: 40})
+	expectChanges(t, changes, "add TestState#fpPort2 40")
+	db.StoreObjectInDb(testStateObj{Name: "fpPort1", Speed: 100})
+	expectChanges(t, changes, "update TestState#fpPort1 10 100")
+	db.StoreObjectsInDb([]objects.ConfigObj{testStateObj{Name: "fpPort1", Speed: 100}})
+	db.StoreValInDb("OtherState#fpPort1", "up", "OperState")
+	expectNoChange(t, changes)
+	db.DeleteObjectFromDb(testStateObj{Name: "fpPort2"})
+	expectChanges(t, changes, "delete TestState#fpPort2 40")
+
+	sub.Close()
+	db.StoreObjectInDb(testStateObj{Name: "fpPort3", Speed: 10})
+	expectNoChange(t, changes)
+}
+
+func TestSubscriptionResyncsAfterServerRestart(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	server.config["notify-keyspace-events"] = "KA"
+	db := connectTestDBUtil(t, server)
+	defer db.Disconnect()
+	db.StoreObjectsInDb([]objects.ConfigObj{testStateObj{Name: "fpPort1", Speed: 10},
+		testStateObj{Name: "fpPort2", Speed: 10}})
+
+	handler, changes := recordingHandler()
+	sub, err := db.SubscribeObjects(testStateObj{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	server.stop()
+	//changes made by another writer while the subscription is down
+	server.lock.Lock()
+	delete(server.hashes, "TestState#fpPort1")
+	server.hashes["TestState#fpPort2"]["Speed"] = "40"
+	server.hashes["TestState#fpPort3"] = map[string]string{"Name": "fpPort3", "Speed": "100"}
+	server.lock.Unlock()
+	server.restart(t)
+	defer server.stop()
+
+	expectChanges(t, changes, "delete TestState#fpPort1 10", "update TestState#fpPort2 10 40",
+		"add TestState#fpPort3 100")
+	db.DeleteObjectFromDb(testStateObj{Name: "fpPort3"})
+	expectChanges(t, changes, "delete TestState#fpPort3 100")
+}
+
+func TestSubscriptionLeavesServerEventsByDefault(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	defer server.stop()
+	db := connectTestDBUtil(t, server)
+	defer db.Disconnect()
+
+	sub, err := db.SubscribeObjects(testStateObj{}, DBObjHandler{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Close()
+	for _, cmd := range server.commands() {
+		if strings.HasPrefix(cmd, "CONFIG SET") {
+			t.Fatal("Expected the server config to be left alone, got", cmd)
+		}
+	}
+}
+
+func TestSubscribeKeysNeedsConnection(t *testing.T) {
+	db := newTestDBUtil(closedAddress(t))
+	if _, err := db.SubscribeKeys("TestState#*", testStateObj{}, DBObjHandler{}); err == nil {
+		t.Fatal("Expected an error before connecting")
+	}
+}
+
+func TestSubscriptionReportsReplaceAsUpdate(t *testing.T) {
+	server := newFakeRedis(t, "127.0.0.1:0")
+	defer server.stop()
+	server.config["notify-keyspace-events"] = "KA"
+	db := connectTestDBUtil(t, server)
+	defer db.Disconnect()
+	db.StoreObjectInDb(testStateObj{Name: "fpPort1", Speed: 10})
+
+	handler, changes := recordingHandler()
+	sub, err := db.SubscribeObjects(testStateObj{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	for _, cmd := range server.commands() {
+		if strings.HasPrefix(cmd, "KEYS") {
+			t.Fatal("Expected the objects to be listed with SCAN, got", cmd)
+		}
+	}
+
+	batch := db.NewBatch(true)
+	batch.ReplaceObject(testStateObj{Name: "fpPort1", Speed: 100})
+	if _, err := batch.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expectChanges(t, changes, "update TestState#fpPort1 10 100")
+	expectNoChange(t, changes)
+}
@@ -24,13 +24,14 @@
 package dbutils
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/garyburd/redigo/redis"
 	"models/objects"
-	"reflect"
 	"sync"
 	"time"
+	"utils/dbutils/redisConfig"
 	"utils/logging"
 )
 
@@ -49,17 +50,37 @@ func (e DBNotConnectedError) Error() string {
 }
 
 type DBUtil struct {
-	redis.Conn
-	logger  *logging.Writer
-	network string
-	address string
-	DbLock  sync.RWMutex
+	redis.Conn //runs each call on a connection of the pool, nil until connected, set under stateLock
+	logger     logging.LoggerIntf
+	network    string
+	address    string
+	DbLock     sync.RWMutex //deprecated, the calls are safe for concurrent use without it
+	config     redisConfig.RedisConfig
+	pool       *redis.Pool
+
+	pipeLock    sync.Mutex
+	pipeConn    redis.Conn //pinned by Send on the DB handle, see pinnedConn
+	pipePending int
+
+	stateLock     sync.Mutex
+	connected     bool
+	reconnecting  bool
+	connGen       int
+	callTimeout   time.Duration
+	stopCh        chan bool
+	readyCh       chan struct{}
+	connStateFunc DBConnStateFunc
+
+	enableKeyspaceEvents bool //set under stateLock, see SetKeyspaceEventsEnable
 }
 
 type DBIntf interface {
 	Connect() error
+	ConnectContext(context.Context) error
 	Disconnect()
 	StoreObjectInDb(objects.ConfigObj) error
+	StoreObjectsInDb([]objects.ConfigObj) error
+	DeleteObjectsFromDb([]objects.ConfigObj) error
 	DeleteObjectFromDb(objects.ConfigObj) error
 	GetObjectFromDb(objects.ConfigObj, string) (objects.ConfigObj, error)
 	GetKey(objects.ConfigObj) string
@@ -72,138 +93,128 @@ type DBIntf interface {
 	StoreValInDb(interface{}, interface{}, interface{}) error
 	GetAllKeys(interface{}) (interface{}, error)
 	GetValFromDB(key interface{}, field interface{}) (val interface{}, err error)
+	SubscribeObjects(objects.ConfigObj, DBObjHandler) (*DBSubscription, error)
+	SubscribeKeys(string, objects.ConfigObj, DBObjHandler) (*DBSubscription, error)
 }
 
-func NewDBUtil(logger *logging.Writer) *DBUtil {
+func NewDBUtil(logger logging.LoggerIntf) *DBUtil {
+	return NewDBUtilWithConfig(logger, redisConfig.DefaultRedisConfig())
+}
+
+//DB handle dialing the server of the config, see redisConfig.LoadRedisConfig for the one of the
+//params dir
+func NewDBUtilWithConfig(logger logging.LoggerIntf, config redisConfig.RedisConfig) *DBUtil {
 	return &DBUtil{
 		logger:  logger,
-		network: "tcp",
-		address: ":6379",
-		DbLock:  sync.RWMutex{},
+		network: config.Network,
+		address: config.Address,
+		config:  config,
 	}
 }
 
+//waits until the DB is reachable, see ConnectContext to give up
 func (db *DBUtil) Connect() error {
-	retryCount := 0
-	ticker := time.NewTicker(DB_CONNECT_TIME_INTERVAL * time.Second)
-	for _ = range ticker.C {
-		retryCount += 1
-		dbHdl, err := redis.Dial(db.network, db.address)
-		if err != nil {
-			if retryCount%DB_CONNECT_RETRY_LOG_COUNT == 0 {
-				if db.logger != nil {
-					db.logger.Err(fmt.Sprintln("Failed to dial out to Redis server. Retrying connection. Num retries = ", retryCount))
-				}
-			}
-		} else {
-			// ping to ensure that that the server is up and running
-			// this is the suggested way to determine that redis is 'ready'
-			dbHdl.Send("PING")
-			dbHdl.Flush()
-			response, err := dbHdl.Receive()
-			var pongReply interface{} = "PONG"
-			db.logger.Info(fmt.Sprintln("Received Response From Redis Server %#v", response))
-			if err == nil && reflect.DeepEqual(response, pongReply) {
-				db.Conn = dbHdl
-				break
-			}
-		}
-	}
-	return nil
+	return db.ConnectContext(context.Background())
 }
 
 func (db *DBUtil) Disconnect() {
-	if db.Conn != nil {
-		db.Close()
+	db.stateLock.Lock()
+	if db.stopCh != nil {
+		close(db.stopCh)
+		db.stopCh = nil
+	}
+	db.connected = false
+	db.setReady(false)
+	db.reconnecting = false
+	pool := db.pool
+	db.pool = nil
+	db.Conn = nil
+	db.stateLock.Unlock()
+	db.releasePinnedConn()
+	if pool != nil {
+		pool.Close()
 	}
 }
 
 func (db *DBUtil) StoreObjectInDb(obj objects.ConfigObj) error {
-	defer db.DbLock.Unlock()
-	db.DbLock.Lock()
-	return obj.StoreObjectInDb(db.Conn)
+	conn, err := db.GetConn()
+	if err != nil {
+		return err
+	}
+	defer db.PutConn(conn)
+	return obj.StoreObjectInDb(conn)
 }
 
 func (db *DBUtil) DeleteObjectFromDb(obj objects.ConfigObj) error {
-	if db.Conn == nil {
-		return DBNotConnectedError{db.network, db.address}
+	conn, err := db.GetConn()
+	if err != nil {
+		return err
 	}
-	defer db.DbLock.Unlock()
-	db.DbLock.Lock()
-	return obj.DeleteObjectFromDb(db.Conn)
+	defer db.PutConn(conn)
+	return obj.DeleteObjectFromDb(conn)
 }
 
 func (db *DBUtil) GetObjectFromDb(obj objects.ConfigObj, objKey string) (objects.ConfigObj, error) {
-	if db.Conn == nil {
-		return obj, DBNotConnectedError{db.network, db.address}
+	conn, err := db.GetConn()
+	if err != nil {
+		return obj, err
 	}
-	defer db.DbLock.Unlock()
-	db.DbLock.Lock()
-	return obj.GetObjectFromDb(objKey, db.Conn)
+	defer db.PutConn(conn)
+	return obj.GetObjectFromDb(objKey, conn)
 }
 
 func (db *DBUtil) GetKey(obj objects.ConfigObj) string {
-	defer db.DbLock.Unlock()
-	db.DbLock.Lock()
 	return obj.GetKey()
 }
 
 func (db *DBUtil) GetAllObjFromDb(obj objects.ConfigObj) ([]objects.ConfigObj, error) {
-	if db.Conn == nil {
-		return make([]objects.ConfigObj, 0), DBNotConnectedError{db.network, db.address}
+	conn, err := db.GetConn()
+	if err != nil {
+		return make([]objects.ConfigObj, 0), err
 	}
-	defer db.DbLock.Unlock()
-	db.DbLock.Lock()
-	return obj.GetAllObjFromDb(db.Conn)
+	defer db.PutConn(conn)
+	return obj.GetAllObjFromDb(conn)
 }
 
 func (db *DBUtil) CompareObjectsAndDiff(obj objects.ConfigObj, updateKeys map[string]bool, inObj objects.ConfigObj) (
 	[]bool, error) {
-	if db.Conn == nil {
+	if db.conn() == nil {
 		return make([]bool, 0), DBNotConnectedError{db.network, db.address}
 	}
-	defer db.DbLock.Unlock()
-	db.DbLock.Lock()
 	return obj.CompareObjectsAndDiff(updateKeys, inObj)
 }
 
 func (db *DBUtil) UpdateObjectInDb(obj, inObj objects.ConfigObj, attrSet []bool) error {
-	if db.Conn == nil {
-		return DBNotConnectedError{db.network, db.address}
+	conn, err := db.GetConn()
+	if err != nil {
+		return err
 	}
-	defer db.DbLock.Unlock()
-	db.DbLock.Lock()
-	return obj.UpdateObjectInDb(inObj, attrSet, db.Conn)
+	defer db.PutConn(conn)
+	return obj.UpdateObjectInDb(inObj, attrSet, conn)
 }
 
 func (db *DBUtil) MergeDbAndConfigObj(obj, dbObj objects.ConfigObj, attrSet []bool) (objects.ConfigObj, error) {
-	defer db.DbLock.Unlock()
-	db.DbLock.Lock()
 	return obj.MergeDbAndConfigObj(dbObj, attrSet)
 }
 
 func (db *DBUtil) GetBulkObjFromDb(obj objects.ConfigObj, startIndex, count int64) (error, int64, int64, bool,
 	[]objects.ConfigObj) {
-	if db.Conn == nil {
-		return DBNotConnectedError{db.network, db.address}, 0, 0, false, make([]objects.ConfigObj, 0)
+	conn, err := db.GetConn()
+	if err != nil {
+		return err, 0, 0, false, make([]objects.ConfigObj, 0)
 	}
-	defer db.DbLock.Unlock()
-	db.DbLock.Lock()
-	return obj.GetBulkObjFromDb(startIndex, count, db.Conn)
+	defer db.PutConn(conn)
+	return obj.GetBulkObjFromDb(startIndex, count, conn)
 }
 
 func (db *DBUtil) Publish(op string, channel interface{}, msg interface{}) {
-	if db.Conn != nil {
-		defer db.DbLock.Unlock()
-		db.DbLock.Lock()
+	if db.conn() != nil {
 		db.Do(op, channel, msg)
 	}
 }
 
 func (db *DBUtil) StoreValInDb(key interface{}, val interface{}, field interface{}) error {
-	if db.Conn != nil {
-		defer db.DbLock.Unlock()
-		db.DbLock.Lock()
+	if db.conn() != nil {
 		_, err := db.Do("HMSET", key, field, val)
 		return err
 	}
@@ -212,9 +223,7 @@ func (db *DBUtil) StoreValInDb(key interface{}, val interface{}, field interface
 }
 
 func (db *DBUtil) GetAllKeys(pattern interface{}) (val interface{}, err error) {
-	if db.Conn != nil {
-		defer db.DbLock.Unlock()
-		db.DbLock.Lock()
+	if db.conn() != nil {
 		val, err = db.Do("KEYS", pattern)
 		return val, err
 	}
@@ -223,12 +232,66 @@ func (db *DBUtil) GetAllKeys(pattern interface{}) (val interface{}, err error) {
 }
 
 func (db *DBUtil) GetValFromDB(key interface{}, field interface{}) (val interface{}, err error) {
-	if db.Conn != nil {
-		defer db.DbLock.Unlock()
-		db.DbLock.Lock()
+	if db.conn() != nil {
 		val, err := db.Do("HGET", key, field)
 		return val, err
 	}
 	err = errors.New("DB Connection handler is nil")
 	return val, err
 }
+
+func (db *DBUtil) AddToSortedSet(key interface{}, score int64, member interface{}) error {
+	if db.conn() != nil {
+		_, err := db.Do("ZADD", key, score, member)
+		return err
+	}
+	err := errors.New("DB Connection handler is nil")
+	return err
+}
+
+//members with a score between min and max, lowest score first or highest first if reverse is set.
+//A count of 0 returns all the members after offset
+func (db *DBUtil) GetSortedSetRangeByScore(key interface{}, min, max int64, offset, count int, reverse bool) ([]string, error) {
+	if db.conn() != nil {
+		args := []interface{}{key, min, max}
+		cmd := "ZRANGEBYSCORE"
+		if reverse {
+			args = []interface{}{key, max, min}
+			cmd = "ZREVRANGEBYSCORE"
+		}
+		if count > 0 {
+			args = append(args, "LIMIT", offset, count)
+		} else if offset > 0 {
+			args = append(args, "LIMIT", offset, -1)
+		}
+		return redis.Strings(db.Do(cmd, args...))
+	}
+	err := errors.New("DB Connection handler is nil")
+	return nil, err
+}
+
+func (db *DBUtil) GetSortedSetCount(key interface{}) (int, error) {
+	if db.conn() != nil {
+		return redis.Int(db.Do("ZCARD", key))
+	}
+	err := errors.New("DB Connection handler is nil")
+	return 0, err
+}
+
+func (db *DBUtil) RemoveFromSortedSet(key interface{}, members ...interface{}) error {
+	if db.conn() != nil {
+		_, err := db.Do("ZREM", append([]interface{}{key}, members...)...)
+		return err
+	}
+	err := errors.New("DB Connection handler is nil")
+	return err
+}
+
+func (db *DBUtil) DeleteKeys(keys ...interface{}) error {
+	if db.conn() != nil {
+		_, err := db.Do("DEL", keys...)
+		return err
+	}
+	err := errors.New("DB Connection handler is nil")
+	return err
+}
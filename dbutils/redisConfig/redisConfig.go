@@ -0,0 +1,246 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// redisConfig.go
+package redisConfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/garyburd/redigo/redis"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	REDIS_CONFIG_FILE_NAME = "redis.json"
+	REDIS_DEFAULT_NETWORK  = "tcp"
+	REDIS_DEFAULT_ADDRESS  = ":6379"
+	REDIS_DIAL_TIMEOUT     = 5 * time.Second
+	REDIS_READ_TIMEOUT     = 5 * time.Second
+	REDIS_WRITE_TIMEOUT    = 5 * time.Second
+)
+
+//how to reach the config DB, shared by all the Redis users of utils. For a Unix socket the network
+//is "unix" and the address the socket path
+type RedisConfig struct {
+	Network  string
+	Address  string
+	Password string
+	DbIndex  int
+
+	TLS           bool
+	TLSSkipVerify bool
+	TLSServerName string //defaults to the host of the address
+	TLSCAFile     string //PEM bundle verifying the server, the system roots when empty
+	TLSCertFile   string //client certificate and key, when the server requires one
+	TLSKeyFile    string
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+//the redis.json of the params dir, timeouts are durations such as "500ms" or "5s"
+type redisConfigJson struct {
+	Network       string `json:"Network"`
+	Address       string `json:"Address"`
+	Password      string `json:"Password"`
+	DbIndex       int    `json:"DbIndex"`
+	TLS           bool   `json:"TLS"`
+	TLSSkipVerify bool   `json:"TLSSkipVerify"`
+	TLSServerName string `json:"TLSServerName"`
+	TLSCAFile     string `json:"TLSCAFile"`
+	TLSCertFile   string `json:"TLSCertFile"`
+	TLSKeyFile    string `json:"TLSKeyFile"`
+	DialTimeout   string `json:"DialTimeout"`
+	ReadTimeout   string `json:"ReadTimeout"`
+	WriteTimeout  string `json:"WriteTimeout"`
+}
+
+//local TCP server on the default port
+func DefaultRedisConfig() RedisConfig {
+	return RedisConfig{
+		Network:      REDIS_DEFAULT_NETWORK,
+		Address:      REDIS_DEFAULT_ADDRESS,
+		DialTimeout:  REDIS_DIAL_TIMEOUT,
+		ReadTimeout:  REDIS_READ_TIMEOUT,
+		WriteTimeout: REDIS_WRITE_TIMEOUT,
+	}
+}
+
+//reads redis.json from the daemon params dir. The attributes missing from the file keep their
+//default, and so does everything when there is no file
+func LoadRedisConfig(paramsDir string) (RedisConfig, error) {
+	config := DefaultRedisConfig()
+	bytes, err := ioutil.ReadFile(filepath.Join(paramsDir, REDIS_CONFIG_FILE_NAME))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return config, err
+	}
+	err = config.UnmarshalJSON(bytes)
+	if err != nil {
+		return DefaultRedisConfig(), err
+	}
+	return config, config.Validate()
+}
+
+//overwrites the attributes present in the JSON
+func (config *RedisConfig) UnmarshalJSON(bytes []byte) error {
+	configJson := redisConfigJson{
+		Network:       config.Network,
+		Address:       config.Address,
+		Password:      config.Password,
+		DbIndex:       config.DbIndex,
+		TLS:           config.TLS,
+		TLSSkipVerify: config.TLSSkipVerify,
+		TLSServerName: config.TLSServerName,
+		TLSCAFile:     config.TLSCAFile,
+		TLSCertFile:   config.TLSCertFile,
+		TLSKeyFile:    config.TLSKeyFile,
+	}
+	err := json.Unmarshal(bytes, &configJson)
+	if err != nil {
+		return errors.New(fmt.Sprintln("Error in Unmarshalling", REDIS_CONFIG_FILE_NAME, err))
+	}
+	timeouts := []struct {
+		val string
+		out *time.Duration
+	}{
+		{configJson.DialTimeout, &config.DialTimeout},
+		{configJson.ReadTimeout, &config.ReadTimeout},
+		{configJson.WriteTimeout, &config.WriteTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.val == "" {
+			continue
+		}
+		*timeout.out, err = time.ParseDuration(timeout.val)
+		if err != nil {
+			return errors.New(fmt.Sprintln("Invalid timeout", timeout.val, "in", REDIS_CONFIG_FILE_NAME))
+		}
+	}
+	config.Network = configJson.Network
+	config.Address = configJson.Address
+	config.Password = configJson.Password
+	config.DbIndex = configJson.DbIndex
+	config.TLS = configJson.TLS
+	config.TLSSkipVerify = configJson.TLSSkipVerify
+	config.TLSServerName = configJson.TLSServerName
+	config.TLSCAFile = configJson.TLSCAFile
+	config.TLSCertFile = configJson.TLSCertFile
+	config.TLSKeyFile = configJson.TLSKeyFile
+	return nil
+}
+
+func (config RedisConfig) Validate() error {
+	switch config.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return errors.New(fmt.Sprintln("Unsupported Redis network", config.Network))
+	}
+	if config.Address == "" {
+		return errors.New("Redis address not set")
+	}
+	if config.DbIndex < 0 {
+		return errors.New(fmt.Sprintln("Invalid Redis DB index", config.DbIndex))
+	}
+	if config.TLS && config.Network == "unix" {
+		return errors.New("TLS is not supported on a Unix socket")
+	}
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return errors.New("TLS client certificate and key must be set together")
+	}
+	if config.DialTimeout < 0 || config.ReadTimeout < 0 || config.WriteTimeout < 0 {
+		return errors.New("Redis timeouts can't be negative")
+	}
+	return nil
+}
+
+func (config RedisConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.TLSSkipVerify,
+		ServerName:         config.TLSServerName,
+	}
+	if config.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New(fmt.Sprintln("No certificate found in", config.TLSCAFile))
+		}
+	}
+	if config.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+//options of redis.Dial matching the config, a zero timeout means none
+func (config RedisConfig) DialOptions() ([]redis.DialOption, error) {
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(config.DialTimeout),
+		redis.DialReadTimeout(config.ReadTimeout),
+		redis.DialWriteTimeout(config.WriteTimeout),
+		redis.DialDatabase(config.DbIndex),
+	}
+	if config.Password != "" {
+		options = append(options, redis.DialPassword(config.Password))
+	}
+	if config.TLS {
+		tlsConfig, err := config.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(tlsConfig))
+	}
+	return options, nil
+}
+
+//a connection authenticated and on the configured DB. Extra options are applied after the ones
+//of the config
+func (config RedisConfig) Dial(options ...redis.DialOption) (redis.Conn, error) {
+	configOptions, err := config.DialOptions()
+	if err != nil {
+		return nil, err
+	}
+	return redis.Dial(config.Network, config.Address, append(configOptions, options...)...)
+}
+
+//network and address, the password is left out
+func (config RedisConfig) String() string {
+	return config.Network + " " + config.Address
+}
@@ -0,0 +1,119 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package redisConfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeRedisConfig(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "redisConfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents != "" {
+		err = ioutil.WriteFile(filepath.Join(dir, REDIS_CONFIG_FILE_NAME), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadRedisConfigDefaultsWithoutFile(t *testing.T) {
+	dir := writeRedisConfig(t, "")
+	defer os.RemoveAll(dir)
+	config, err := LoadRedisConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != DefaultRedisConfig() {
+		t.Fatal("Expected the defaults, got", config)
+	}
+}
+
+func TestLoadRedisConfigUnixSocket(t *testing.T) {
+	dir := writeRedisConfig(t, `{"Network": "unix", "Address": "/var/run/redis/redis.sock",
+		"Password": "secret", "DbIndex": 2, "ReadTimeout": "250ms"}`)
+	defer os.RemoveAll(dir)
+	config, err := LoadRedisConfig(dir + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Network != "unix" || config.Address != "/var/run/redis/redis.sock" ||
+		config.Password != "secret" || config.DbIndex != 2 {
+		t.Fatal("Unexpected config", config)
+	}
+	if config.ReadTimeout != 250*time.Millisecond {
+		t.Fatal("Expected a read timeout of 250ms, got", config.ReadTimeout)
+	}
+	if config.DialTimeout != REDIS_DIAL_TIMEOUT || config.WriteTimeout != REDIS_WRITE_TIMEOUT {
+		t.Fatal("Expected the timeouts missing from the file to keep their default", config)
+	}
+	if strings.Contains(config.String(), "secret") {
+		t.Fatal("Password printed in", config.String())
+	}
+}
+
+func TestLoadRedisConfigRejectsInvalidFiles(t *testing.T) {
+	invalid := []string{
+		`{"Network": "udp"}`,
+		`{"DialTimeout": "soon"}`,
+		`{"Network": "unix", "Address": "/tmp/redis.sock", "TLS": true}`,
+		`{"TLS": true, "TLSCertFile": "client.pem"}`,
+		`{"DbIndex": -1}`,
+		`{"Address": `,
+	}
+	for _, contents := range invalid {
+		dir := writeRedisConfig(t, contents)
+		_, err := LoadRedisConfig(dir)
+		os.RemoveAll(dir)
+		if err == nil {
+			t.Error("Expected an error for", contents)
+		}
+	}
+}
+
+func TestDialOptionsLoadTLSRoots(t *testing.T) {
+	config := DefaultRedisConfig()
+	config.Address = "redis.lab:6380"
+	config.TLS = true
+	config.TLSCAFile = "/nonexistent/ca.pem"
+	if _, err := config.DialOptions(); err == nil {
+		t.Fatal("Expected an error for a missing CA file")
+	}
+	config.TLSCAFile = ""
+	options, err := config.DialOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options) != 6 {
+		t.Fatal("Expected the timeouts, DB index and TLS options, got", len(options))
+	}
+}
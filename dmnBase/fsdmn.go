@@ -37,6 +37,7 @@ import (
 	"utils/asicdClient"
 	"utils/commonDefs"
 	"utils/dbutils"
+	"utils/dbutils/redisConfig"
 	"utils/ipcutils"
 	"utils/keepalive"
 	"utils/logging"
@@ -69,6 +70,7 @@ type FSBaseDmn struct {
 	LogPrefix   string
 	Logger      *logging.Writer
 	DbHdl       *dbutils.DBUtil
+	RedisConfig *redisConfig.RedisConfig // read from redis.json of the params dir by the first init
 	ClientsList []ClientJson
 }
 
@@ -82,9 +84,22 @@ type FSDaemon struct {
 	AsicdSubSocketErrCh chan error
 }
 
+// Redis server shared by the logger and the DB handle, the defaults when the params dir has none
+func (dmn *FSBaseDmn) GetRedisConfig() redisConfig.RedisConfig {
+	if dmn.RedisConfig == nil {
+		config, err := redisConfig.LoadRedisConfig(dmn.ParamsDir)
+		if err != nil {
+			fmt.Println(dmn.LogPrefix, "Error in reading", redisConfig.REDIS_CONFIG_FILE_NAME, err, "using the defaults")
+			config = redisConfig.DefaultRedisConfig()
+		}
+		dmn.RedisConfig = &config
+	}
+	return *dmn.RedisConfig
+}
+
 func (dmn *FSBaseDmn) InitLogger() (err error) {
 	fmt.Println(dmn.LogPrefix, " Starting ", dmn.DmnName, "logger")
-	dmnLogger, err := logging.NewLogger(dmn.DmnName, dmn.LogPrefix, true)
+	dmnLogger, err := logging.NewLoggerWithRedisConfig(dmn.DmnName, dmn.LogPrefix, true, dmn.GetRedisConfig())
 	if err != nil {
 		fmt.Println("Failed to start the logger. Nothing will be logged...")
 		return err
@@ -94,7 +109,7 @@ func (dmn *FSBaseDmn) InitLogger() (err error) {
 }
 
 func (dmn *FSBaseDmn) InitDBHdl() (err error) {
-	dbHdl := dbutils.NewDBUtil(dmn.Logger)
+	dbHdl := dbutils.NewDBUtilWithConfig(dmn.Logger, dmn.GetRedisConfig())
 	err = dbHdl.Connect()
 	if err != nil {
 		dmn.Logger.Err("Failed to dial out to Redis server")
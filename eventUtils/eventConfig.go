@@ -0,0 +1,228 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// eventConfig.go
+package eventUtils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"infra/sysd/sysdCommonDefs"
+	"models/events"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	EventsFileCheckInterval time.Duration = 10 * time.Second
+)
+
+//types of the sysd notifications for the events, carried in a sysdCommonDefs.Notification
+const (
+	EventNotifyReload uint8 = 110
+	EventNotifyEnable uint8 = 111
+)
+
+//payload of an EventNotifyEnable notification
+type EventEnableNotification struct {
+	OwnerName string
+	EventId   int //0 for all the events of the owner
+	Enable    bool
+}
+
+//checks the event definitions read from the file before they are used
+func validateEventJson(evtJson EventJson) error {
+	daemonIds := make(map[int]map[int]bool)
+	daemonNames := make(map[string]bool)
+	for _, daemon := range evtJson.DaemonEvents {
+		if daemon.DaemonName == "" {
+			return fmt.Errorf("Daemon %d has no name", daemon.DaemonId)
+		}
+		if _, exist := daemonIds[daemon.DaemonId]; exist || daemonNames[daemon.DaemonName] {
+			return fmt.Errorf("Duplicate daemon %s, id %d", daemon.DaemonName, daemon.DaemonId)
+		}
+		daemonNames[daemon.DaemonName] = true
+		eventIds := make(map[int]bool)
+		for _, evt := range daemon.EventList {
+			if evt.EventName == "" {
+				return fmt.Errorf("Event %d of %s has no name", evt.EventId, daemon.DaemonName)
+			}
+			if eventIds[evt.EventId] {
+				return fmt.Errorf("Duplicate event id %d for %s", evt.EventId, daemon.DaemonName)
+			}
+			eventIds[evt.EventId] = true
+		}
+		daemonIds[daemon.DaemonId] = eventIds
+	}
+	for _, daemon := range evtJson.DaemonEvents {
+		for _, evt := range daemon.EventList {
+			if !evt.IsFault || !evt.Fault.RaiseFault {
+				continue
+			}
+			if !daemonIds[evt.Fault.ClearingDaemonId][evt.Fault.ClearingEventId] {
+				return fmt.Errorf("Fault %s of %s is cleared by the unknown event %d of daemon %d", evt.EventName,
+					daemon.DaemonName, evt.Fault.ClearingEventId, evt.Fault.ClearingDaemonId)
+			}
+		}
+	}
+	return nil
+}
+
+//reads the event definitions again. The active ones are kept when the file cannot be read or
+//is not valid. The enables set at runtime are applied on top of the file
+func (p *EventPublisher) ReloadEvents() error {
+	evtJson, err := p.readEventJson(p.eventsFile)
+	if err != nil {
+		return err
+	}
+	if err = validateEventJson(evtJson); err != nil {
+		p.logger.Err(fmt.Sprintln("Invalid event definitions in", p.eventsFile, "keeping the active ones, err:", err))
+		return err
+	}
+	found := false
+	for _, daemon := range evtJson.DaemonEvents {
+		found = found || daemon.DaemonName == p.ownerName
+	}
+	if !found {
+		err = fmt.Errorf("No event definitions for %s in %s", p.ownerName, p.eventsFile)
+		p.logger.Err(fmt.Sprintln(err, "keeping the active ones"))
+		return err
+	}
+	p.loadEventDetails(evtJson)
+	p.logger.Info(fmt.Sprintln("Reloaded the event definitions from", p.eventsFile))
+	return nil
+}
+
+func ReloadEvents() error {
+	return DefaultEventPublisher().ReloadEvents()
+}
+
+//reloads the event definitions when the file changed since it was read
+func (p *EventPublisher) checkEventsFile() {
+	info, err := os.Stat(p.eventsFile)
+	if err != nil {
+		return
+	}
+	p.eventLock.Lock()
+	changed := !info.ModTime().Equal(p.eventsFileTime) || info.Size() != p.eventsFileSize
+	p.eventsFileTime = info.ModTime()
+	p.eventsFileSize = info.Size()
+	p.eventLock.Unlock()
+	if changed {
+		p.ReloadEvents()
+	}
+}
+
+func (p *EventPublisher) recordEventsFile() {
+	info, err := os.Stat(p.eventsFile)
+	if err != nil {
+		return
+	}
+	p.eventLock.Lock()
+	p.eventsFileTime = info.ModTime()
+	p.eventsFileSize = info.Size()
+	p.eventLock.Unlock()
+}
+
+//enables or disables an event of the owner, until it is changed again
+func (p *EventPublisher) SetEventEnable(eventId events.EventId, enable bool) error {
+	p.eventLock.Lock()
+	defer p.eventLock.Unlock()
+	evtEnt, exist := p.eventMap[eventId]
+	if !exist {
+		return errors.New(fmt.Sprintln("Unable to find the event corresponding to given eventId: ", eventId))
+	}
+	evtEnt.Enable = enable
+	p.eventMap[eventId] = evtEnt
+	p.enableOverrides[eventId] = enable
+	return nil
+}
+
+func SetEventEnable(eventId events.EventId, enable bool) error {
+	return DefaultEventPublisher().SetEventEnable(eventId, enable)
+}
+
+//enables or disables all the events of the owner, until it is changed again
+func (p *EventPublisher) SetOwnerEventEnable(enable bool) {
+	p.eventLock.Lock()
+	p.globalEventEnable = enable
+	p.ownerEnableOverride = &enable
+	p.eventLock.Unlock()
+}
+
+func SetOwnerEventEnable(enable bool) {
+	DefaultEventPublisher().SetOwnerEventEnable(enable)
+}
+
+func (p *EventPublisher) IsOwnerEventEnabled() bool {
+	p.eventLock.RLock()
+	defer p.eventLock.RUnlock()
+	return p.globalEventEnable
+}
+
+//copy of the active event definitions
+func (p *EventPublisher) GetEventDetails() map[events.EventId]EventDetails {
+	p.eventLock.RLock()
+	defer p.eventLock.RUnlock()
+	eventMap := make(map[events.EventId]EventDetails, len(p.eventMap))
+	for evtId, evtEnt := range p.eventMap {
+		eventMap[evtId] = evtEnt
+	}
+	return eventMap
+}
+
+//handles the EventNotifyReload and EventNotifyEnable notifications, the others are ignored
+func (p *EventPublisher) ProcessEventNotification(rxBuf []byte) error {
+	var msg sysdCommonDefs.Notification
+	err := json.Unmarshal(rxBuf, &msg)
+	if err != nil {
+		p.logger.Err(fmt.Sprintln("Unable to unmarshal event notification: ", rxBuf))
+		return err
+	}
+	switch msg.Type {
+	case EventNotifyReload:
+		return p.ReloadEvents()
+	case EventNotifyEnable:
+		var enableMsg EventEnableNotification
+		err = json.Unmarshal(msg.Payload, &enableMsg)
+		if err != nil {
+			p.logger.Err(fmt.Sprintln("Unable to unmarshal event enable notification: ", msg.Payload))
+			return err
+		}
+		if !strings.EqualFold(enableMsg.OwnerName, p.ownerName) {
+			return nil
+		}
+		if enableMsg.EventId == 0 {
+			p.SetOwnerEventEnable(enableMsg.Enable)
+			return nil
+		}
+		return p.SetEventEnable(events.EventId(enableMsg.EventId), enableMsg.Enable)
+	}
+	return nil
+}
+
+func ProcessEventNotification(rxBuf []byte) error {
+	return DefaultEventPublisher().ProcessEventNotification(rxBuf)
+}
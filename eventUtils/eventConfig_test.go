@@ -0,0 +1,92 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package eventUtils
+
+import (
+	"encoding/json"
+	"infra/sysd/sysdCommonDefs"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"utils/logging"
+)
+
+func writeEventsFile(t *testing.T, path string, evtJson EventJson) {
+	data, _ := json.Marshal(evtJson)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventReloadAndEnable(t *testing.T) {
+	eventsFile := filepath.Join(t.TempDir(), "events.json")
+	asicd := DaemonEvent{DaemonId: 1, DaemonName: "ASICD", DaemonEventEnable: true, EventList: []EventStruct{
+		{EventId: 1, EventName: "PortOperStateDown", EventEnable: true},
+	}}
+	writeEventsFile(t, eventsFile, EventJson{DaemonEvents: []DaemonEvent{asicd}})
+	p, err := NewEventPublisherFromFile("ASICD", eventsFile, newTestPubHdl(), logging.NewMemoryLogger("test", sysdCommonDefs.INFO), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.SetEventEnable(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetEventEnable(5, false); err == nil {
+		t.Error("enabled an unknown event")
+	}
+
+	//a fault cleared by an unknown event is rejected, the active definitions stay
+	asicd.EventList = append(asicd.EventList, EventStruct{EventId: 2, EventName: "PortOperStateUp", EventEnable: true,
+		IsFault: true, Fault: FaultDetail{RaiseFault: true, ClearingDaemonId: 1, ClearingEventId: 3}})
+	writeEventsFile(t, eventsFile, EventJson{DaemonEvents: []DaemonEvent{asicd}})
+	if err := p.ReloadEvents(); err == nil {
+		t.Error("reloaded invalid definitions")
+	}
+	if eventMap := p.GetEventDetails(); len(eventMap) != 1 {
+		t.Errorf("event definitions %+v after an invalid reload, expected the active ones", eventMap)
+	}
+
+	asicd.EventList[1].Fault.ClearingEventId = 1
+	asicd.EventList[1].Description = "Port operational state up"
+	writeEventsFile(t, eventsFile, EventJson{DaemonEvents: []DaemonEvent{asicd}})
+	p.checkEventsFile()
+	eventMap := p.GetEventDetails()
+	if len(eventMap) != 2 || eventMap[1].Enable || !eventMap[2].Enable {
+		t.Errorf("event definitions %+v, expected the new event and the runtime disable kept", eventMap)
+	}
+
+	payload, _ := json.Marshal(EventEnableNotification{OwnerName: "asicd", Enable: false})
+	notification, _ := json.Marshal(sysdCommonDefs.Notification{Type: EventNotifyEnable, Payload: payload})
+	if err := p.ProcessEventNotification(notification); err != nil {
+		t.Fatal(err)
+	}
+	if p.IsOwnerEventEnabled() {
+		t.Error("owner events enabled after the disable notification")
+	}
+	notification, _ = json.Marshal(sysdCommonDefs.Notification{Type: EventNotifyReload})
+	if err := p.ProcessEventNotification(notification); err != nil || p.IsOwnerEventEnabled() {
+		t.Errorf("reload err %v, owner enable not kept across the reload", err)
+	}
+}
@@ -0,0 +1,367 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// eventDelivery.go
+package eventUtils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+//what PublishEvents does when the publish queue is full. The newest event is dropped by
+//default so that a stalled DB never blocks the callers
+const (
+	EventOverflowBlock = iota
+	EventOverflowDropNewest
+	EventOverflowDropOldest
+)
+
+const (
+	EventStoreRetryCount     int           = 3
+	EventStoreRetryBackoff   time.Duration = 100 * time.Millisecond
+	EventStoreMaxBackoff     time.Duration = 2 * time.Second
+	EventSpoolReplayInterval time.Duration = 5 * time.Second
+)
+
+var ErrEventQueueFull = errors.New("Event publish queue is full")
+
+var errEventReplayInProgress = errors.New("Event spool replay in progress")
+
+//counters of the event publication
+type EventStats struct {
+	Queued        uint64
+	Published     uint64
+	Dropped       uint64 //dropped on a full queue, or when the DB and the spool both failed
+	StoreRetries  uint64
+	StoreFailures uint64
+	Spooled       uint64
+	Replayed      uint64
+	Duplicates    uint64 //spooled events found in the DB on replay
+	Trimmed       uint64 //deleted by the retention
+}
+
+//event waiting in the spool file for the DB
+type spooledEvent struct {
+	Key   string
+	Desc  string
+	Owner string
+	Msg   []byte
+}
+
+type eventDeliveryState struct {
+	sync.Mutex
+	overflowPolicy int
+	retryCount     int
+	retryBackoff   time.Duration
+	spoolFile      string
+	spoolPending   bool
+	storeDown      bool //set when the retries are exhausted, events go to the spool until a replay succeeds
+	replaying      bool
+	stats          EventStats
+}
+
+func newEventDeliveryState() eventDeliveryState {
+	return eventDeliveryState{
+		overflowPolicy: EventOverflowDropNewest,
+		retryCount:     EventStoreRetryCount,
+		retryBackoff:   EventStoreRetryBackoff,
+	}
+}
+
+func (p *EventPublisher) SetEventOverflowPolicy(policy int) {
+	p.delivery.Lock()
+	p.delivery.overflowPolicy = policy
+	p.delivery.Unlock()
+}
+
+//number of retries of a failed DB store, the backoff doubles after each retry
+func (p *EventPublisher) SetEventStoreRetry(count int, backoff time.Duration) {
+	p.delivery.Lock()
+	p.delivery.retryCount = count
+	p.delivery.retryBackoff = backoff
+	p.delivery.Unlock()
+}
+
+//file keeping the events which could not be stored while the DB is down. They are replayed
+//once the DB is reachable, including the ones left by a previous run. An empty path disables
+//the spool and such events are dropped
+func (p *EventPublisher) SetEventSpoolFile(path string) error {
+	pending := false
+	if path != "" {
+		info, err := os.Stat(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		pending = err == nil && info.Size() > 0
+	}
+	p.delivery.Lock()
+	p.delivery.spoolFile = path
+	p.delivery.spoolPending = pending
+	p.delivery.Unlock()
+	return nil
+}
+
+func (p *EventPublisher) GetEventStats() EventStats {
+	p.delivery.Lock()
+	defer p.delivery.Unlock()
+	return p.delivery.stats
+}
+
+func SetEventOverflowPolicy(policy int) {
+	DefaultEventPublisher().SetEventOverflowPolicy(policy)
+}
+
+func SetEventStoreRetry(count int, backoff time.Duration) {
+	DefaultEventPublisher().SetEventStoreRetry(count, backoff)
+}
+
+func SetEventSpoolFile(path string) error {
+	return DefaultEventPublisher().SetEventSpoolFile(path)
+}
+
+func GetEventStats() EventStats {
+	return DefaultEventPublisher().GetEventStats()
+}
+
+func (p *EventPublisher) countEvent(counter *uint64, delta uint64) {
+	p.delivery.Lock()
+	*counter += delta
+	p.delivery.Unlock()
+}
+
+func (p *EventPublisher) enqueueEvent(recvdEvt RecvdEvent) error {
+	p.delivery.Lock()
+	policy := p.delivery.overflowPolicy
+	p.delivery.Unlock()
+	switch policy {
+	case EventOverflowDropNewest:
+		select {
+		case p.publishCh <- recvdEvt:
+		default:
+			p.countEvent(&p.delivery.stats.Dropped, 1)
+			return ErrEventQueueFull
+		}
+	case EventOverflowDropOldest:
+		for queued := false; !queued; {
+			select {
+			case p.publishCh <- recvdEvt:
+				queued = true
+			default:
+				select {
+				case <-p.publishCh:
+					p.countEvent(&p.delivery.stats.Dropped, 1)
+				default:
+				}
+			}
+		}
+	default:
+		p.publishCh <- recvdEvt
+	}
+	p.countEvent(&p.delivery.stats.Queued, 1)
+	return nil
+}
+
+//stores the event in the DB and publishes it, spools it when the DB stays unreachable.
+//The key is built when the event is raised so that a replay overwrites the same entry
+func (p *EventPublisher) deliverEvent(key string, desc string, owner string, msg []byte) error {
+	p.delivery.Lock()
+	storeDown := p.delivery.storeDown && p.delivery.spoolFile != ""
+	spoolPending := p.delivery.spoolPending
+	p.delivery.Unlock()
+	if !storeDown && spoolPending {
+		//the older spooled events go first, the event waits behind them if they cannot be stored
+		if err := p.replayEventSpool(); err != nil {
+			p.logger.Err(fmt.Sprintln("Event spool replay failed, err:", err))
+			if err != errEventReplayInProgress {
+				p.delivery.Lock()
+				p.delivery.storeDown = true
+				p.delivery.Unlock()
+			}
+			return p.spoolEvent(spooledEvent{Key: key, Desc: desc, Owner: owner, Msg: msg})
+		}
+	}
+	if !storeDown {
+		err := p.storeEventWithRetry(key, desc)
+		if err == nil {
+			if err = indexEvent(p.pubHdl, key); err != nil {
+				p.logger.Err(fmt.Sprintln("Indexing event", key, "failed, err:", err))
+			}
+			p.pubHdl.Publish("PUBLISH", owner, msg)
+			p.countEvent(&p.delivery.stats.Published, 1)
+			return nil
+		}
+		p.logger.Err(fmt.Sprintln("Storing Events in database failed, err:", err))
+		p.countEvent(&p.delivery.stats.StoreFailures, 1)
+		p.delivery.Lock()
+		p.delivery.storeDown = true
+		p.delivery.Unlock()
+	}
+	return p.spoolEvent(spooledEvent{Key: key, Desc: desc, Owner: owner, Msg: msg})
+}
+
+func (p *EventPublisher) storeEventWithRetry(key string, desc string) error {
+	p.delivery.Lock()
+	retryCount := p.delivery.retryCount
+	backoff := p.delivery.retryBackoff
+	p.delivery.Unlock()
+	err := p.pubHdl.StoreValInDb(key, desc, "Desc")
+	for retry := 0; err != nil && retry < retryCount; retry++ {
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > EventStoreMaxBackoff {
+			backoff = EventStoreMaxBackoff
+		}
+		p.countEvent(&p.delivery.stats.StoreRetries, 1)
+		err = p.pubHdl.StoreValInDb(key, desc, "Desc")
+	}
+	return err
+}
+
+func (p *EventPublisher) spoolEvent(spooled spooledEvent) error {
+	p.delivery.Lock()
+	defer p.delivery.Unlock()
+	if p.delivery.spoolFile == "" {
+		p.delivery.stats.Dropped++
+		return errors.New(fmt.Sprintln("Event", spooled.Key, "dropped, database unreachable and no spool file"))
+	}
+	line, err := json.Marshal(spooled)
+	if err == nil {
+		var file *os.File
+		file, err = os.OpenFile(p.delivery.spoolFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err == nil {
+			_, err = file.Write(append(line, '\n'))
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		p.delivery.stats.Dropped++
+		return errors.New(fmt.Sprintln("Failed to spool event", spooled.Key, "err:", err))
+	}
+	p.delivery.stats.Spooled++
+	p.delivery.spoolPending = true
+	return nil
+}
+
+//stores the spooled events in order and stops at the first failure, the rest stays in the spool.
+//Events already in the DB are not stored or published again. The DB is accessed without the
+//delivery lock held, the events spooled in the meantime are kept behind the ones left
+func (p *EventPublisher) replayEventSpool() error {
+	p.delivery.Lock()
+	spoolFile := p.delivery.spoolFile
+	if spoolFile == "" {
+		p.delivery.storeDown = false
+		p.delivery.Unlock()
+		return nil
+	}
+	if p.delivery.replaying {
+		p.delivery.Unlock()
+		return errEventReplayInProgress
+	}
+	data, err := ioutil.ReadFile(spoolFile)
+	if err != nil && !os.IsNotExist(err) {
+		p.delivery.Unlock()
+		return err
+	}
+	p.delivery.replaying = true
+	p.delivery.Unlock()
+
+	var remaining bytes.Buffer
+	var replayErr error
+	var replayed, duplicates uint64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if replayErr != nil {
+			remaining.Write(line)
+			remaining.WriteByte('\n')
+			continue
+		}
+		var spooled spooledEvent
+		if err := json.Unmarshal(line, &spooled); err != nil {
+			p.logger.Err(fmt.Sprintln("Discarding corrupt spooled event:", string(line)))
+			continue
+		}
+		stored, err := p.pubHdl.GetValFromDB(spooled.Key, "Desc")
+		if err == nil && stored != nil {
+			duplicates++
+			indexEvent(p.pubHdl, spooled.Key)
+			continue
+		}
+		if err == nil {
+			err = p.pubHdl.StoreValInDb(spooled.Key, spooled.Desc, "Desc")
+		}
+		if err == nil {
+			err = indexEvent(p.pubHdl, spooled.Key)
+		}
+		if err != nil {
+			replayErr = err
+			remaining.Write(line)
+			remaining.WriteByte('\n')
+			continue
+		}
+		p.pubHdl.Publish("PUBLISH", spooled.Owner, spooled.Msg)
+		replayed++
+	}
+
+	p.delivery.Lock()
+	defer p.delivery.Unlock()
+	p.delivery.replaying = false
+	p.delivery.stats.Replayed += replayed
+	p.delivery.stats.Published += replayed
+	p.delivery.stats.Duplicates += duplicates
+	//the spool is only appended to while replaying
+	if current, err := ioutil.ReadFile(spoolFile); err == nil && len(current) > len(data) {
+		remaining.Write(current[len(data):])
+	}
+	if remaining.Len() == 0 {
+		err = os.Remove(spoolFile)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		tmpFile := spoolFile + ".tmp"
+		err = ioutil.WriteFile(tmpFile, remaining.Bytes(), 0644)
+		if err == nil {
+			err = os.Rename(tmpFile, spoolFile)
+		}
+	}
+	if err != nil {
+		p.logger.Err(fmt.Sprintln("Failed to rewrite the event spool file, err:", err))
+	}
+	p.delivery.spoolPending = remaining.Len() > 0
+	if replayErr != nil {
+		return replayErr
+	}
+	p.delivery.storeDown = false
+	return err
+}
@@ -0,0 +1,211 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package eventUtils
+
+import (
+	"errors"
+	"infra/sysd/sysdCommonDefs"
+	"path/filepath"
+	"testing"
+	"utils/logging"
+)
+
+//in-memory PubIntf, the store fails while down is set
+type testPubHdl struct {
+	down      bool
+	db        map[string]string
+	published []string
+}
+
+func newTestPubHdl() *testPubHdl {
+	return &testPubHdl{db: make(map[string]string)}
+}
+
+func (pub *testPubHdl) Publish(op string, channel interface{}, msg interface{}) {
+	pub.published = append(pub.published, string(msg.([]byte)))
+}
+
+func (pub *testPubHdl) StoreValInDb(key interface{}, val interface{}, field interface{}) error {
+	if pub.down {
+		return errors.New("connection refused")
+	}
+	pub.db[key.(string)] = val.(string)
+	return nil
+}
+
+func (pub *testPubHdl) GetAllKeys(pattern interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+func (pub *testPubHdl) GetValFromDB(key interface{}, field interface{}) (interface{}, error) {
+	if pub.down {
+		return nil, errors.New("connection refused")
+	}
+	if val, ok := pub.db[key.(string)]; ok {
+		return []byte(val), nil
+	}
+	return nil, nil
+}
+
+//publisher without the event handler, the tests drive it
+func newTestPublisher(pubHdl PubIntf, evtChBufSize int32) *EventPublisher {
+	p := newEventPublisher("ASICD", pubHdl, logging.NewMemoryLogger("test", sysdCommonDefs.INFO), evtChBufSize)
+	p.started = true
+	return p
+}
+
+func TestEventSpoolReplay(t *testing.T) {
+	pub := newTestPubHdl()
+	p := newTestPublisher(pub, 0)
+	spoolFile := filepath.Join(t.TempDir(), "events.spool")
+	if err := p.SetEventSpoolFile(spoolFile); err != nil {
+		t.Fatal(err)
+	}
+	p.SetEventStoreRetry(2, 0)
+	pub.down = true
+	p.deliverEvent("Events#ARPD#e1#1#", "first", "ARPD", []byte("m1"))
+	p.deliverEvent("Events#ARPD#e2#2#", "second", "ARPD", []byte("m2"))
+	p.deliverEvent("Events#ARPD#e3#3#", "third", "ARPD", []byte("m3"))
+	stats := p.GetEventStats()
+	if stats.Spooled != 3 || stats.StoreRetries != 2 || stats.StoreFailures != 1 || len(pub.published) != 0 {
+		t.Fatalf("stats %+v published %v, expected 3 spooled events after one retried store", stats, pub.published)
+	}
+	if err := p.replayEventSpool(); err == nil {
+		t.Error("replay succeeded with the DB down")
+	}
+	//the second event made it to the DB before the connection was lost
+	pub.down = false
+	pub.db["Events#ARPD#e2#2#"] = "second"
+	if err := p.replayEventSpool(); err != nil {
+		t.Fatal(err)
+	}
+	p.deliverEvent("Events#ARPD#e4#4#", "fourth", "ARPD", []byte("m4"))
+	stats = p.GetEventStats()
+	if stats.Replayed != 2 || stats.Duplicates != 1 || stats.Published != 3 {
+		t.Errorf("stats %+v, expected 2 replayed and 1 duplicate", stats)
+	}
+	if len(pub.db) != 4 || len(pub.published) != 3 || pub.published[0] != "m1" || pub.published[2] != "m4" {
+		t.Errorf("db %v published %v", pub.db, pub.published)
+	}
+	if matches, _ := filepath.Glob(spoolFile + "*"); len(matches) != 0 {
+		t.Errorf("spool files %v left after the replay", matches)
+	}
+}
+
+func TestEventSpoolReplayedBeforeNewEvent(t *testing.T) {
+	spoolFile := filepath.Join(t.TempDir(), "events.spool")
+	down := newTestPubHdl()
+	down.down = true
+	p := newTestPublisher(down, 0)
+	if err := p.SetEventSpoolFile(spoolFile); err != nil {
+		t.Fatal(err)
+	}
+	p.SetEventStoreRetry(0, 0)
+	p.deliverEvent("Events#ARPD#e1#1#", "first", "ARPD", []byte("m1"))
+	//restarted with the DB back, the spool is found pending
+	pub := newTestPubHdl()
+	p = newTestPublisher(pub, 0)
+	if err := p.SetEventSpoolFile(spoolFile); err != nil {
+		t.Fatal(err)
+	}
+	p.deliverEvent("Events#ARPD#e2#2#", "second", "ARPD", []byte("m2"))
+	if len(pub.published) != 2 || pub.published[0] != "m1" || pub.published[1] != "m2" {
+		t.Errorf("published %v, expected the spooled event first", pub.published)
+	}
+}
+
+//blocks the stores until release is closed
+type blockingPubHdl struct {
+	*testPubHdl
+	storing chan struct{}
+	release chan struct{}
+}
+
+func (pub *blockingPubHdl) StoreValInDb(key interface{}, val interface{}, field interface{}) error {
+	pub.storing <- struct{}{}
+	<-pub.release
+	return pub.testPubHdl.StoreValInDb(key, val, field)
+}
+
+func TestEventSpoolReplayWithoutLock(t *testing.T) {
+	spoolFile := filepath.Join(t.TempDir(), "events.spool")
+	down := newTestPubHdl()
+	down.down = true
+	p := newTestPublisher(down, 0)
+	if err := p.SetEventSpoolFile(spoolFile); err != nil {
+		t.Fatal(err)
+	}
+	p.SetEventStoreRetry(0, 0)
+	p.deliverEvent("Events#ARPD#e1#1#", "first", "ARPD", []byte("m1"))
+	pub := &blockingPubHdl{testPubHdl: newTestPubHdl(), storing: make(chan struct{}), release: make(chan struct{})}
+	p.pubHdl = pub
+	replayDone := make(chan error)
+	go func() {
+		replayDone <- p.replayEventSpool()
+	}()
+	<-pub.storing
+	//the stats and the spool stay available while the replay waits for the DB
+	if stats := p.GetEventStats(); stats.Spooled != 1 {
+		t.Errorf("stats %+v during the replay", stats)
+	}
+	p.spoolEvent(spooledEvent{Key: "Events#ARPD#e2#2#", Desc: "second", Owner: "ARPD", Msg: []byte("m2")})
+	close(pub.release)
+	if err := <-replayDone; err != nil {
+		t.Fatal(err)
+	}
+	if len(pub.published) != 1 || pub.published[0] != "m1" {
+		t.Errorf("published %v, expected m1", pub.published)
+	}
+	//the event spooled during the replay is kept for the next one
+	go func() {
+		for range pub.storing {
+		}
+	}()
+	if err := p.replayEventSpool(); err != nil {
+		t.Fatal(err)
+	}
+	close(pub.storing)
+	if len(pub.published) != 2 || pub.published[1] != "m2" {
+		t.Errorf("published %v, expected m2 replayed", pub.published)
+	}
+}
+
+func TestEventQueueOverflow(t *testing.T) {
+	p := newTestPublisher(newTestPubHdl(), 1)
+	//the newest event is dropped by default
+	p.PublishEvents(1, "k1", "")
+	if err := p.PublishEvents(2, "k2", ""); err != ErrEventQueueFull {
+		t.Errorf("err %v, expected a full queue", err)
+	}
+	p.SetEventOverflowPolicy(EventOverflowDropOldest)
+	if err := p.PublishEvents(3, "k3", ""); err != nil {
+		t.Error(err)
+	}
+	if queued := <-p.publishCh; queued.eventId != 3 {
+		t.Errorf("queued event %d, expected the newest", queued.eventId)
+	}
+	if stats := p.GetEventStats(); stats.Queued != 2 || stats.Dropped != 2 {
+		t.Errorf("stats %+v, expected 2 queued and 2 dropped", stats)
+	}
+}
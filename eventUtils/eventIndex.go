@@ -0,0 +1,407 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// eventIndex.go
+package eventUtils
+
+import (
+	"fmt"
+	"math"
+	"models/events"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"utils/logging"
+	"utils/typeConv"
+)
+
+//sorted sets indexing the event keys by time, the score is the event time in microseconds
+const (
+	EventIndexAll     string = "EventIndex#All"
+	EventIndexOwner   string = "EventIndex#Owner#"
+	EventIndexName    string = "EventIndex#Name#"
+	EventIndexObjName string = "EventIndex#ObjName#"
+	EventIndexObjKey  string = "EventIndex#ObjKey#"
+)
+
+const (
+	EventRetentionInterval time.Duration = time.Minute
+	EventIndexBatchSize    int           = 256
+)
+
+//sorted set operations of the DB handle, implemented by dbutils.DBUtil. Without them the events
+//are queried with a scan of the keys and never trimmed
+type EventIndexIntf interface {
+	AddToSortedSet(key interface{}, score int64, member interface{}) error
+	GetSortedSetRangeByScore(key interface{}, min, max int64, offset, count int, reverse bool) ([]string, error)
+	GetSortedSetCount(key interface{}) (int, error)
+	RemoveFromSortedSet(key interface{}, members ...interface{}) error
+	DeleteKeys(keys ...interface{}) error
+}
+
+//event query, empty fields match all the events. The events are returned newest first
+type EventQuery struct {
+	OwnerName  string
+	EventName  string
+	SrcObjName string
+	SrcObjKey  string
+	StartTime  time.Time //zero for no lower bound
+	EndTime    time.Time //zero for no upper bound
+	Offset     int
+	Limit      int //0 for no limit
+}
+
+//fields of a stored event key, Events#owner#name#objName#objKey#time#unixNano#
+type storedEventKey struct {
+	key       string
+	owner     string
+	name      string
+	objName   string
+	objKey    string
+	timeStamp string
+	uTime     int64
+}
+
+type eventRetentionState struct {
+	sync.Mutex
+	maxAge   time.Duration
+	maxCount int
+}
+
+//events older than maxAge or beyond the newest maxCount ones are deleted, 0 disables the limit
+func (p *EventPublisher) SetEventRetention(maxAge time.Duration, maxCount int) {
+	p.retention.Lock()
+	p.retention.maxAge = maxAge
+	p.retention.maxCount = maxCount
+	p.retention.Unlock()
+}
+
+func SetEventRetention(maxAge time.Duration, maxCount int) {
+	DefaultEventPublisher().SetEventRetention(maxAge, maxCount)
+}
+
+func parseEventKey(key string) (storedEventKey, bool) {
+	str := strings.Split(key, "#")
+	if len(str) < 8 || str[0] != "Events" {
+		return storedEventKey{}, false
+	}
+	uTime, err := strconv.ParseInt(str[len(str)-2], 10, 64)
+	if err != nil {
+		return storedEventKey{}, false
+	}
+	return storedEventKey{
+		key:       key,
+		owner:     str[1],
+		name:      str[2],
+		objName:   str[3],
+		objKey:    str[4],
+		timeStamp: str[5],
+		uTime:     uTime,
+	}, true
+}
+
+func (k storedEventKey) indexKeys() []string {
+	return []string{
+		EventIndexAll,
+		EventIndexOwner + strings.ToUpper(k.owner),
+		EventIndexName + k.name,
+		EventIndexObjName + k.objName,
+		EventIndexObjKey + k.objKey,
+	}
+}
+
+func eventScore(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
+
+//adds a stored event to the indexes, adding it again is harmless
+func indexEvent(pubHdl PubIntf, key string) error {
+	idx, ok := pubHdl.(EventIndexIntf)
+	if !ok {
+		return nil
+	}
+	k, ok := parseEventKey(key)
+	if !ok {
+		return fmt.Errorf("Invalid event key %s", key)
+	}
+	for _, indexKey := range k.indexKeys() {
+		if err := idx.AddToSortedSet(indexKey, k.uTime/int64(time.Microsecond), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//indexes the events stored before the indexes existed, with a single scan of the keys
+func ReindexEvents(pubHdl PubIntf) error {
+	keys, err := typeConv.ConvertToStrings(pubHdl.GetAllKeys("Events#*"))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := indexEvent(pubHdl, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//most selective index for the query
+func (query EventQuery) indexKey() string {
+	switch {
+	case query.SrcObjKey != "":
+		return EventIndexObjKey + query.SrcObjKey
+	case query.EventName != "":
+		return EventIndexName + query.EventName
+	case query.SrcObjName != "":
+		return EventIndexObjName + query.SrcObjName
+	case query.OwnerName != "":
+		return EventIndexOwner + strings.ToUpper(query.OwnerName)
+	}
+	return EventIndexAll
+}
+
+func (query EventQuery) matches(k storedEventKey) bool {
+	if query.OwnerName != "" && !strings.EqualFold(query.OwnerName, k.owner) {
+		return false
+	}
+	if (query.EventName != "" && query.EventName != k.name) ||
+		(query.SrcObjName != "" && query.SrcObjName != k.objName) ||
+		(query.SrcObjKey != "" && query.SrcObjKey != k.objKey) {
+		return false
+	}
+	uTime := k.uTime / int64(time.Microsecond)
+	if !query.StartTime.IsZero() && uTime < eventScore(query.StartTime) {
+		return false
+	}
+	if !query.EndTime.IsZero() && uTime > eventScore(query.EndTime) {
+		return false
+	}
+	return true
+}
+
+func (query EventQuery) scoreRange() (int64, int64) {
+	min, max := int64(0), int64(math.MaxInt64)
+	if !query.StartTime.IsZero() {
+		min = eventScore(query.StartTime)
+	}
+	if !query.EndTime.IsZero() {
+		max = eventScore(query.EndTime)
+	}
+	return min, max
+}
+
+//runs the query on the indexes when the DB handle has them, otherwise on a scan of the keys
+func QueryEvents(query EventQuery, pubHdl PubIntf, logger logging.LoggerIntf) (evt []events.EventObject, err error) {
+	stored, err := queryStoredEvents(query, pubHdl, logger)
+	for _, streamed := range stored {
+		evt = append(evt, streamed.EventObject)
+	}
+	return evt, err
+}
+
+func queryStoredEvents(query EventQuery, pubHdl PubIntf, logger logging.LoggerIntf) (evt []StreamedEvent, err error) {
+	idx, ok := pubHdl.(EventIndexIntf)
+	if !ok {
+		return scanEvents(query, pubHdl, logger)
+	}
+	indexKey := query.indexKey()
+	min, max := query.scoreRange()
+	skipped := 0
+	for pageOffset := 0; ; pageOffset += EventIndexBatchSize {
+		keys, err := idx.GetSortedSetRangeByScore(indexKey, min, max, pageOffset, EventIndexBatchSize, true)
+		if err != nil {
+			logger.Err(fmt.Sprintln("Error querying the event index", indexKey, "err:", err))
+			return evt, err
+		}
+		for _, key := range keys {
+			k, ok := parseEventKey(key)
+			if !ok || !query.matches(k) {
+				continue
+			}
+			if skipped < query.Offset {
+				skipped++
+				continue
+			}
+			obj, err := getEventObject(k, pubHdl)
+			if err != nil {
+				//trimmed after the index was read
+				continue
+			}
+			evt = append(evt, obj)
+			if query.Limit > 0 && len(evt) == query.Limit {
+				return evt, nil
+			}
+		}
+		if len(keys) < EventIndexBatchSize {
+			return evt, nil
+		}
+	}
+}
+
+func getEventObject(k storedEventKey, pubHdl PubIntf) (StreamedEvent, error) {
+	desc, err := typeConv.ConvertToString(pubHdl.GetValFromDB(k.key, "Desc"))
+	if err != nil {
+		return StreamedEvent{}, err
+	}
+	obj := events.EventObject{
+		OwnerName:   k.owner,
+		EventName:   k.name,
+		TimeStamp:   k.timeStamp,
+		Description: desc,
+		SrcObjName:  k.objName,
+		SrcObjKey:   k.objKey,
+	}
+	return StreamedEvent{EventObject: obj, Id: k.uTime}, nil
+}
+
+//query on a scan of the keys, for DB handles without the sorted sets
+func scanEvents(query EventQuery, pubHdl PubIntf, logger logging.LoggerIntf) (evt []StreamedEvent, err error) {
+	qPattern := constructQueryPattern(events.EventObject{
+		OwnerName:  query.OwnerName,
+		EventName:  query.EventName,
+		SrcObjName: query.SrcObjName,
+		SrcObjKey:  query.SrcObjKey,
+	})
+	keys, err := typeConv.ConvertToStrings(pubHdl.GetAllKeys(qPattern))
+	if err != nil {
+		logger.Err(fmt.Sprintln("Error querying for keys:", err))
+	}
+	keySlice := constructKeySlice(keys)
+	if keySlice == nil {
+		logger.Err("Key slice is nil")
+	}
+	sort.Sort(KeyObjSlice(keySlice))
+	skipped := 0
+	for _, keyObj := range keySlice {
+		k, ok := parseEventKey(keyObj.Key)
+		if !ok || !query.matches(k) {
+			continue
+		}
+		if skipped < query.Offset {
+			skipped++
+			continue
+		}
+		obj, err := getEventObject(k, pubHdl)
+		if err != nil {
+			logger.Err(fmt.Sprintln("Error getting the value from DB", err))
+			continue
+		}
+		evt = append(evt, obj)
+		if query.Limit > 0 && len(evt) == query.Limit {
+			break
+		}
+	}
+	return evt, err
+}
+
+//deletes the events beyond the retention limits, oldest first and in batches
+func (p *EventPublisher) trimEvents() error {
+	idx, ok := p.pubHdl.(EventIndexIntf)
+	if !ok {
+		return nil
+	}
+	p.retention.Lock()
+	maxAge := p.retention.maxAge
+	maxCount := p.retention.maxCount
+	p.retention.Unlock()
+	if maxAge > 0 {
+		cutoff := eventScore(time.Now().Add(-maxAge))
+		for {
+			keys, err := idx.GetSortedSetRangeByScore(EventIndexAll, 0, cutoff, 0, EventIndexBatchSize, false)
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				break
+			}
+			if err = p.trimEventKeys(idx, keys); err != nil {
+				return err
+			}
+		}
+	}
+	if maxCount <= 0 {
+		return nil
+	}
+	count, err := idx.GetSortedSetCount(EventIndexAll)
+	if err != nil {
+		return err
+	}
+	for excess := count - maxCount; excess > 0; {
+		batch := excess
+		if batch > EventIndexBatchSize {
+			batch = EventIndexBatchSize
+		}
+		keys, err := idx.GetSortedSetRangeByScore(EventIndexAll, 0, math.MaxInt64, 0, batch, false)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if err = p.trimEventKeys(idx, keys); err != nil {
+			return err
+		}
+		excess -= len(keys)
+	}
+	return nil
+}
+
+func (p *EventPublisher) trimEventKeys(idx EventIndexIntf, keys []string) error {
+	if err := removeEvents(idx, keys); err != nil {
+		return err
+	}
+	p.countEvent(&p.delivery.stats.Trimmed, uint64(len(keys)))
+	return nil
+}
+
+func removeEvents(idx EventIndexIntf, keys []string) error {
+	members := make(map[string][]interface{})
+	dbKeys := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		dbKeys = append(dbKeys, key)
+		k, ok := parseEventKey(key)
+		if !ok {
+			members[EventIndexAll] = append(members[EventIndexAll], key)
+			continue
+		}
+		for _, indexKey := range k.indexKeys() {
+			members[indexKey] = append(members[indexKey], key)
+		}
+	}
+	if err := idx.DeleteKeys(dbKeys...); err != nil {
+		return err
+	}
+	//the all index last, so that a failure leaves the events to trim again
+	for indexKey, indexMembers := range members {
+		if indexKey == EventIndexAll {
+			continue
+		}
+		if err := idx.RemoveFromSortedSet(indexKey, indexMembers...); err != nil {
+			return err
+		}
+	}
+	return idx.RemoveFromSortedSet(EventIndexAll, members[EventIndexAll]...)
+}
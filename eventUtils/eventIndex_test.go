@@ -0,0 +1,171 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package eventUtils
+
+import (
+	"fmt"
+	"models/events"
+	"sort"
+	"testing"
+	"time"
+)
+
+//test DB handle with the sorted sets
+type testIndexPubHdl struct {
+	*testPubHdl
+	zsets map[string]map[string]int64
+}
+
+func newTestIndexPubHdl() *testIndexPubHdl {
+	return &testIndexPubHdl{testPubHdl: newTestPubHdl(), zsets: make(map[string]map[string]int64)}
+}
+
+func (pub *testIndexPubHdl) AddToSortedSet(key interface{}, score int64, member interface{}) error {
+	zset, exist := pub.zsets[key.(string)]
+	if !exist {
+		zset = make(map[string]int64)
+		pub.zsets[key.(string)] = zset
+	}
+	zset[member.(string)] = score
+	return nil
+}
+
+func (pub *testIndexPubHdl) GetSortedSetRangeByScore(key interface{}, min, max int64, offset, count int, reverse bool) ([]string, error) {
+	var members []string
+	for member, score := range pub.zsets[key.(string)] {
+		if score >= min && score <= max {
+			members = append(members, member)
+		}
+	}
+	zset := pub.zsets[key.(string)]
+	sort.Slice(members, func(i, j int) bool {
+		if reverse {
+			return zset[members[i]] > zset[members[j]]
+		}
+		return zset[members[i]] < zset[members[j]]
+	})
+	if offset >= len(members) {
+		return nil, nil
+	}
+	members = members[offset:]
+	if count > 0 && count < len(members) {
+		members = members[:count]
+	}
+	return members, nil
+}
+
+func (pub *testIndexPubHdl) GetSortedSetCount(key interface{}) (int, error) {
+	return len(pub.zsets[key.(string)]), nil
+}
+
+func (pub *testIndexPubHdl) RemoveFromSortedSet(key interface{}, members ...interface{}) error {
+	for _, member := range members {
+		delete(pub.zsets[key.(string)], member.(string))
+	}
+	return nil
+}
+
+func (pub *testIndexPubHdl) DeleteKeys(keys ...interface{}) error {
+	for _, key := range keys {
+		delete(pub.db, key.(string))
+	}
+	return nil
+}
+
+func storeTestEvent(p *EventPublisher, owner, name, objName, objKey string, timeStamp time.Time) {
+	key := fmt.Sprintf("Events#%s#%s#%s#%s#%s#%d#", owner, name, objName, objKey, timeStamp.String(), timeStamp.UnixNano())
+	p.deliverEvent(key, name+" on "+objKey, owner, []byte(key))
+}
+
+func TestEventIndexQueries(t *testing.T) {
+	pub := newTestIndexPubHdl()
+	p := newTestPublisher(pub, 0)
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 6; i++ {
+		storeTestEvent(p, "ASICD", "PortOperStateDown", "Port", fmt.Sprintf("fpPort%d", i%2), start.Add(time.Duration(i)*time.Minute))
+	}
+	storeTestEvent(p, "ARPD", "ArpEntryLearned", "ArpEntry", "10.1.1.1", start.Add(10*time.Minute))
+
+	evts, err := QueryEvents(EventQuery{OwnerName: "asicd"}, pub, p.logger)
+	if err != nil || len(evts) != 6 {
+		t.Fatalf("%d ASICD events, err %v, expected 6", len(evts), err)
+	}
+	evts, _ = QueryEvents(EventQuery{SrcObjKey: "fpPort1", StartTime: start.Add(2 * time.Minute), Limit: 1, Offset: 1}, pub, p.logger)
+	if len(evts) != 1 || evts[0].TimeStamp != start.Add(3*time.Minute).String() {
+		t.Errorf("events %+v, expected the fpPort1 event at minute 3", evts)
+	}
+	evts, _ = GetEvents(events.EventObject{OwnerName: "ARPD", EventName: "ArpEntryLearned"}, pub, p.logger)
+	if len(evts) != 1 || evts[0].Description != "ArpEntryLearned on 10.1.1.1" {
+		t.Errorf("events %+v, expected the ARPD event", evts)
+	}
+
+	p.SetEventRetention(0, 4)
+	if err := p.trimEvents(); err != nil {
+		t.Fatal(err)
+	}
+	if len(pub.db) != 4 || len(pub.zsets[EventIndexAll]) != 4 || len(pub.zsets[EventIndexOwner+"ASICD"]) != 3 ||
+		p.GetEventStats().Trimmed != 3 {
+		t.Errorf("%d events and %d indexed left, expected 4", len(pub.db), len(pub.zsets[EventIndexAll]))
+	}
+	p.SetEventRetention(55*time.Minute, 0)
+	p.trimEvents()
+	if evts, _ := QueryEvents(EventQuery{}, pub, p.logger); len(evts) != 1 || evts[0].OwnerName != "ARPD" {
+		t.Errorf("events %+v, expected the ARPD event only", evts)
+	}
+}
+
+//fails the range reads without a limit on the all index
+type limitCheckPubHdl struct {
+	*testIndexPubHdl
+	t *testing.T
+}
+
+func (pub *limitCheckPubHdl) GetSortedSetRangeByScore(key interface{}, min, max int64, offset, count int, reverse bool) ([]string, error) {
+	if key == EventIndexAll && (count <= 0 || count > EventIndexBatchSize) {
+		pub.t.Errorf("unbatched read of %d events from the all index", count)
+	}
+	return pub.testIndexPubHdl.GetSortedSetRangeByScore(key, min, max, offset, count, reverse)
+}
+
+func TestTrimEventsInBatches(t *testing.T) {
+	pub := &limitCheckPubHdl{testIndexPubHdl: newTestIndexPubHdl(), t: t}
+	p := newTestPublisher(pub, 0)
+	start := time.Now().Add(-2 * time.Hour)
+	numExpired := 2*EventIndexBatchSize + 10
+	for i := 0; i < numExpired; i++ {
+		storeTestEvent(p, "ASICD", "PortOperStateDown", "Port", fmt.Sprintf("fpPort%d", i), start.Add(time.Duration(i)*time.Millisecond))
+	}
+	for i := 0; i < 5; i++ {
+		storeTestEvent(p, "ARPD", "ArpEntryLearned", "ArpEntry", fmt.Sprintf("10.1.1.%d", i), time.Now())
+	}
+	p.SetEventRetention(time.Hour, 3)
+	if err := p.trimEvents(); err != nil {
+		t.Fatal(err)
+	}
+	if len(pub.db) != 3 || len(pub.zsets[EventIndexAll]) != 3 || len(pub.zsets[EventIndexOwner+"ASICD"]) != 0 ||
+		p.GetEventStats().Trimmed != uint64(numExpired+2) {
+		t.Errorf("%d events and %d indexed left, trimmed %d, expected 3 left", len(pub.db), len(pub.zsets[EventIndexAll]),
+			p.GetEventStats().Trimmed)
+	}
+}
@@ -0,0 +1,410 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// eventStream.go
+package eventUtils
+
+import (
+	"encoding/json"
+	"fmt"
+	"models/events"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"utils/logging"
+)
+
+const (
+	EventStreamBufSize   int           = 256
+	EventStreamKeepAlive time.Duration = 30 * time.Second
+	//the daemons publish their events on a channel named after the owner, the keyspace
+	//notification channels start with __ and are left out
+	EventChannelPattern string        = "[^_]*"
+	EventFeedMinBackoff time.Duration = 100 * time.Millisecond
+	EventFeedMaxBackoff time.Duration = 10 * time.Second
+)
+
+//DB handle passing the messages published on the channels matching a pattern to handler, until
+//stopCh is closed or the connection fails. dbutils.DBUtil implements it
+type EventChannelIntf interface {
+	PSubscribeChannels(pattern string, stopCh <-chan bool, handler func(channel string, msg []byte)) error
+}
+
+//event sent to the stream subscribers. Id is the event time in nanoseconds, a client resumes
+//after it with the Last-Event-ID header or the since parameter
+type StreamedEvent struct {
+	events.EventObject
+	Id int64
+}
+
+//live events matching a filter. C is closed when the subscription is closed, or when the
+//subscriber falls behind by more than its buffer, the subscriber then resumes from its last event
+type EventSubscription struct {
+	C      <-chan StreamedEvent
+	ch     chan StreamedEvent
+	filter EventQuery
+	set    *eventSubscriberSet
+	closed bool
+}
+
+type eventSubscriberSet struct {
+	sync.Mutex
+	subs       map[*EventSubscription]bool
+	closed     bool
+	feeding    bool //the events come from the DB channels, the ones of this daemon included
+	feedStopCh chan bool
+}
+
+//subscribes to the events matching the filter, empty fields match all the events
+func (p *EventPublisher) SubscribeEvents(filter events.EventObject, bufSize int) *EventSubscription {
+	if bufSize <= 0 {
+		bufSize = EventStreamBufSize
+	}
+	ch := make(chan StreamedEvent, bufSize)
+	sub := &EventSubscription{
+		C:  ch,
+		ch: ch,
+		filter: EventQuery{
+			OwnerName:  filter.OwnerName,
+			EventName:  filter.EventName,
+			SrcObjName: filter.SrcObjName,
+			SrcObjKey:  filter.SrcObjKey,
+		},
+		set: &p.subscribers,
+	}
+	p.subscribers.Lock()
+	if p.subscribers.closed {
+		sub.closeLocked()
+	} else {
+		p.subscribers.subs[sub] = true
+	}
+	p.subscribers.Unlock()
+	return sub
+}
+
+func SubscribeEvents(filter events.EventObject, bufSize int) *EventSubscription {
+	return DefaultEventPublisher().SubscribeEvents(filter, bufSize)
+}
+
+func (sub *EventSubscription) Close() {
+	sub.set.Lock()
+	sub.closeLocked()
+	sub.set.Unlock()
+}
+
+func (sub *EventSubscription) closeLocked() {
+	if !sub.closed {
+		sub.closed = true
+		delete(sub.set.subs, sub)
+		close(sub.ch)
+	}
+}
+
+//closes the subscriptions when the publisher is closed
+func (set *eventSubscriberSet) closeAll() {
+	set.Lock()
+	set.closed = true
+	if set.feeding {
+		close(set.feedStopCh)
+	}
+	for sub := range set.subs {
+		sub.closeLocked()
+	}
+	set.Unlock()
+}
+
+func toStreamedEvent(evt *events.Event) (StreamedEvent, storedEventKey) {
+	streamed := StreamedEvent{
+		EventObject: events.EventObject{
+			OwnerName:   evt.OwnerName,
+			EventName:   evt.EventName,
+			TimeStamp:   evt.TimeStamp.String(),
+			Description: evt.Description,
+			SrcObjName:  evt.SrcObjName,
+			SrcObjKey:   srcObjKeyString(evt.SrcObjKey),
+		},
+		Id: evt.TimeStamp.UnixNano(),
+	}
+	k := storedEventKey{
+		owner:   streamed.OwnerName,
+		name:    streamed.EventName,
+		objName: streamed.SrcObjName,
+		objKey:  streamed.SrcObjKey,
+		uTime:   streamed.Id,
+	}
+	return streamed, k
+}
+
+//passes an event to the matching subscribers. The events published by this daemon are dispatched
+//by the package, a daemon subscribed to the events of the others dispatches them here
+func (p *EventPublisher) DispatchEvent(evt *events.Event) {
+	streamed, k := toStreamedEvent(evt)
+	p.subscribers.Lock()
+	defer p.subscribers.Unlock()
+	for sub := range p.subscribers.subs {
+		if !sub.filter.matches(k) {
+			continue
+		}
+		select {
+		case sub.ch <- streamed:
+		default:
+			sub.closeLocked()
+		}
+	}
+}
+
+func DispatchEvent(evt *events.Event) {
+	DefaultEventPublisher().DispatchEvent(evt)
+}
+
+//dispatches an event published by this daemon, unless it comes back from the DB channels
+func (p *EventPublisher) dispatchLocalEvent(evt *events.Event) {
+	p.subscribers.Lock()
+	feeding := p.subscribers.feeding
+	p.subscribers.Unlock()
+	if !feeding {
+		p.DispatchEvent(evt)
+	}
+}
+
+//feeds the subscribers with the events all the daemons publish on the DB, when pubHdl can
+//subscribe to the DB channels. The feed is started once and stops when the publisher is closed
+func (p *EventPublisher) startEventFeed(pubHdl PubIntf, logger logging.LoggerIntf) {
+	channels, ok := pubHdl.(EventChannelIntf)
+	if !ok {
+		return
+	}
+	p.subscribers.Lock()
+	defer p.subscribers.Unlock()
+	if p.subscribers.feeding || p.subscribers.closed {
+		return
+	}
+	p.subscribers.feeding = true
+	p.subscribers.feedStopCh = make(chan bool)
+	go p.feedEvents(channels, p.subscribers.feedStopCh, logger)
+}
+
+//subscribes to the event channels again after a failure, waiting longer after each one
+func (p *EventPublisher) feedEvents(channels EventChannelIntf, stopCh chan bool, logger logging.LoggerIntf) {
+	backoff := EventFeedMinBackoff
+	for {
+		start := time.Now()
+		err := channels.PSubscribeChannels(EventChannelPattern, stopCh, p.dispatchPublishedEvent(logger))
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		logger.Err(fmt.Sprintln("Lost the subscription to the event channels, err:", err))
+		if time.Since(start) > EventFeedMaxBackoff {
+			backoff = EventFeedMinBackoff
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > EventFeedMaxBackoff {
+			backoff = EventFeedMaxBackoff
+		}
+	}
+}
+
+//the messages of the other publishers of the channels are skipped
+func (p *EventPublisher) dispatchPublishedEvent(logger logging.LoggerIntf) func(string, []byte) {
+	return func(channel string, msg []byte) {
+		var evt events.Event
+		if err := json.Unmarshal(msg, &evt); err != nil || evt.EventName == "" || evt.OwnerName != channel {
+			logger.Debug(fmt.Sprintln("Skipping the message of channel", channel, "not an event"))
+			return
+		}
+		p.DispatchEvent(&evt)
+	}
+}
+
+//http handler streaming the events of the publisher as Server-Sent Events when the client accepts
+//text/event-stream, as one JSON object per line otherwise. When the DB handle implements
+//EventChannelIntf the events of all the daemons are streamed, not only the ones of the publisher.
+//The filter is given by the OwnerName, EventName, SrcObjName and SrcObjKey parameters or by an
+//events.EventObject in the body. The stored events after the Last-Event-ID header or the since
+//parameter are sent before the live ones
+func (p *EventPublisher) EventStreamHandler() http.Handler {
+	return newEventStreamHandler(func() *EventPublisher { return p }, p.pubHdl, p.logger)
+}
+
+//streams the events of the default publisher, the stored ones are read with the given DB handle
+func EventStreamHandler(pubHdl PubIntf, logger logging.LoggerIntf) http.Handler {
+	return newEventStreamHandler(DefaultEventPublisher, pubHdl, logger)
+}
+
+func newEventStreamHandler(publisher func() *EventPublisher, pubHdl PubIntf, logger logging.LoggerIntf) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		filter, err := getStreamFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since, err := getStreamResumeId(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+		if sse {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		//subscribe before reading the stored events so that none is missed in between
+		p := publisher()
+		p.startEventFeed(pubHdl, logger)
+		sub := p.SubscribeEvents(filter, EventStreamBufSize)
+		defer sub.Close()
+		w.WriteHeader(http.StatusOK)
+		//the live events already sent with the stored ones are skipped, the others are sent in the
+		//order they come, the daemons' clocks differ
+		lastId := since
+		var storedIds map[int64]bool
+		if since > 0 {
+			storedIds, lastId, err = writeStoredEvents(w, sse, filter, since, pubHdl, logger)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		keepAlive := time.NewTicker(EventStreamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case streamed, ok := <-sub.C:
+				if !ok {
+					logger.Info(fmt.Sprintln("Event stream subscriber fell behind, closing the stream at", lastId))
+					return
+				}
+				if storedIds[streamed.Id] {
+					delete(storedIds, streamed.Id)
+					continue
+				}
+				if err := writeStreamedEvent(w, sse, streamed); err != nil {
+					return
+				}
+				lastId = streamed.Id
+				flusher.Flush()
+			case <-keepAlive.C:
+				if sse {
+					fmt.Fprint(w, ": keepalive\n\n")
+				} else {
+					fmt.Fprint(w, "\n")
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+func getStreamFilter(r *http.Request) (events.EventObject, error) {
+	filter, err := GetEventQueryParams(r)
+	if err != nil {
+		return filter, err
+	}
+	params := r.URL.Query()
+	if owner := params.Get("OwnerName"); owner != "" {
+		filter.OwnerName = owner
+	}
+	if name := params.Get("EventName"); name != "" {
+		filter.EventName = name
+	}
+	if objName := params.Get("SrcObjName"); objName != "" {
+		filter.SrcObjName = objName
+	}
+	if objKey := params.Get("SrcObjKey"); objKey != "" {
+		filter.SrcObjKey = objKey
+	}
+	return filter, nil
+}
+
+func getStreamResumeId(r *http.Request) (int64, error) {
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = r.URL.Query().Get("since")
+	}
+	if resume == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(resume, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid resume event id %s", resume)
+	}
+	return id, nil
+}
+
+//sends the stored events after since, oldest first, and returns their ids and the id of the last one
+func writeStoredEvents(w http.ResponseWriter, sse bool, filter events.EventObject, since int64, pubHdl PubIntf, logger logging.LoggerIntf) (map[int64]bool, int64, error) {
+	query := EventQuery{
+		OwnerName:  filter.OwnerName,
+		EventName:  filter.EventName,
+		SrcObjName: filter.SrcObjName,
+		SrcObjKey:  filter.SrcObjKey,
+		StartTime:  time.Unix(0, since+1),
+	}
+	stored, err := queryStoredEvents(query, pubHdl, logger)
+	if err != nil {
+		logger.Err(fmt.Sprintln("Reading the events to resume the stream failed, err:", err))
+	}
+	sent := make(map[int64]bool)
+	lastId := since
+	for i := len(stored) - 1; i >= 0; i-- {
+		if stored[i].Id <= lastId {
+			continue
+		}
+		if err := writeStreamedEvent(w, sse, stored[i]); err != nil {
+			return sent, lastId, err
+		}
+		sent[stored[i].Id] = true
+		lastId = stored[i].Id
+	}
+	return sent, lastId, nil
+}
+
+func writeStreamedEvent(w http.ResponseWriter, sse bool, streamed StreamedEvent) error {
+	data, err := json.Marshal(streamed)
+	if err != nil {
+		return err
+	}
+	if sse {
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", streamed.Id, data)
+	} else {
+		_, err = fmt.Fprintf(w, "%s\n", data)
+	}
+	return err
+}
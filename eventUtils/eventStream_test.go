@@ -0,0 +1,164 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package eventUtils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"models/events"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readServerSentEvent(t *testing.T, reader *bufio.Reader) StreamedEvent {
+	var streamed StreamedEvent
+	var id string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if id != fmt.Sprint(streamed.Id) {
+				t.Errorf("id %s, expected %d", id, streamed.Id)
+			}
+			return streamed
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &streamed); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestEventStreamResume(t *testing.T) {
+	pub := newTestIndexPubHdl()
+	p := newTestPublisher(pub, 0)
+	start := time.Now().Add(-time.Minute)
+	storeTestEvent(p, "ASICD", "PortOperStateDown", "Port", "fpPort1", start)
+	storeTestEvent(p, "ASICD", "PortOperStateUp", "Port", "fpPort1", start.Add(time.Second))
+	server := httptest.NewServer(p.EventStreamHandler())
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/?OwnerName=ASICD&since=%d", server.URL, start.UnixNano()), nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	if streamed := readServerSentEvent(t, reader); streamed.EventName != "PortOperStateUp" || streamed.Id != start.Add(time.Second).UnixNano() {
+		t.Fatalf("resumed with %+v, expected the event after the last seen one", streamed)
+	}
+	//the stored event comes again live and is skipped, an older event of a daemon whose clock is
+	//behind is still sent
+	p.DispatchEvent(&events.Event{OwnerName: "ASICD", EventName: "PortOperStateUp", SrcObjName: "Port",
+		SrcObjKey: map[string]interface{}{"IntfRef": "fpPort1"}, TimeStamp: start.Add(time.Second)})
+	lagging := events.Event{OwnerName: "ASICD", EventName: "PortOperStateDown", SrcObjName: "Port",
+		SrcObjKey: map[string]interface{}{"IntfRef": "fpPort3"}, TimeStamp: start.Add(time.Second / 2)}
+	p.DispatchEvent(&lagging)
+	if streamed := readServerSentEvent(t, reader); streamed.SrcObjKey != "IntfRef:fpPort3" || streamed.Id != lagging.TimeStamp.UnixNano() {
+		t.Errorf("live event %+v, expected the lagging event", streamed)
+	}
+	p.DispatchEvent(&events.Event{OwnerName: "ARPD", EventName: "ArpEntryLearned", TimeStamp: time.Now()})
+	live := events.Event{OwnerName: "ASICD", EventName: "PortOperStateDown", SrcObjName: "Port",
+		SrcObjKey: map[string]interface{}{"IntfRef": "fpPort2"}, TimeStamp: time.Now()}
+	p.DispatchEvent(&live)
+	if streamed := readServerSentEvent(t, reader); streamed.SrcObjKey != "IntfRef:fpPort2" || streamed.Id != live.TimeStamp.UnixNano() {
+		t.Errorf("live event %+v, expected the ASICD event", streamed)
+	}
+}
+
+func TestEventSubscriptionOverflow(t *testing.T) {
+	p := newTestPublisher(newTestPubHdl(), 0)
+	sub := p.SubscribeEvents(events.EventObject{EventName: "PortOperStateDown"}, 1)
+	for i := 0; i < 2; i++ {
+		p.DispatchEvent(&events.Event{OwnerName: "ASICD", EventName: "PortOperStateDown", TimeStamp: time.Now()})
+	}
+	if _, ok := <-sub.C; !ok {
+		t.Fatal("missing the buffered event")
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("subscription still open after falling behind")
+	}
+	sub.Close()
+}
+
+//test DB handle passing the handler of the channel subscription to the test
+type testChannelPubHdl struct {
+	*testPubHdl
+	handlers chan func(string, []byte)
+}
+
+func (pub *testChannelPubHdl) PSubscribeChannels(pattern string, stopCh <-chan bool, handler func(channel string, msg []byte)) error {
+	pub.handlers <- handler
+	<-stopCh
+	return nil
+}
+
+func TestEventStreamFromChannels(t *testing.T) {
+	pub := &testChannelPubHdl{testPubHdl: newTestPubHdl(), handlers: make(chan func(string, []byte), 1)}
+	p := newTestPublisher(pub, 0)
+	server := httptest.NewServer(p.EventStreamHandler())
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	var handler func(string, []byte)
+	select {
+	case handler = <-pub.handlers:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event channels were not subscribed")
+	}
+	//the events of the publisher come back from the channels
+	p.dispatchLocalEvent(&events.Event{OwnerName: "ASICD", EventName: "PortOperStateDown", TimeStamp: time.Now()})
+	handler("ARPD", []byte("not an event"))
+	remote := events.Event{OwnerName: "ARPD", EventName: "ArpEntryLearned", SrcObjName: "ArpEntry", TimeStamp: time.Now()}
+	msg, _ := json.Marshal(remote)
+	handler("ARPD", msg)
+	if streamed := readServerSentEvent(t, reader); streamed.OwnerName != "ARPD" || streamed.Id != remote.TimeStamp.UnixNano() {
+		t.Errorf("streamed %+v, expected the event published by ARPD", streamed)
+	}
+	p.subscribers.closeAll()
+	select {
+	case handler = <-pub.handlers:
+		t.Error("subscribed again to the event channels after the publisher was closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -31,13 +31,12 @@ import (
 	"io/ioutil"
 	"models/events"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"utils/commonDefs"
 	"utils/logging"
-	"utils/typeConv"
 )
 
 type Event struct {
@@ -59,14 +58,15 @@ type EventDetails struct {
 	EventName   string
 	Description string
 	SrcObjName  string
+	IsFault     bool
+	Fault       FaultDetail
 }
 
-var EventMap map[events.EventId]EventDetails
-
 type FaultDetail struct {
 	RaiseFault       bool
 	ClearingEventId  int
 	ClearingDaemonId int
+	Severity         string //Critical, Major, Minor or Warning, Major if not set
 }
 
 type EventStruct struct {
@@ -108,103 +108,254 @@ type RecvdEvent struct {
 	eventId        events.EventId
 	key            interface{}
 	additionalInfo string
+	timeStamp      time.Time
 }
 
-var GlobalEventEnable bool = true
-var OwnerName string
-var OwnerId events.OwnerId
-var Logger logging.LoggerIntf
-var PubHdl PubIntf
-var PublishCh chan RecvdEvent
-
 const (
 	EventDir string = "/etc/flexswitch/"
 )
 
-func initEventDetails(ownerName string) error {
-	var evtJson EventJson
-	eventsFile := EventDir + "events.json"
+var ErrEventPublisherClosed = errors.New("Event publisher is closed")
+var ErrEventsNotInitialized = errors.New("Events are not initialized")
+
+//publishes the events of a daemon, with its own event definitions, queue, faults and subscribers
+type EventPublisher struct {
+	ownerName           string
+	ownerId             events.OwnerId
+	logger              logging.LoggerIntf
+	pubHdl              PubIntf
+	publishCh           chan RecvdEvent
+	eventsFile          string
+	eventLock           sync.RWMutex //event definitions and enables
+	eventMap            map[events.EventId]EventDetails
+	globalEventEnable   bool
+	enableOverrides     map[events.EventId]bool //set at runtime, kept across reloads
+	ownerEnableOverride *bool
+	eventsFileTime      time.Time
+	eventsFileSize      int64
+	closeLock           sync.RWMutex //held for reading while queueing an event
+	started             bool
+	closed              bool
+	stopCh              chan bool
+	doneCh              chan bool
+	delivery            eventDeliveryState
+	retention           eventRetentionState
+	faults              faultManager
+	subscribers         eventSubscriberSet
+}
+
+//publisher used by the package functions, replaced by InitEvents
+var defaultPublisher = struct {
+	sync.RWMutex
+	publisher *EventPublisher
+}{publisher: newEventPublisher("", nil, nil, 0)}
+
+func newEventPublisher(ownerName string, pubHdl PubIntf, logger logging.LoggerIntf, evtChBufSize int32) *EventPublisher {
+	return &EventPublisher{
+		ownerName:         ownerName,
+		logger:            logger,
+		pubHdl:            pubHdl,
+		publishCh:         make(chan RecvdEvent, evtChBufSize),
+		eventsFile:        EventDir + "events.json",
+		eventMap:          make(map[events.EventId]EventDetails),
+		globalEventEnable: true,
+		enableOverrides:   make(map[events.EventId]bool),
+		stopCh:            make(chan bool),
+		doneCh:            make(chan bool),
+		delivery:          newEventDeliveryState(),
+		faults:            newFaultManager(),
+		subscribers:       eventSubscriberSet{subs: make(map[*EventSubscription]bool)},
+	}
+}
+
+//reads the event definitions of the owner from EventDir and starts the event handler
+func NewEventPublisher(ownerName string, pubHdl PubIntf, logger logging.LoggerIntf, evtChBufSize int32) (*EventPublisher, error) {
+	return NewEventPublisherFromFile(ownerName, EventDir+"events.json", pubHdl, logger, evtChBufSize)
+}
+
+//reads the event definitions of the owner from eventsFile, which is reloaded when it changes
+func NewEventPublisherFromFile(ownerName string, eventsFile string, pubHdl PubIntf, logger logging.LoggerIntf, evtChBufSize int32) (*EventPublisher, error) {
+	p := newEventPublisher(ownerName, pubHdl, logger, evtChBufSize)
+	p.eventsFile = eventsFile
+	p.logger.Info(fmt.Sprintln("Initializing Owner Name :", ownerName))
+	p.recordEventsFile()
+	evtJson, err := p.readEventJson(eventsFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateEventJson(evtJson); err != nil {
+		p.logger.Err(fmt.Sprintln("Invalid event definitions in", eventsFile, "err:", err))
+	}
+	p.loadEventDetails(evtJson)
+	p.start()
+	p.logger.Info(fmt.Sprintln("EventMap:", p.GetEventDetails()))
+	return p, nil
+}
+
+func (p *EventPublisher) readEventJson(eventsFile string) (evtJson EventJson, err error) {
 	bytes, err := ioutil.ReadFile(eventsFile)
 	if err != nil {
-		Logger.Err(fmt.Sprintln("Error in reading ", eventsFile, " file."))
+		p.logger.Err(fmt.Sprintln("Error in reading ", eventsFile, " file."))
 		err := errors.New(fmt.Sprintln("Error in reading ", eventsFile, " file."))
-		return err
+		return evtJson, err
 	}
 
 	err = json.Unmarshal(bytes, &evtJson)
 	if err != nil {
-		Logger.Err(fmt.Sprintln("Errors in unmarshalling json file : ", eventsFile))
+		p.logger.Err(fmt.Sprintln("Errors in unmarshalling json file : ", eventsFile))
 		err := errors.New(fmt.Sprintln("Errors in unmarshalling json file: ", eventsFile))
-		return err
+		return evtJson, err
 	}
+	return evtJson, nil
+}
 
-	Logger.Debug(fmt.Sprintln("Owner Name :", ownerName, "evtJson:", evtJson))
+func (p *EventPublisher) loadEventDetails(evtJson EventJson) {
+	p.logger.Debug(fmt.Sprintln("Owner Name :", p.ownerName, "evtJson:", evtJson))
+	p.faults.initFaultDetails(evtJson)
+	eventMap := make(map[events.EventId]EventDetails)
+	p.eventLock.Lock()
+	defer p.eventLock.Unlock()
 	for _, daemon := range evtJson.DaemonEvents {
-		Logger.Debug(fmt.Sprintln("OwnerName:", ownerName, "daemon.DaemonName:", daemon.DaemonName))
-		if daemon.DaemonName == ownerName {
-			OwnerName = ownerName
-			OwnerId = events.OwnerId(daemon.DaemonId)
-			GlobalEventEnable = daemon.DaemonEventEnable
+		p.logger.Debug(fmt.Sprintln("OwnerName:", p.ownerName, "daemon.DaemonName:", daemon.DaemonName))
+		if daemon.DaemonName == p.ownerName {
+			p.ownerId = events.OwnerId(daemon.DaemonId)
+			p.globalEventEnable = daemon.DaemonEventEnable
 			for _, evt := range daemon.EventList {
 				evtId := events.EventId(evt.EventId)
-				evtEnt, _ := EventMap[evtId]
+				evtEnt, _ := eventMap[evtId]
 				evtEnt.EventName = evt.EventName
 				evtEnt.Description = evt.Description
 				evtEnt.SrcObjName = evt.SrcObjName
-				evtEnt.Oid = OwnerId
-				evtEnt.OwnerName = OwnerName
+				evtEnt.Oid = p.ownerId
+				evtEnt.OwnerName = p.ownerName
 				evtEnt.Enable = evt.EventEnable
-				EventMap[evtId] = evtEnt
+				evtEnt.IsFault = evt.IsFault
+				evtEnt.Fault = evt.Fault
+				if enable, exist := p.enableOverrides[evtId]; exist {
+					evtEnt.Enable = enable
+				}
+				eventMap[evtId] = evtEnt
 			}
 			continue
 		}
 	}
+	if p.ownerEnableOverride != nil {
+		p.globalEventEnable = *p.ownerEnableOverride
+	}
+	p.eventMap = eventMap
+}
 
-	return nil
+func (p *EventPublisher) start() {
+	p.closeLock.Lock()
+	p.started = true
+	p.closeLock.Unlock()
+	go p.eventHandler()
 }
 
-func InitEvents(ownerName string, pubHdl PubIntf, logger logging.LoggerIntf, evtChBufSize int32) error {
-	EventMap = make(map[events.EventId]EventDetails)
-	Logger = logger
-	PubHdl = pubHdl
-	Logger.Info(fmt.Sprintln("Initializing Owner Name :", ownerName))
-	err := initEventDetails(ownerName)
-	if err != nil {
-		return err
+//stops taking events, publishes the queued ones and stops the event handler. The stream
+//subscriptions are closed
+func (p *EventPublisher) Close() error {
+	p.closeLock.Lock()
+	if p.closed {
+		p.closeLock.Unlock()
+		return nil
 	}
-	PublishCh = make(chan RecvdEvent, evtChBufSize)
-	go eventHandler()
-	Logger.Info(fmt.Sprintln("EventMap:", EventMap))
+	p.closed = true
+	started := p.started
+	p.closeLock.Unlock()
+	if started {
+		close(p.stopCh)
+		<-p.doneCh
+	}
+	p.subscribers.closeAll()
 	return nil
 }
 
-func eventHandler() {
+func (p *EventPublisher) OwnerName() string {
+	return p.ownerName
+}
+
+func (p *EventPublisher) OwnerId() events.OwnerId {
+	return p.ownerId
+}
+
+func (p *EventPublisher) eventHandler() {
+	replayTicker := time.NewTicker(EventSpoolReplayInterval)
+	defer replayTicker.Stop()
+	retentionTicker := time.NewTicker(EventRetentionInterval)
+	defer retentionTicker.Stop()
+	fileTicker := time.NewTicker(EventsFileCheckInterval)
+	defer fileTicker.Stop()
+	defer close(p.doneCh)
 	for {
-		recvdEvt := <-PublishCh
-		err := publishRecvdEvents(recvdEvt.eventId, recvdEvt.key, recvdEvt.additionalInfo)
-		if err != nil {
-			Logger.Err(fmt.Sprintln("Error Publishing Events:", err))
+		select {
+		case recvdEvt := <-p.publishCh:
+			p.handleRecvdEvent(recvdEvt)
+		case <-replayTicker.C:
+			p.delivery.Lock()
+			replay := p.delivery.storeDown || p.delivery.spoolPending
+			p.delivery.Unlock()
+			if replay {
+				err := p.replayEventSpool()
+				if err != nil {
+					p.logger.Debug(fmt.Sprintln("Event spool replay failed, err:", err))
+				}
+			}
+		case <-retentionTicker.C:
+			err := p.trimEvents()
+			if err != nil {
+				p.logger.Err(fmt.Sprintln("Trimming the stored events failed, err:", err))
+			}
+		case <-fileTicker.C:
+			p.checkEventsFile()
+		case <-p.stopCh:
+			for {
+				select {
+				case recvdEvt := <-p.publishCh:
+					p.handleRecvdEvent(recvdEvt)
+				default:
+					return
+				}
+			}
 		}
 	}
 }
 
-func PublishEvents(eventId events.EventId, key interface{}, additionalInfo string) error {
+func (p *EventPublisher) handleRecvdEvent(recvdEvt RecvdEvent) {
+	err := p.publishRecvdEvents(recvdEvt)
+	if err != nil {
+		p.logger.Err(fmt.Sprintln("Error Publishing Events:", err))
+	}
+}
+
+func (p *EventPublisher) PublishEvents(eventId events.EventId, key interface{}, additionalInfo string) error {
 	recvdEvt := RecvdEvent{
 		eventId:        eventId,
 		key:            key,
 		additionalInfo: additionalInfo,
+		timeStamp:      time.Now(),
 	}
-	PublishCh <- recvdEvt
-	return nil
+	p.closeLock.RLock()
+	defer p.closeLock.RUnlock()
+	if p.closed {
+		return ErrEventPublisherClosed
+	}
+	if !p.started {
+		return ErrEventsNotInitialized
+	}
+	return p.enqueueEvent(recvdEvt)
 }
 
-func publishRecvdEvents(eventId events.EventId, key interface{}, additionalInfo string) error {
-	var err error
-	if GlobalEventEnable == false {
+func (p *EventPublisher) publishRecvdEvents(recvdEvt RecvdEvent) error {
+	eventId := recvdEvt.eventId
+	p.eventLock.RLock()
+	globalEventEnable := p.globalEventEnable
+	evtEnt, exist := p.eventMap[eventId]
+	p.eventLock.RUnlock()
+	if globalEventEnable == false {
 		return nil
 	}
 	evt := new(events.Event)
-	evtEnt, exist := EventMap[eventId]
 	if !exist {
 		err := errors.New(fmt.Sprintln("Unable to find the event corresponding to given eventId: ", eventId))
 		return err
@@ -217,33 +368,72 @@ func publishRecvdEvents(eventId events.EventId, key interface{}, additionalInfo
 	evt.OwnerName = evtEnt.OwnerName
 	evt.EvtId = eventId
 	evt.EventName = evtEnt.EventName
-	evt.TimeStamp = time.Now()
-	if additionalInfo != "" {
-		evt.Description = evtEnt.Description + ": " + additionalInfo
+	evt.TimeStamp = recvdEvt.timeStamp
+	if recvdEvt.additionalInfo != "" {
+		evt.Description = evtEnt.Description + ": " + recvdEvt.additionalInfo
 	} else {
 		evt.Description = evtEnt.Description
 	}
 	evt.SrcObjName = evtEnt.SrcObjName
-	evt.SrcObjKey = key
+	evt.SrcObjKey = recvdEvt.key
 	msg, _ := json.Marshal(*evt)
 	var unmarshalMsg events.Event
-	err = json.Unmarshal(msg, &unmarshalMsg)
-	keyMap, _ := events.EventKeyMap[evt.OwnerName]
-	obj, _ := keyMap[evt.SrcObjName]
-	obj = unmarshalMsg.SrcObjKey
-	str := fmt.Sprintf("%v", obj)
-	keyString := strings.TrimPrefix(str, "map[")
-	strKey := strings.Split(keyString, "]")
-	Logger.Info(fmt.Sprintln("Events to be published: ", evt, strKey[0]))
-	keyStr := fmt.Sprintf("Events#%s#%s#%s#%s#%s#%d#", evt.OwnerName, evt.EventName, evt.SrcObjName, strKey[0], evt.TimeStamp.String(), evt.TimeStamp.UnixNano())
-	Logger.Debug(fmt.Sprintln("Key Str :", keyStr))
-
-	err = PubHdl.StoreValInDb(keyStr, evt.Description, "Desc")
+	json.Unmarshal(msg, &unmarshalMsg)
+	srcObjKey := srcObjKeyString(unmarshalMsg.SrcObjKey)
+	p.logger.Info(fmt.Sprintln("Events to be published: ", evt, srcObjKey))
+	keyStr := fmt.Sprintf("Events#%s#%s#%s#%s#%s#%d#", evt.OwnerName, evt.EventName, evt.SrcObjName, srcObjKey, evt.TimeStamp.String(), evt.TimeStamp.UnixNano())
+	p.logger.Debug(fmt.Sprintln("Key Str :", keyStr))
+	p.ProcessFaultEvent(&unmarshalMsg)
+	p.dispatchLocalEvent(&unmarshalMsg)
+
+	return p.deliverEvent(keyStr, evt.Description, evt.OwnerName, msg)
+}
+
+//the settings made on the default publisher before InitEvents are kept
+func (p *EventPublisher) copySettings(prev *EventPublisher) {
+	prev.delivery.Lock()
+	p.delivery.Lock()
+	p.delivery.overflowPolicy = prev.delivery.overflowPolicy
+	p.delivery.retryCount = prev.delivery.retryCount
+	p.delivery.retryBackoff = prev.delivery.retryBackoff
+	p.delivery.spoolFile = prev.delivery.spoolFile
+	p.delivery.spoolPending = prev.delivery.spoolPending
+	p.delivery.Unlock()
+	prev.delivery.Unlock()
+	prev.retention.Lock()
+	p.retention.Lock()
+	p.retention.maxAge = prev.retention.maxAge
+	p.retention.maxCount = prev.retention.maxCount
+	p.retention.Unlock()
+	prev.retention.Unlock()
+}
+
+func DefaultEventPublisher() *EventPublisher {
+	defaultPublisher.RLock()
+	defer defaultPublisher.RUnlock()
+	return defaultPublisher.publisher
+}
+
+//creates the default publisher used by the package functions. A previous default publisher is closed
+func InitEvents(ownerName string, pubHdl PubIntf, logger logging.LoggerIntf, evtChBufSize int32) error {
+	return InitEventsFromFile(ownerName, EventDir+"events.json", pubHdl, logger, evtChBufSize)
+}
+
+func InitEventsFromFile(ownerName string, eventsFile string, pubHdl PubIntf, logger logging.LoggerIntf, evtChBufSize int32) error {
+	p, err := NewEventPublisherFromFile(ownerName, eventsFile, pubHdl, logger, evtChBufSize)
 	if err != nil {
-		Logger.Err(fmt.Sprintln("Storing Events in database failed, err:", err))
+		return err
 	}
-	PubHdl.Publish("PUBLISH", evt.OwnerName, msg)
-	return nil
+	defaultPublisher.Lock()
+	prev := defaultPublisher.publisher
+	p.copySettings(prev)
+	defaultPublisher.publisher = p
+	defaultPublisher.Unlock()
+	return prev.Close()
+}
+
+func PublishEvents(eventId events.EventId, key interface{}, additionalInfo string) error {
+	return DefaultEventPublisher().PublishEvents(eventId, key, additionalInfo)
 }
 
 func GetEventQueryParams(r *http.Request) (evtObj events.EventObject, err error) {
@@ -269,35 +459,13 @@ func GetEventQueryParams(r *http.Request) (evtObj events.EventObject, err error)
 }
 
 func GetEvents(evtQueryObj events.EventObject, pubHdl PubIntf, logger logging.LoggerIntf) (evt []events.EventObject, err error) {
-	qPattern := constructQueryPattern(evtQueryObj)
-	//fmt.Println("Pattern Query:", qPattern)
-	keys, err := typeConv.ConvertToStrings(pubHdl.GetAllKeys(qPattern))
-	if err != nil {
-		logger.Err(fmt.Sprintln("Error querying for keys:", err))
-	}
-	keySlice := constructKeySlice(keys)
-	if keySlice == nil {
-		logger.Err("Key slice is nil")
-	}
-	sort.Sort(KeyObjSlice(keySlice))
-	for _, keyObj := range keySlice {
-		desc, err := typeConv.ConvertToString(pubHdl.GetValFromDB(keyObj.Key, "Desc"))
-		if err != nil {
-			logger.Err(fmt.Sprintln("Error getting the value from DB", err))
-			continue
-		}
-		str := strings.Split(keyObj.Key, "#")
-		obj := events.EventObject{
-			OwnerName:   str[1],
-			EventName:   str[2],
-			TimeStamp:   str[5],
-			Description: desc,
-			SrcObjName:  str[3],
-			SrcObjKey:   str[4],
-		}
-		evt = append(evt, obj)
+	query := EventQuery{
+		OwnerName:  evtQueryObj.OwnerName,
+		EventName:  evtQueryObj.EventName,
+		SrcObjName: evtQueryObj.SrcObjName,
+		SrcObjKey:  evtQueryObj.SrcObjKey,
 	}
-	return evt, err
+	return QueryEvents(query, pubHdl, logger)
 }
 
 func constructQueryPattern(evtQueryObj events.EventObject) string {
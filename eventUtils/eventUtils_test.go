@@ -0,0 +1,75 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package eventUtils
+
+import (
+	"infra/sysd/sysdCommonDefs"
+	"models/events"
+	"testing"
+	"utils/logging"
+)
+
+func TestEventPublisherClose(t *testing.T) {
+	pub := newTestPubHdl()
+	p := newEventPublisher("ASICD", pub, logging.NewMemoryLogger("test", sysdCommonDefs.INFO), 64)
+	p.loadEventDetails(EventJson{DaemonEvents: []DaemonEvent{
+		{DaemonId: 1, DaemonName: "ASICD", DaemonEventEnable: true, EventList: []EventStruct{
+			{EventId: 1, EventName: "PortOperStateDown", Description: "Port down", SrcObjName: "Port", EventEnable: true},
+		}},
+		{DaemonId: 2, DaemonName: "ARPD", DaemonEventEnable: true},
+	}})
+	if err := p.PublishEvents(1, "fpPort1", ""); err != ErrEventsNotInitialized {
+		t.Errorf("err %v before start, expected not initialized", err)
+	}
+	sub := p.SubscribeEvents(events.EventObject{OwnerName: "ASICD"}, 0)
+	p.start()
+	for i := 0; i < 20; i++ {
+		if err := p.PublishEvents(1, map[string]interface{}{"IntfRef": i}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Close()
+	if len(pub.db) != 20 || p.GetEventStats().Published != 20 {
+		t.Errorf("%d events stored at close, expected the 20 queued ones", len(pub.db))
+	}
+	select {
+	case <-p.doneCh:
+	default:
+		t.Error("event handler still running after Close")
+	}
+	//the subscription is closed after the events it got
+	streamed := 0
+	for _ = range sub.C {
+		streamed++
+	}
+	if streamed != 20 {
+		t.Errorf("%d streamed events, expected 20", streamed)
+	}
+	if err := p.PublishEvents(1, "fpPort1", ""); err != ErrEventPublisherClosed {
+		t.Errorf("err %v after Close, expected closed", err)
+	}
+	if p.OwnerId() != 1 {
+		t.Errorf("owner id %d, expected 1", p.OwnerId())
+	}
+}
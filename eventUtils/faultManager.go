@@ -0,0 +1,248 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// faultManager.go
+package eventUtils
+
+import (
+	"fmt"
+	"models/events"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FaultSeverityCritical string = "Critical"
+	FaultSeverityMajor    string = "Major"
+	FaultSeverityMinor    string = "Minor"
+	FaultSeverityWarning  string = "Warning"
+)
+
+//number of cleared faults kept in the history
+const (
+	FaultHistorySize int = 1024
+)
+
+//fault raised by an event on an object, active until the clearing event for the same object
+type FaultEntry struct {
+	OwnerId           events.OwnerId
+	OwnerName         string
+	EventId           events.EventId
+	EventName         string
+	Description       string
+	SrcObjName        string
+	SrcObjKey         string
+	Severity          string
+	FirstSeen         time.Time
+	LastSeen          time.Time
+	Occurrences       int
+	Cleared           bool
+	ClearedAt         time.Time
+	ClearingEventName string
+}
+
+type eventKey struct {
+	ownerId events.OwnerId
+	eventId events.EventId
+}
+
+type faultKey struct {
+	eventKey
+	srcObjKey string
+}
+
+type faultManager struct {
+	sync.Mutex
+	raising  map[eventKey]FaultDetail //fault definitions of the raising events
+	clearing map[eventKey][]eventKey  //raising events cleared by an event
+	active   map[faultKey]*FaultEntry
+	history  []FaultEntry
+}
+
+func newFaultManager() faultManager {
+	return faultManager{
+		raising:  make(map[eventKey]FaultDetail),
+		clearing: make(map[eventKey][]eventKey),
+		active:   make(map[faultKey]*FaultEntry),
+	}
+}
+
+//reads the fault definitions of all the daemons, the clearing event may belong to another daemon
+func (faultMgr *faultManager) initFaultDetails(evtJson EventJson) {
+	raising := make(map[eventKey]FaultDetail)
+	clearing := make(map[eventKey][]eventKey)
+	for _, daemon := range evtJson.DaemonEvents {
+		for _, evt := range daemon.EventList {
+			if !evt.IsFault || !evt.Fault.RaiseFault {
+				continue
+			}
+			raiseKey := eventKey{events.OwnerId(daemon.DaemonId), events.EventId(evt.EventId)}
+			raising[raiseKey] = evt.Fault
+			clearKey := eventKey{events.OwnerId(evt.Fault.ClearingDaemonId), events.EventId(evt.Fault.ClearingEventId)}
+			clearing[clearKey] = append(clearing[clearKey], raiseKey)
+		}
+	}
+	faultMgr.Lock()
+	faultMgr.raising = raising
+	faultMgr.clearing = clearing
+	faultMgr.Unlock()
+}
+
+//string form of the source object key, as used in the event DB keys
+func srcObjKeyString(key interface{}) string {
+	str := fmt.Sprintf("%v", key)
+	keyString := strings.TrimPrefix(str, "map[")
+	strKey := strings.Split(keyString, "]")
+	return strKey[0]
+}
+
+//raises or clears the faults for an event. Called for the events published by this daemon, a daemon
+//subscribed to the events of the others passes them here to track their faults
+func (p *EventPublisher) ProcessFaultEvent(evt *events.Event) {
+	p.faults.processEvent(evt)
+}
+
+func (faultMgr *faultManager) processEvent(evt *events.Event) {
+	srcObjKey := srcObjKeyString(evt.SrcObjKey)
+	key := eventKey{evt.OwnerId, evt.EvtId}
+	faultMgr.Lock()
+	defer faultMgr.Unlock()
+	if detail, exist := faultMgr.raising[key]; exist {
+		faultMgr.raiseFault(evt, faultKey{key, srcObjKey}, detail)
+	}
+	for _, raiseKey := range faultMgr.clearing[key] {
+		fKey := faultKey{raiseKey, srcObjKey}
+		if fault, exist := faultMgr.active[fKey]; exist {
+			fault.Cleared = true
+			fault.ClearedAt = evt.TimeStamp
+			fault.ClearingEventName = evt.EventName
+			faultMgr.clearFault(fKey)
+		}
+	}
+}
+
+func (faultMgr *faultManager) raiseFault(evt *events.Event, fKey faultKey, detail FaultDetail) {
+	fault, exist := faultMgr.active[fKey]
+	if !exist {
+		severity := detail.Severity
+		if severity == "" {
+			severity = FaultSeverityMajor
+		}
+		fault = &FaultEntry{
+			OwnerId:    evt.OwnerId,
+			OwnerName:  evt.OwnerName,
+			EventId:    evt.EvtId,
+			EventName:  evt.EventName,
+			SrcObjName: evt.SrcObjName,
+			SrcObjKey:  fKey.srcObjKey,
+			Severity:   severity,
+			FirstSeen:  evt.TimeStamp,
+		}
+		faultMgr.active[fKey] = fault
+	}
+	fault.Description = evt.Description
+	fault.LastSeen = evt.TimeStamp
+	fault.Occurrences++
+}
+
+//moves an active fault to the history, called with the lock held
+func (faultMgr *faultManager) clearFault(fKey faultKey) {
+	fault := faultMgr.active[fKey]
+	delete(faultMgr.active, fKey)
+	if len(faultMgr.history) >= FaultHistorySize {
+		faultMgr.history = append(faultMgr.history[:0], faultMgr.history[len(faultMgr.history)-FaultHistorySize+1:]...)
+	}
+	faultMgr.history = append(faultMgr.history, *fault)
+}
+
+//clears an active fault without its clearing event, e.g. on operator request
+func (p *EventPublisher) ClearFault(ownerId events.OwnerId, eventId events.EventId, srcObjKey string) bool {
+	faultMgr := &p.faults
+	fKey := faultKey{eventKey{ownerId, eventId}, srcObjKey}
+	faultMgr.Lock()
+	defer faultMgr.Unlock()
+	fault, exist := faultMgr.active[fKey]
+	if !exist {
+		return false
+	}
+	fault.Cleared = true
+	fault.ClearedAt = time.Now()
+	faultMgr.clearFault(fKey)
+	return true
+}
+
+//active faults, oldest first
+func (p *EventPublisher) GetActiveFaults() []FaultEntry {
+	faultMgr := &p.faults
+	faultMgr.Lock()
+	faults := make([]FaultEntry, 0, len(faultMgr.active))
+	for _, fault := range faultMgr.active {
+		faults = append(faults, *fault)
+	}
+	faultMgr.Unlock()
+	sort.Slice(faults, func(i, j int) bool {
+		return faults[i].FirstSeen.Before(faults[j].FirstSeen)
+	})
+	return faults
+}
+
+func (p *EventPublisher) GetActiveFault(ownerId events.OwnerId, eventId events.EventId, srcObjKey string) (FaultEntry, bool) {
+	faultMgr := &p.faults
+	faultMgr.Lock()
+	defer faultMgr.Unlock()
+	fault, exist := faultMgr.active[faultKey{eventKey{ownerId, eventId}, srcObjKey}]
+	if !exist {
+		return FaultEntry{}, false
+	}
+	return *fault, true
+}
+
+//cleared faults in the order they were cleared, the last FaultHistorySize ones
+func (p *EventPublisher) GetFaultHistory() []FaultEntry {
+	faultMgr := &p.faults
+	faultMgr.Lock()
+	defer faultMgr.Unlock()
+	return append([]FaultEntry(nil), faultMgr.history...)
+}
+
+func ProcessFaultEvent(evt *events.Event) {
+	DefaultEventPublisher().ProcessFaultEvent(evt)
+}
+
+func ClearFault(ownerId events.OwnerId, eventId events.EventId, srcObjKey string) bool {
+	return DefaultEventPublisher().ClearFault(ownerId, eventId, srcObjKey)
+}
+
+func GetActiveFaults() []FaultEntry {
+	return DefaultEventPublisher().GetActiveFaults()
+}
+
+func GetActiveFault(ownerId events.OwnerId, eventId events.EventId, srcObjKey string) (FaultEntry, bool) {
+	return DefaultEventPublisher().GetActiveFault(ownerId, eventId, srcObjKey)
+}
+
+func GetFaultHistory() []FaultEntry {
+	return DefaultEventPublisher().GetFaultHistory()
+}
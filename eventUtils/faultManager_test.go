@@ -0,0 +1,67 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package eventUtils
+
+import (
+	"models/events"
+	"testing"
+	"time"
+)
+
+func TestFaultLifecycle(t *testing.T) {
+	p := newTestPublisher(newTestPubHdl(), 0)
+	p.faults.initFaultDetails(EventJson{DaemonEvents: []DaemonEvent{
+		{DaemonId: 1, DaemonName: "ASICD", EventList: []EventStruct{
+			{EventId: 1, EventName: "PortOperStateDown", IsFault: true,
+				Fault: FaultDetail{RaiseFault: true, ClearingEventId: 2, ClearingDaemonId: 1, Severity: FaultSeverityCritical}},
+			{EventId: 2, EventName: "PortOperStateUp", IsFault: true},
+		}},
+	}})
+	now := time.Now()
+	down := events.Event{OwnerId: 1, OwnerName: "ASICD", EvtId: 1, EventName: "PortOperStateDown",
+		SrcObjName: "Port", SrcObjKey: map[string]interface{}{"IntfRef": "fpPort1"}, TimeStamp: now}
+	p.ProcessFaultEvent(&down)
+	down.TimeStamp = now.Add(time.Second)
+	p.ProcessFaultEvent(&down)
+	faults := p.GetActiveFaults()
+	if len(faults) != 1 || faults[0].Occurrences != 2 || faults[0].Severity != FaultSeverityCritical ||
+		!faults[0].FirstSeen.Equal(now) || !faults[0].LastSeen.Equal(down.TimeStamp) {
+		t.Fatalf("active faults %+v, expected one critical fault seen twice", faults)
+	}
+	up := events.Event{OwnerId: 1, OwnerName: "ASICD", EvtId: 2, EventName: "PortOperStateUp",
+		SrcObjName: "Port", SrcObjKey: map[string]interface{}{"IntfRef": "fpPort2"}, TimeStamp: now.Add(2 * time.Second)}
+	p.ProcessFaultEvent(&up)
+	if _, active := p.GetActiveFault(1, 1, faults[0].SrcObjKey); !active {
+		t.Fatal("fault cleared by the clearing event of another object")
+	}
+	up.SrcObjKey = map[string]interface{}{"IntfRef": "fpPort1"}
+	p.ProcessFaultEvent(&up)
+	if faults := p.GetActiveFaults(); len(faults) != 0 {
+		t.Errorf("active faults %+v after the clearing event", faults)
+	}
+	history := p.GetFaultHistory()
+	if len(history) != 1 || !history[0].Cleared || history[0].ClearingEventName != "PortOperStateUp" {
+		t.Errorf("fault history %+v", history)
+	}
+}
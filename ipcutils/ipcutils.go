@@ -25,9 +25,13 @@ package ipcutils
 
 import (
 	//"fmt"
+	"context"
 	"git.apache.org/thrift.git/lib/go/thrift"
 	"models/objects"
+	"strings"
 	"sync"
+	"time"
+	"utils/logging"
 )
 
 type IPCClientBase struct {
@@ -75,6 +79,110 @@ func CreateIPCHandles(address string) (thrift.TTransport, *thrift.TBinaryProtoco
 	return ttransport, protocolFactory, err
 }
 
+//
+// This method logs an IPC call with the logger and the trace ID of ctx. The returned
+// function is called with the result of the call. The trace ID travels to the server
+// only over a connection using a TraceProtocol.
+//
+func TraceIPCCall(ctx context.Context, server string, method string) func(err error) {
+	logger := logging.FromContext(ctx).With("server", server, "method", method)
+	start := time.Now()
+	logger.Debug("IPC call")
+	return func(err error) {
+		if err != nil {
+			logger.Err("IPC call failed", "error", err, "duration", time.Since(start))
+			return
+		}
+		logger.Debug("IPC call done", "duration", time.Since(start))
+	}
+}
+
+//separates the method name and the trace ID in the name of a thrift message
+const TRACE_ID_SEPARATOR = "#"
+
+//
+// Output protocol appending the trace ID to the name of the call messages, the way
+// TMultiplexedProtocol prepends the service name. The binary protocol has no headers,
+// so the server has to take the ID off the name with a TraceProcessor.
+//
+type TraceProtocol struct {
+	thrift.TProtocol
+	lock    sync.Mutex
+	traceId string
+}
+
+func NewTraceProtocol(protocol thrift.TProtocol) *TraceProtocol {
+	return &TraceProtocol{TProtocol: protocol}
+}
+
+//trace ID of the following calls, "" for none
+func (p *TraceProtocol) SetTraceId(traceId string) {
+	p.lock.Lock()
+	p.traceId = traceId
+	p.lock.Unlock()
+}
+
+func (p *TraceProtocol) WriteMessageBegin(name string, typeId thrift.TMessageType, seqId int32) error {
+	p.lock.Lock()
+	traceId := p.traceId
+	p.lock.Unlock()
+	if traceId != "" && (typeId == thrift.CALL || typeId == thrift.ONEWAY) {
+		name += TRACE_ID_SEPARATOR + traceId
+	}
+	return p.TProtocol.WriteMessageBegin(name, typeId, seqId)
+}
+
+//method name and trace ID of a message name written by a TraceProtocol
+func SplitTraceId(name string) (method string, traceId string) {
+	if idx := strings.LastIndex(name, TRACE_ID_SEPARATOR); idx >= 0 {
+		return name[:idx], name[idx+len(TRACE_ID_SEPARATOR):]
+	}
+	return name, ""
+}
+
+//
+// Server side of TraceProtocol: takes the trace ID off the message name, logs the call
+// with it and hands the message to the processor under its plain method name. Messages
+// without a trace ID are processed as they are.
+//
+type TraceProcessor struct {
+	processor thrift.TProcessor
+	server    string
+	logger    *logging.FieldLogger
+}
+
+func NewTraceProcessor(processor thrift.TProcessor, server string, logger *logging.FieldLogger) *TraceProcessor {
+	return &TraceProcessor{processor: processor, server: server, logger: logger}
+}
+
+func (p *TraceProcessor) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	name, typeId, seqId, err := in.ReadMessageBegin()
+	if err != nil {
+		return false, err
+	}
+	method, traceId := SplitTraceId(name)
+	ctx := logging.NewContext(context.Background(), p.logger)
+	if traceId != "" {
+		ctx = logging.WithTraceId(ctx, traceId)
+	}
+	done := TraceIPCCall(ctx, p.server, method)
+	ok, terr := p.processor.Process(&storedMessageProtocol{in, method, typeId, seqId}, out)
+	done(terr)
+	return ok, terr
+}
+
+//protocol returning the message begin already read by the TraceProcessor
+type storedMessageProtocol struct {
+	thrift.TProtocol
+	name   string
+	typeId thrift.TMessageType
+	seqId  int32
+}
+
+func (s *storedMessageProtocol) ReadMessageBegin() (string, thrift.TMessageType, int32, error) {
+	return s.name, s.typeId, s.seqId, nil
+}
+
 func (clnt *IPCClientBase) CloseIPCHandles() error {
 	clnt.PtrProtocolFactory = nil
 	if err := clnt.TTransport.Close(); err != nil {
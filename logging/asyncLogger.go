@@ -0,0 +1,193 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// asyncLogger.go
+package logging
+
+import (
+	"errors"
+	"infra/sysd/sysdCommonDefs"
+	"sync"
+)
+
+//what an async logger does with a message when its queue is full
+const (
+	ASYNC_DROP_NEWEST = iota //drop the new message
+	ASYNC_DROP_OLDEST        //drop the oldest queued message to make room
+	ASYNC_BLOCK              //wait for room in the queue
+)
+
+type AsyncLogStats struct {
+	Queued  uint64
+	Written uint64
+	Dropped uint64
+}
+
+type logEntry struct {
+	level   sysdCommonDefs.SRDebugLevel
+	message string
+}
+
+//bounded queue of the messages, drained by a writer goroutine into the sinks
+type asyncQueue struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	entries  []logEntry
+	size     int
+	policy   int
+	inFlight bool
+	closed   bool
+	done     chan struct{}
+	stats    AsyncLogStats
+}
+
+//makes the log calls queue the messages instead of writing them to the sinks. Crit, alert and emerg
+//messages are never dropped, they wait for room in the queue whatever the policy
+func (logger *Writer) EnableAsync(queueSize int, dropPolicy int) error {
+	if queueSize < 1 {
+		return errors.New("Invalid async log queue size")
+	}
+	if dropPolicy != ASYNC_DROP_NEWEST && dropPolicy != ASYNC_DROP_OLDEST && dropPolicy != ASYNC_BLOCK {
+		return errors.New("Invalid async log drop policy")
+	}
+	queue := &asyncQueue{size: queueSize, policy: dropPolicy, done: make(chan struct{})}
+	queue.cond = sync.NewCond(&queue.lock)
+	logger.asyncLock.Lock()
+	defer logger.asyncLock.Unlock()
+	if logger.asyncQueue != nil {
+		return errors.New("Async logging already enabled")
+	}
+	logger.asyncQueue = queue
+	go logger.asyncWriter(queue)
+	return nil
+}
+
+//drains the queue and goes back to writing synchronously
+func (logger *Writer) DisableAsync() {
+	logger.asyncLock.Lock()
+	queue := logger.asyncQueue
+	logger.asyncQueue = nil
+	logger.asyncLock.Unlock()
+	if queue == nil {
+		return
+	}
+	queue.lock.Lock()
+	queue.closed = true
+	queue.cond.Broadcast()
+	queue.lock.Unlock()
+	<-queue.done
+}
+
+//waits until all the messages queued so far are written
+func (logger *Writer) Flush() {
+	logger.asyncLock.RLock()
+	queue := logger.asyncQueue
+	logger.asyncLock.RUnlock()
+	if queue == nil {
+		return
+	}
+	queue.lock.Lock()
+	for len(queue.entries) > 0 || queue.inFlight {
+		queue.cond.Wait()
+	}
+	queue.lock.Unlock()
+}
+
+func (logger *Writer) GetAsyncLogStats() (stats AsyncLogStats) {
+	logger.asyncLock.RLock()
+	queue := logger.asyncQueue
+	logger.asyncLock.RUnlock()
+	if queue == nil {
+		return stats
+	}
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return queue.stats
+}
+
+//queues the message, returns false if async logging is not enabled
+func (logger *Writer) enqueue(level sysdCommonDefs.SRDebugLevel, message string) bool {
+	logger.asyncLock.RLock()
+	queue := logger.asyncQueue
+	logger.asyncLock.RUnlock()
+	if queue == nil {
+		return false
+	}
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	for len(queue.entries) >= queue.size && !queue.closed {
+		if queue.policy == ASYNC_BLOCK || isRateLimitExempt(level) {
+			if queue.policy == ASYNC_DROP_OLDEST && queue.dropOldest() {
+				break
+			}
+			queue.cond.Wait()
+			continue
+		}
+		if queue.policy == ASYNC_DROP_NEWEST || !queue.dropOldest() {
+			queue.stats.Dropped++
+			return true
+		}
+	}
+	if queue.closed {
+		return false
+	}
+	queue.entries = append(queue.entries, logEntry{level: level, message: message})
+	queue.stats.Queued++
+	queue.cond.Broadcast()
+	return true
+}
+
+//drops the oldest message which may be dropped
+func (queue *asyncQueue) dropOldest() bool {
+	for i, entry := range queue.entries {
+		if !isRateLimitExempt(entry.level) {
+			queue.entries = append(queue.entries[:i], queue.entries[i+1:]...)
+			queue.stats.Dropped++
+			return true
+		}
+	}
+	return false
+}
+
+func (logger *Writer) asyncWriter(queue *asyncQueue) {
+	defer close(queue.done)
+	queue.lock.Lock()
+	for {
+		for len(queue.entries) == 0 && !queue.closed {
+			queue.cond.Wait()
+		}
+		if len(queue.entries) == 0 {
+			queue.lock.Unlock()
+			return
+		}
+		entry := queue.entries[0]
+		queue.entries = queue.entries[1:]
+		queue.inFlight = true
+		queue.lock.Unlock()
+		logger.writeSinks(entry.level, entry.message)
+		queue.lock.Lock()
+		queue.inFlight = false
+		queue.stats.Written++
+		queue.cond.Broadcast()
+	}
+}
@@ -0,0 +1,65 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package logging
+
+import (
+	"infra/sysd/sysdCommonDefs"
+	"testing"
+)
+
+//sink blocking the writes until released
+type gatedSink struct {
+	MemorySink
+	gate chan struct{}
+}
+
+func (sink *gatedSink) WriteLog(level sysdCommonDefs.SRDebugLevel, message string) error {
+	<-sink.gate
+	return sink.MemorySink.WriteLog(level, message)
+}
+
+func TestAsyncDropPolicy(t *testing.T) {
+	sink := &gatedSink{gate: make(chan struct{})}
+	logger := NewLoggerWithSinks("ribd", sysdCommonDefs.INFO, sink)
+	if err := logger.EnableAsync(4, ASYNC_DROP_NEWEST); err != nil {
+		t.Fatal(err)
+	}
+	//the writer goroutine holds the first message, the queue holds the next 4
+	for i := 0; i < 20; i++ {
+		logger.Info("route", i)
+	}
+	close(sink.gate)
+	logger.Crit("queued after the gate opened")
+	logger.Flush()
+	stats := logger.GetAsyncLogStats()
+	records := sink.Records()
+	if stats.Dropped == 0 || stats.Written != uint64(len(records)) || stats.Queued+stats.Dropped != 21 {
+		t.Errorf("stats %+v with %d records", stats, len(records))
+	}
+	if records[0].Message != "route 0" || records[len(records)-1].Level != sysdCommonDefs.CRIT {
+		t.Errorf("unexpected records %+v", records)
+	}
+	logger.Close()
+	logger.Info("written synchronously after close")
+}
@@ -0,0 +1,87 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// caller.go
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+type callerInfo struct {
+	file      string //last directory and file name
+	line      int
+	function  string
+	goroutine int
+}
+
+//adds the file:line, function and goroutine of the log call to the records
+func (logger *Writer) SetCallerCapture(enable bool) {
+	logger.captureCaller = enable
+}
+
+//first frame outside the logging package, the tests of the package count as callers
+func getCallerInfo() (info callerInfo) {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "utils/logging.") || strings.HasSuffix(frame.File, "_test.go") {
+			info.file = filepath.Join(filepath.Base(filepath.Dir(frame.File)), filepath.Base(frame.File))
+			info.line = frame.Line
+			info.function = frame.Function[strings.LastIndex(frame.Function, "/")+1:]
+			break
+		}
+		if !more {
+			break
+		}
+	}
+	info.goroutine = getGoroutineId()
+	return info
+}
+
+//parsed from the "goroutine N [running]:" header of the stack trace
+func getGoroutineId() int {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if idx := bytes.IndexByte(buf, ' '); idx > 0 {
+		id, _ := strconv.Atoi(string(buf[:idx]))
+		return id
+	}
+	return 0
+}
+
+func (info callerInfo) String() string {
+	return fmt.Sprintf("%s:%d %s g%d", info.file, info.line, info.function, info.goroutine)
+}
+
+func (info callerInfo) keyvals() []interface{} {
+	return []interface{}{"caller", info.file + ":" + strconv.Itoa(info.line), "func", info.function, "goroutine", info.goroutine}
+}
@@ -0,0 +1,272 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// fieldLogger.go
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"infra/sysd/sysdCommonDefs"
+	"strconv"
+	"strings"
+)
+
+//output formats of the structured log records
+const (
+	LOG_FORMAT_LOGFMT = iota
+	LOG_FORMAT_JSON
+)
+
+func ConvertLevelValToStr(level sysdCommonDefs.SRDebugLevel) string {
+	switch level {
+	case sysdCommonDefs.OFF:
+		return "off"
+	case sysdCommonDefs.CRIT:
+		return "crit"
+	case sysdCommonDefs.ERR:
+		return "err"
+	case sysdCommonDefs.WARN:
+		return "warn"
+	case sysdCommonDefs.ALERT:
+		return "alert"
+	case sysdCommonDefs.EMERG:
+		return "emerg"
+	case sysdCommonDefs.NOTICE:
+		return "notice"
+	case sysdCommonDefs.INFO:
+		return "info"
+	case sysdCommonDefs.DEBUG:
+		return "debug"
+	case sysdCommonDefs.TRACE:
+		return "trace"
+	}
+	return strconv.Itoa(int(level))
+}
+
+//logger carrying key-value fields which are added to every record it logs.
+//Records are rendered as logfmt or JSON, depending on the Writer's LogFormat
+type FieldLogger struct {
+	writer  *Writer
+	intf    LoggerIntf //logger other than a Writer, see With
+	module  string
+	keyvals []interface{}
+}
+
+func (logger *Writer) SetLogFormat(format int) {
+	logger.LogFormat = format
+}
+
+//returns a logger adding the key-value pairs to all its records
+func (logger *Writer) With(keyvals ...interface{}) *FieldLogger {
+	return &FieldLogger{writer: logger, keyvals: copyKeyvals(nil, keyvals)}
+}
+
+func (fl *FieldLogger) With(keyvals ...interface{}) *FieldLogger {
+	return &FieldLogger{writer: fl.writer, intf: fl.intf, module: fl.module, keyvals: copyKeyvals(fl.keyvals, keyvals)}
+}
+
+//returns a field logger on top of any LoggerIntf. The records of a logger which is not a Writer
+//are rendered as logfmt and passed to its method of the level
+func With(logger LoggerIntf, keyvals ...interface{}) *FieldLogger {
+	if withLogger, ok := logger.(interface {
+		With(...interface{}) *FieldLogger
+	}); ok {
+		return withLogger.With(keyvals...)
+	}
+	return &FieldLogger{intf: logger, keyvals: copyKeyvals(nil, keyvals)}
+}
+
+func copyKeyvals(keyvals []interface{}, more []interface{}) []interface{} {
+	all := make([]interface{}, 0, len(keyvals)+len(more))
+	all = append(all, keyvals...)
+	return append(all, more...)
+}
+
+func (fl *FieldLogger) Crit(msg string, keyvals ...interface{}) error {
+	return fl.log(sysdCommonDefs.CRIT, msg, keyvals)
+}
+
+func (fl *FieldLogger) Err(msg string, keyvals ...interface{}) error {
+	return fl.log(sysdCommonDefs.ERR, msg, keyvals)
+}
+
+func (fl *FieldLogger) Warning(msg string, keyvals ...interface{}) error {
+	return fl.log(sysdCommonDefs.WARN, msg, keyvals)
+}
+
+func (fl *FieldLogger) Alert(msg string, keyvals ...interface{}) error {
+	return fl.log(sysdCommonDefs.ALERT, msg, keyvals)
+}
+
+func (fl *FieldLogger) Emerg(msg string, keyvals ...interface{}) error {
+	return fl.log(sysdCommonDefs.EMERG, msg, keyvals)
+}
+
+func (fl *FieldLogger) Notice(msg string, keyvals ...interface{}) error {
+	return fl.log(sysdCommonDefs.NOTICE, msg, keyvals)
+}
+
+func (fl *FieldLogger) Info(msg string, keyvals ...interface{}) error {
+	return fl.log(sysdCommonDefs.INFO, msg, keyvals)
+}
+
+func (fl *FieldLogger) Debug(msg string, keyvals ...interface{}) error {
+	return fl.log(sysdCommonDefs.DEBUG, msg, keyvals)
+}
+
+func (fl *FieldLogger) log(level sysdCommonDefs.SRDebugLevel, msg string, keyvals []interface{}) error {
+	logger := fl.writer
+	if logger == nil {
+		return fl.logIntf(level, msg, keyvals)
+	}
+	//check the level before rendering the record
+	if !logger.isLevelEnabled(fl.module, level) {
+		return nil
+	}
+	record := make([]interface{}, 0, 8+len(fl.keyvals)+len(keyvals))
+	record = append(record, "level", ConvertLevelValToStr(level), "component", logger.MyComponentName)
+	if fl.module != "" {
+		record = append(record, "module", fl.module)
+	}
+	record = append(record, "msg", msg)
+	fields := len(record)
+	record = append(record, fl.keyvals...)
+	record = append(record, keyvals...)
+	message := FormatRecord(logger.LogFormat, record)
+	if !logger.allowMessage(level, message) {
+		return nil
+	}
+	if logger.captureCaller {
+		//the caller is not part of the message compared by the rate limiting
+		withCaller := append(append(append([]interface{}{}, record[:fields]...), getCallerInfo().keyvals()...), record[fields:]...)
+		message = FormatRecord(logger.LogFormat, withCaller)
+	}
+	return logger.output(level, message)
+}
+
+func (fl *FieldLogger) logIntf(level sysdCommonDefs.SRDebugLevel, msg string, keyvals []interface{}) error {
+	record := make([]interface{}, 0, 2+len(fl.keyvals)+len(keyvals))
+	record = append(record, "msg", msg)
+	record = append(record, fl.keyvals...)
+	record = append(record, keyvals...)
+	message := FormatRecord(LOG_FORMAT_LOGFMT, record)
+	switch level {
+	case sysdCommonDefs.CRIT:
+		return fl.intf.Crit(message)
+	case sysdCommonDefs.ERR:
+		return fl.intf.Err(message)
+	case sysdCommonDefs.WARN:
+		return fl.intf.Warning(message)
+	case sysdCommonDefs.ALERT:
+		return fl.intf.Alert(message)
+	case sysdCommonDefs.EMERG:
+		return fl.intf.Emerg(message)
+	case sysdCommonDefs.NOTICE:
+		return fl.intf.Notice(message)
+	case sysdCommonDefs.INFO:
+		return fl.intf.Info(message)
+	}
+	return fl.intf.Debug(message)
+}
+
+//renders the key-value pairs in the format. A key without a value gets the value "(MISSING)"
+func FormatRecord(format int, keyvals []interface{}) string {
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals, "(MISSING)")
+	}
+	var buf bytes.Buffer
+	if format == LOG_FORMAT_JSON {
+		buf.WriteByte('{')
+	}
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		if format == LOG_FORMAT_JSON {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(jsonValue(key))
+			buf.WriteByte(':')
+			buf.Write(jsonValue(keyvals[i+1]))
+			continue
+		}
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(logfmtKey(key))
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(keyvals[i+1]))
+	}
+	if format == LOG_FORMAT_JSON {
+		buf.WriteByte('}')
+	}
+	return buf.String()
+}
+
+func logfmtKey(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+	if key == "" {
+		return "_"
+	}
+	return key
+}
+
+func logfmtValue(value interface{}) string {
+	var str string
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		str = v
+	case error:
+		str = v.Error()
+	case fmt.Stringer:
+		str = v.String()
+	default:
+		str = fmt.Sprint(v)
+	}
+	if str == "" || strings.IndexFunc(str, func(r rune) bool { return r <= ' ' || r == '=' || r == '"' }) >= 0 {
+		return strconv.Quote(str)
+	}
+	return str
+}
+
+func jsonValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return data
+}
@@ -0,0 +1,66 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestFormatRecord(t *testing.T) {
+	keyvals := []interface{}{"level", "info", "component", "ribd", "msg", "Added route", "prefix", "10.1.0.0/16",
+		"err", errors.New("no next hop"), "count", 3, "empty", "", "dangling"}
+	logfmt := FormatRecord(LOG_FORMAT_LOGFMT, keyvals)
+	expected := `level=info component=ribd msg="Added route" prefix=10.1.0.0/16 err="no next hop" count=3 empty="" dangling=(MISSING)`
+	if logfmt != expected {
+		t.Errorf("logfmt record\n%s\nexpected\n%s", logfmt, expected)
+	}
+	record := FormatRecord(LOG_FORMAT_JSON, keyvals)
+	expected = `{"level":"info","component":"ribd","msg":"Added route","prefix":"10.1.0.0/16","err":"no next hop","count":3,"empty":"","dangling":"(MISSING)"}`
+	if record != expected {
+		t.Errorf("JSON record\n%s\nexpected\n%s", record, expected)
+	}
+}
+
+func TestFormatRecordJSONKeys(t *testing.T) {
+	keys := []string{"nul\x00key", "bell\a", "del\x7f", "quote\"key", "caf\u00e9", "emoji\U0001F600", "bad\xffutf8"}
+	keyvals := make([]interface{}, 0, 2*len(keys))
+	for i, key := range keys {
+		keyvals = append(keyvals, key, i)
+	}
+	record := FormatRecord(LOG_FORMAT_JSON, keyvals)
+	decoded := make(map[string]int)
+	if err := json.Unmarshal([]byte(record), &decoded); err != nil {
+		t.Fatalf("invalid JSON record %s: %v", record, err)
+	}
+	for i, key := range keys[:len(keys)-1] {
+		if value, ok := decoded[key]; !ok || value != i {
+			t.Errorf("key %q decoded to %d, %v from %s", key, value, ok, record)
+		}
+	}
+	if len(decoded) != len(keys) {
+		t.Errorf("decoded %d keys from %s, expected %d", len(decoded), record, len(keys))
+	}
+}
@@ -0,0 +1,142 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// fileSink.go
+package logging
+
+import (
+	"infra/sysd/sysdCommonDefs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//suffix of the rotated files
+const FILE_SINK_ROTATION_TIME_FORMAT = "20060102-150405.000000"
+
+//text lines in a file, rotated on size and age. The rotated files are named
+// <path>.<time of the rotation> and only the newest maxBackups of them are kept
+type FileSink struct {
+	path       string
+	maxSize    int64         //0 for no size limit
+	maxAge     time.Duration //0 for no age limit, counted from the time the file was opened
+	maxBackups int           //0 to keep all the rotated files
+	file       *os.File
+	size       int64
+	openTime   time.Time
+	lock       sync.Mutex
+}
+
+func NewFileSink(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*FileSink, error) {
+	sink := &FileSink{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (sink *FileSink) open() error {
+	file, err := os.OpenFile(sink.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	sink.file = file
+	sink.size = info.Size()
+	sink.openTime = time.Now()
+	return nil
+}
+
+func (sink *FileSink) WriteLog(level sysdCommonDefs.SRDebugLevel, message string) error {
+	now := time.Now()
+	line := formatLogLine(now, level, message)
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.file == nil {
+		if err := sink.open(); err != nil {
+			return err
+		}
+	}
+	if (sink.maxSize > 0 && sink.size > 0 && sink.size+int64(len(line)) > sink.maxSize) ||
+		(sink.maxAge > 0 && now.Sub(sink.openTime) >= sink.maxAge) {
+		if err := sink.rotate(now); err != nil {
+			return err
+		}
+	}
+	n, err := sink.file.WriteString(line)
+	sink.size += int64(n)
+	return err
+}
+
+func (sink *FileSink) rotate(now time.Time) error {
+	sink.file.Close()
+	sink.file = nil
+	if err := os.Rename(sink.path, sink.path+"."+now.Format(FILE_SINK_ROTATION_TIME_FORMAT)); err != nil {
+		return err
+	}
+	sink.removeOldBackups()
+	return sink.open()
+}
+
+func (sink *FileSink) removeOldBackups() {
+	if sink.maxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(sink.path + ".*")
+	if err != nil {
+		return
+	}
+	//other files sharing the prefix, such as <path>.conf, are not backups
+	backups := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if _, err := time.Parse(FILE_SINK_ROTATION_TIME_FORMAT, strings.TrimPrefix(match, sink.path+".")); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	if len(backups) <= sink.maxBackups {
+		return
+	}
+	//the time suffix sorts in rotation order
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-sink.maxBackups] {
+		os.Remove(backup)
+	}
+}
+
+func (sink *FileSink) Close() error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.file == nil {
+		return nil
+	}
+	err := sink.file.Close()
+	sink.file = nil
+	return err
+}
@@ -0,0 +1,177 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// logConfig.go
+package logging
+
+import (
+	"fmt"
+	"infra/sysd/sysdCommonDefs"
+	"io"
+	"models/objects"
+	"sysd"
+	"time"
+	"utils/dbutils/redisConfig"
+
+	"github.com/garyburd/redigo/redis"
+	nanomsg "github.com/op/go-nanomsg"
+)
+
+//logging configuration of a component
+type LogConfig struct {
+	GlobalLogging bool
+	Level         sysdCommonDefs.SRDebugLevel
+}
+
+//source of the logging configuration read when the logger is created. The config holds the
+//defaults, a source only overrides what it has configured
+type LogConfigSource interface {
+	ReadLogConfig(component string, config *LogConfig) error
+}
+
+//source of the runtime logging notifications published by sysd. Recv returns io.EOF when
+//there are no more notifications
+type LogNotificationSource interface {
+	Recv() ([]byte, error)
+}
+
+//a fixed configuration, used as is
+func (config LogConfig) ReadLogConfig(component string, out *LogConfig) error {
+	*out = config
+	return nil
+}
+
+//reads the SystemLogging and ComponentLogging objects from the config DB
+type RedisLogConfigSource struct {
+	Config redisConfig.RedisConfig
+	Logger LoggerIntf //optional, reports the connection and query failures
+}
+
+func NewRedisLogConfigSource(logger LoggerIntf) *RedisLogConfigSource {
+	return &RedisLogConfigSource{Config: redisConfig.DefaultRedisConfig(), Logger: logger}
+}
+
+//waits until the DB is reachable
+func (source *RedisLogConfigSource) ReadLogConfig(component string, config *LogConfig) error {
+	var dbHdl redis.Conn
+	var err error
+	retryCount := 0
+	ticker := time.NewTicker(DB_CONNECT_TIME_INTERVAL * time.Second)
+	defer ticker.Stop()
+	for _ = range ticker.C {
+		retryCount += 1
+		dbHdl, err = source.Config.Dial()
+		if err != nil {
+			if retryCount%DB_CONNECT_RETRY_LOG_COUNT == 0 {
+				source.logErr(fmt.Sprintln("Failed to dial out to Redis server. Retrying connection. Num retries = ", retryCount))
+			}
+		} else {
+			break
+		}
+	}
+	defer dbHdl.Close()
+	if err = source.readSystemLogging(dbHdl, config); err != nil {
+		return err
+	}
+	return source.readComponentLogging(dbHdl, component, config)
+}
+
+func (source *RedisLogConfigSource) readSystemLogging(dbHdl redis.Conn, config *LogConfig) error {
+	var dbObj objects.SystemLogging
+	objList, err := dbObj.GetAllObjFromDb(dbHdl)
+	if err != nil {
+		source.logErr("DB query failed for SystemLogging config")
+		return err
+	}
+	if objList != nil {
+		obj := sysd.NewSystemLogging()
+		dbObject := objList[0].(objects.SystemLogging)
+		objects.ConvertsysdSystemLoggingObjToThrift(&dbObject, obj)
+		if obj.Logging == "on" {
+			config.GlobalLogging = true
+		}
+	}
+	return nil
+}
+
+func (source *RedisLogConfigSource) readComponentLogging(dbHdl redis.Conn, component string, config *LogConfig) error {
+	var dbObj objects.ComponentLogging
+	objList, err := dbObj.GetAllObjFromDb(dbHdl)
+	if err != nil {
+		source.logErr("DB query failed for ComponentLogging config")
+		return err
+	}
+	for idx := 0; idx < len(objList); idx++ {
+		obj := sysd.NewComponentLogging()
+		dbObject := objList[idx].(objects.ComponentLogging)
+		objects.ConvertsysdComponentLoggingObjToThrift(&dbObject, obj)
+		if obj.Module == component {
+			config.Level = ConvertLevelStrToVal(obj.Level)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (source *RedisLogConfigSource) logErr(message string) {
+	if source.Logger != nil {
+		source.Logger.Err(message)
+	}
+}
+
+//notifications read from the sysd publisher socket
+type subSocketNotificationSource struct {
+	socket *nanomsg.SubSocket
+}
+
+func (source *subSocketNotificationSource) Recv() ([]byte, error) {
+	return source.socket.Recv(0)
+}
+
+//notifications written to the channel, closing it ends the listener. Meant for unit tests
+type ChanLogNotificationSource chan []byte
+
+func (source ChanLogNotificationSource) Recv() ([]byte, error) {
+	rxBuf, ok := <-source
+	if !ok {
+		return nil, io.EOF
+	}
+	return rxBuf, nil
+}
+
+//processes the notifications of the source until it returns io.EOF
+func (logger *Writer) ListenForNotifications(source LogNotificationSource) error {
+	for {
+		rxBuf, err := source.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logger.Err(fmt.Sprintln("Recv on logging notification source failed with error:", err))
+			continue
+		}
+		if rxBuf != nil {
+			logger.ProcessLoggingNotification(rxBuf)
+		}
+	}
+}
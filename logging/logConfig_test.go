@@ -0,0 +1,99 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"infra/sysd/sysdCommonDefs"
+	"strings"
+	"testing"
+)
+
+type failingConfigSource struct{}
+
+func (source failingConfigSource) ReadLogConfig(component string, config *LogConfig) error {
+	config.Level = sysdCommonDefs.DEBUG
+	return errors.New("config DB unreachable")
+}
+
+func TestLoggerWithConfig(t *testing.T) {
+	sink := NewMemorySink(0)
+	logger, err := NewLoggerWithConfig("test", failingConfigSource{}, nil, sink)
+	if err == nil {
+		t.Error("expected the config source error")
+	}
+	if logger.MyLogLevel != sysdCommonDefs.DEBUG || !logger.GlobalLogging {
+		t.Errorf("level %d global %v, expected the partially read config", logger.MyLogLevel, logger.GlobalLogging)
+	}
+	logger.Debug("read")
+	if records := sink.Records(); len(records) != 1 || records[0].Message != "read" {
+		t.Errorf("records %+v, expected the debug record", records)
+	}
+}
+
+func TestLoggingNotificationSource(t *testing.T) {
+	logger := NewMemoryLogger("test", sysdCommonDefs.INFO)
+	payload, _ := json.Marshal(sysdCommonDefs.ComponentLogging{Name: "test", Level: sysdCommonDefs.DEBUG})
+	notification, _ := json.Marshal(sysdCommonDefs.Notification{Type: sysdCommonDefs.C_LOG, Payload: payload})
+	source := make(ChanLogNotificationSource, 1)
+	source <- notification
+	close(source)
+	if err := logger.ListenForNotifications(source); err != nil {
+		t.Fatal(err)
+	}
+	if logger.MyLogLevel != sysdCommonDefs.DEBUG {
+		t.Errorf("level %d after the notification, expected debug", logger.MyLogLevel)
+	}
+}
+
+//hides the Writer methods of the memory logger
+type plainLogger struct {
+	LoggerIntf
+}
+
+func TestMemoryLogger(t *testing.T) {
+	logger := NewMemoryLogger("test", sysdCommonDefs.INFO)
+	logger.Debug("filtered")
+	logger.Info("route", "added")
+	With(plainLogger{logger}, "route", "10.1.1.0/24").Err("install failed", "error", "no nexthop")
+	With(logger, "route", "10.1.1.0/24").Info("withdrawn")
+	if entries := logger.Entries(); len(entries) != 3 {
+		t.Fatalf("entries %+v, expected 3", entries)
+	}
+	if !logger.Contains(sysdCommonDefs.INFO, "route added") || logger.Contains(sysdCommonDefs.DEBUG, "filtered") {
+		t.Error("unexpected info entries", logger.EntriesAt(sysdCommonDefs.INFO))
+	}
+	errEntries := logger.EntriesAt(sysdCommonDefs.ERR)
+	if len(errEntries) != 1 || strings.TrimSpace(errEntries[0].Message) != "msg=\"install failed\" route=10.1.1.0/24 error=\"no nexthop\"" {
+		t.Errorf("err entries %+v", errEntries)
+	}
+	if !logger.Contains(sysdCommonDefs.INFO, "level=info component=test msg=withdrawn route=10.1.1.0/24") {
+		t.Error("missing the structured record of the Writer", logger.Entries())
+	}
+	logger.Reset()
+	if len(logger.Entries()) != 0 {
+		t.Error("entries left after Reset")
+	}
+}
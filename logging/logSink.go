@@ -0,0 +1,220 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// logSink.go
+package logging
+
+import (
+	"fmt"
+	"infra/sysd/sysdCommonDefs"
+	"io"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//destination of the log messages. A Writer hands every message that passes its level gating
+//to all its sinks whose own level threshold allows it
+type LogSink interface {
+	WriteLog(level sysdCommonDefs.SRDebugLevel, message string) error
+	Close() error
+}
+
+type logSinkEntry struct {
+	sink  LogSink
+	level sysdCommonDefs.SRDebugLevel
+}
+
+//adds a sink receiving the messages up to level
+func (logger *Writer) AddSink(sink LogSink, level sysdCommonDefs.SRDebugLevel) {
+	logger.sinkLock.Lock()
+	defer logger.sinkLock.Unlock()
+	for _, entry := range logger.sinks {
+		if entry.sink == sink {
+			entry.level = level
+			return
+		}
+	}
+	logger.sinks = append(logger.sinks, &logSinkEntry{sink: sink, level: level})
+}
+
+func (logger *Writer) SetSinkLevel(sink LogSink, level sysdCommonDefs.SRDebugLevel) {
+	logger.AddSink(sink, level)
+}
+
+//removes the sink without closing it
+func (logger *Writer) RemoveSink(sink LogSink) bool {
+	logger.sinkLock.Lock()
+	defer logger.sinkLock.Unlock()
+	for i, entry := range logger.sinks {
+		if entry.sink == sink {
+			logger.sinks = append(logger.sinks[:i:i], logger.sinks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (logger *Writer) writeSinks(level sysdCommonDefs.SRDebugLevel, message string) (err error) {
+	logger.sinkLock.RLock()
+	defer logger.sinkLock.RUnlock()
+	for _, entry := range logger.sinks {
+		if entry.level < level {
+			continue
+		}
+		if sinkErr := entry.sink.WriteLog(level, message); sinkErr != nil && err == nil {
+			err = sinkErr
+		}
+	}
+	return err
+}
+
+func (logger *Writer) closeSinks() (err error) {
+	logger.sinkLock.Lock()
+	defer logger.sinkLock.Unlock()
+	for _, entry := range logger.sinks {
+		if sinkErr := entry.sink.Close(); sinkErr != nil && err == nil {
+			err = sinkErr
+		}
+	}
+	logger.sinks = nil
+	return err
+}
+
+//text line used by the sinks which do not have a record format of their own
+func formatLogLine(now time.Time, level sysdCommonDefs.SRDebugLevel, message string) string {
+	return fmt.Sprintf("%s %s %s\n", now.Format(time.RFC3339Nano), strings.ToUpper(ConvertLevelValToStr(level)),
+		strings.TrimRight(message, "\n"))
+}
+
+//local syslog
+type SysLogSink struct {
+	SysLogger *syslog.Writer
+}
+
+func NewSysLogSink(tag string) (*SysLogSink, error) {
+	sysLogger, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SysLogSink{SysLogger: sysLogger}, nil
+}
+
+func (sink *SysLogSink) WriteLog(level sysdCommonDefs.SRDebugLevel, message string) error {
+	switch level {
+	case sysdCommonDefs.CRIT:
+		return sink.SysLogger.Crit(message)
+	case sysdCommonDefs.ERR:
+		return sink.SysLogger.Err(message)
+	case sysdCommonDefs.WARN:
+		return sink.SysLogger.Warning(message)
+	case sysdCommonDefs.ALERT:
+		return sink.SysLogger.Alert(message)
+	case sysdCommonDefs.EMERG:
+		return sink.SysLogger.Emerg(message)
+	case sysdCommonDefs.NOTICE:
+		return sink.SysLogger.Notice(message)
+	case sysdCommonDefs.INFO:
+		return sink.SysLogger.Info(message)
+	case sysdCommonDefs.DEBUG:
+		return sink.SysLogger.Debug(message)
+	}
+	_, err := sink.SysLogger.Write([]byte(message))
+	return err
+}
+
+func (sink *SysLogSink) Close() error {
+	return sink.SysLogger.Close()
+}
+
+//text lines on a stream such as stderr
+type StreamSink struct {
+	out  io.Writer
+	lock sync.Mutex
+}
+
+func NewStreamSink(out io.Writer) *StreamSink {
+	return &StreamSink{out: out}
+}
+
+func NewStderrSink() *StreamSink {
+	return NewStreamSink(os.Stderr)
+}
+
+func (sink *StreamSink) WriteLog(level sysdCommonDefs.SRDebugLevel, message string) error {
+	line := formatLogLine(time.Now(), level, message)
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	_, err := io.WriteString(sink.out, line)
+	return err
+}
+
+func (sink *StreamSink) Close() error {
+	return nil
+}
+
+type LogRecord struct {
+	Time    time.Time
+	Level   sysdCommonDefs.SRDebugLevel
+	Message string
+}
+
+//in-memory capture of the messages, meant for unit tests
+type MemorySink struct {
+	capacity int
+	records  []LogRecord
+	lock     sync.Mutex
+}
+
+//keeps the last capacity records, all of them if capacity is 0
+func NewMemorySink(capacity int) *MemorySink {
+	return &MemorySink{capacity: capacity}
+}
+
+func (sink *MemorySink) WriteLog(level sysdCommonDefs.SRDebugLevel, message string) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.capacity > 0 && len(sink.records) >= sink.capacity {
+		sink.records = append(sink.records[:0], sink.records[len(sink.records)-sink.capacity+1:]...)
+	}
+	sink.records = append(sink.records, LogRecord{Time: time.Now(), Level: level, Message: strings.TrimRight(message, "\n")})
+	return nil
+}
+
+func (sink *MemorySink) Records() []LogRecord {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	return append([]LogRecord(nil), sink.records...)
+}
+
+func (sink *MemorySink) Reset() {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	sink.records = nil
+}
+
+func (sink *MemorySink) Close() error {
+	return nil
+}
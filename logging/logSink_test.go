@@ -0,0 +1,178 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package logging
+
+import (
+	"bufio"
+	"infra/sysd/sysdCommonDefs"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSinkLevels(t *testing.T) {
+	all := NewMemorySink(0)
+	errOnly := NewMemorySink(0)
+	logger := NewLoggerWithSinks("test", sysdCommonDefs.INFO, all)
+	logger.AddSink(errOnly, sysdCommonDefs.ERR)
+	logger.Info("route", "added")
+	logger.Err("route add failed")
+	logger.Debug("filtered by the logger level")
+	if records := all.Records(); len(records) != 2 || records[0].Message != "route added" {
+		t.Errorf("all sink records %+v, expected 2", records)
+	}
+	if records := errOnly.Records(); len(records) != 1 || records[0].Level != sysdCommonDefs.ERR {
+		t.Errorf("err sink records %+v, expected the err record only", records)
+	}
+	logger.RemoveSink(all)
+	logger.Err("second failure")
+	if len(all.Records()) != 2 || len(errOnly.Records()) != 2 {
+		t.Errorf("unexpected records after RemoveSink: %d %d", len(all.Records()), len(errOnly.Records()))
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	//shares the prefix of the rotated files but is not one of them
+	if err := os.WriteFile(path+".conf", []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sink, err := NewFileSink(path, 200, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	for i := 0; i < 20; i++ {
+		if err := sink.WriteLog(sysdCommonDefs.INFO, strings.Repeat("x", 50)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, _ := filepath.Glob(path + ".2*")
+	if len(backups) != 2 {
+		t.Errorf("%d rotated files %v, expected 2", len(backups), backups)
+	}
+	if _, err := os.Stat(path + ".conf"); err != nil {
+		t.Errorf("unrelated file removed by the rotation: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > 200 {
+		t.Errorf("current file %v size exceeds the limit, err %v", info, err)
+	}
+}
+
+//tcp collector handing the received messages to a channel
+func startSysLogCollector(t *testing.T, addr string) (net.Listener, chan string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					//octet counted frames
+					length, err := reader.ReadString(' ')
+					if err != nil {
+						return
+					}
+					n, err := strconv.Atoi(strings.TrimSpace(length))
+					if err != nil {
+						return
+					}
+					frame := make([]byte, n)
+					if _, err = io.ReadFull(reader, frame); err != nil {
+						return
+					}
+					messages <- string(frame)
+				}
+			}(conn)
+		}
+	}()
+	return listener, messages
+}
+
+func expectSysLogMessage(t *testing.T, messages chan string, text string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-messages:
+			if strings.Contains(msg, text) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("message %q not received", text)
+		}
+	}
+}
+
+func TestRemoteSysLogSinkReconnect(t *testing.T) {
+	listener, messages := startSysLogCollector(t, "127.0.0.1:0")
+	addr := listener.Addr().String()
+	sink, err := NewRemoteSysLogSink("tcp", addr, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err = sink.WriteLog(sysdCommonDefs.INFO, "first\n"); err != nil {
+		t.Fatal(err)
+	}
+	expectSysLogMessage(t, messages, "test")
+	listener.Close()
+	//drop the accepted connection too, the writes fail once the peer resets it
+	sink.lock.Lock()
+	sink.conn.Close()
+	sink.lock.Unlock()
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		sink.WriteLog(sysdCommonDefs.ERR, "while down\n")
+	}
+	if elapsed := time.Since(start); elapsed > REMOTE_SYSLOG_RECONNECT_MIN_BACKOFF {
+		t.Errorf("writes blocked for %v while the collector was down", elapsed)
+	}
+	if stats := sink.GetStats(); stats.Pending != 10 {
+		t.Errorf("stats %+v, expected 10 pending messages", stats)
+	}
+	listener, messages = startSysLogCollector(t, addr)
+	defer listener.Close()
+	expectSysLogMessage(t, messages, "while down")
+	deadline := time.Now().Add(5 * time.Second)
+	for sink.GetStats().Pending != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := sink.GetStats(); stats.Pending != 0 || stats.Sent != 11 {
+		t.Errorf("stats %+v after the reconnect, expected 11 sent", stats)
+	}
+}
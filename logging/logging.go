@@ -29,12 +29,10 @@ import (
 	"infra/sysd/sysdCommonDefs"
 	"log"
 	"log/syslog"
-	"models/objects"
 	"os"
-	"sysd"
-	"time"
+	"sync"
+	"utils/dbutils/redisConfig"
 
-	"github.com/garyburd/redigo/redis"
 	nanomsg "github.com/op/go-nanomsg"
 )
 
@@ -100,9 +98,24 @@ type Writer struct {
 	initialized     bool
 	subSocket       *nanomsg.SubSocket
 	socketCh        chan []byte
+	LogFormat       int //format of the structured records, LOG_FORMAT_LOGFMT or LOG_FORMAT_JSON
+	sinks           []*logSinkEntry
+	sinkLock        sync.RWMutex
+	moduleRules     []*moduleLevelRule //module levels, see SetModuleLevel
+	moduleLock      sync.RWMutex
+	rateLimiter     *rateLimiter //per level budgets, see SetRateLimit
+	rateLimiterOnce sync.Once
+	asyncQueue      *asyncQueue //queue of the async mode, see EnableAsync
+	asyncLock       sync.RWMutex
+	captureCaller   bool //see SetCallerCapture
 }
 
 func NewLogger(name string, tag string, listenToConfig bool) (*Writer, error) {
+	return NewLoggerWithRedisConfig(name, tag, listenToConfig, redisConfig.DefaultRedisConfig())
+}
+
+//same as NewLogger with the logging level read from the DB of the config
+func NewLoggerWithRedisConfig(name string, tag string, listenToConfig bool, dbConfig redisConfig.RedisConfig) (*Writer, error) {
 	var err error
 	srLogger := new(Writer)
 	srLogger.MyComponentName = name
@@ -113,16 +126,19 @@ func NewLogger(name string, tag string, listenToConfig bool) (*Writer, error) {
 		fmt.Println("Failed to initialize syslog - ", err)
 		return srLogger, err
 	}
+	srLogger.AddSink(&SysLogSink{SysLogger: srLogger.SysLogger}, sysdCommonDefs.TRACE)
 	// if SysLogger can't be initialized then send all logs to /dev/null
 	devNull, err := os.Open(os.DevNull)
 	if err == nil {
 		srLogger.nullLogger = log.New(devNull, tag, log.Ldate|log.Ltime|log.Lshortfile)
 	}
 
-	srLogger.GlobalLogging = true
-	srLogger.MyLogLevel = sysdCommonDefs.INFO
+	config := LogConfig{GlobalLogging: true, Level: sysdCommonDefs.INFO}
 	// Read logging level from DB
-	srLogger.readLogLevelFromDb()
+	source := &RedisLogConfigSource{Config: dbConfig, Logger: srLogger}
+	source.ReadLogConfig(name, &config)
+	srLogger.GlobalLogging = config.GlobalLogging
+	srLogger.MyLogLevel = config.Level
 	srLogger.initialized = true
 	fmt.Println("Logging level ", srLogger.MyLogLevel, " set for ", srLogger.MyComponentName)
 	if listenToConfig {
@@ -131,70 +147,34 @@ func NewLogger(name string, tag string, listenToConfig bool) (*Writer, error) {
 	return srLogger, err
 }
 
-func (logger *Writer) readSystemLoggingFromDb(dbHdl redis.Conn) error {
-	logger.Info("Reading SystemLogging")
-	var dbObj objects.SystemLogging
-	objList, err := dbObj.GetAllObjFromDb(dbHdl)
-	if err != nil {
-		logger.Err("DB query failed for SystemLogging config")
-		return err
+//logger with the configuration read from the given source and the messages written to the sinks,
+//nothing is dialed. The notifications are processed in the background when a source is given.
+//The logger is usable when reading the config fails, with the global logging on and the level info
+func NewLoggerWithConfig(name string, config LogConfigSource, notifications LogNotificationSource, sinks ...LogSink) (*Writer, error) {
+	var err error
+	srLogger := new(Writer)
+	srLogger.MyComponentName = name
+	logConfig := LogConfig{GlobalLogging: true, Level: sysdCommonDefs.INFO}
+	if config != nil {
+		err = config.ReadLogConfig(name, &logConfig)
 	}
-	if objList != nil {
-		obj := sysd.NewSystemLogging()
-		dbObject := objList[0].(objects.SystemLogging)
-		objects.ConvertsysdSystemLoggingObjToThrift(&dbObject, obj)
-		if obj.Logging == "on" {
-			logger.GlobalLogging = true
-		}
+	srLogger.GlobalLogging = logConfig.GlobalLogging
+	srLogger.MyLogLevel = logConfig.Level
+	for _, sink := range sinks {
+		srLogger.AddSink(sink, sysdCommonDefs.TRACE)
 	}
-	return nil
-}
-
-func (logger *Writer) readComponentLoggingFromDb(dbHdl redis.Conn) error {
-	logger.Info("Reading ComponentLogging")
-	var dbObj objects.ComponentLogging
-	objList, err := dbObj.GetAllObjFromDb(dbHdl)
-	if err != nil {
-		logger.Err("DB query failed for ComponentLogging config")
-		return err
-	}
-	if objList != nil {
-		for idx := 0; idx < len(objList); idx++ {
-			obj := sysd.NewComponentLogging()
-			dbObject := objList[idx].(objects.ComponentLogging)
-			objects.ConvertsysdComponentLoggingObjToThrift(&dbObject, obj)
-			if obj.Module == logger.MyComponentName {
-				logger.MyLogLevel = ConvertLevelStrToVal(obj.Level)
-				return nil
-			}
-		}
+	srLogger.initialized = true
+	if notifications != nil {
+		go srLogger.ListenForNotifications(notifications)
 	}
-	return nil
+	return srLogger, err
 }
 
-func (logger *Writer) readLogLevelFromDb() error {
-	var dbHdl redis.Conn
-	var err error
-	retryCount := 0
-	ticker := time.NewTicker(DB_CONNECT_TIME_INTERVAL * time.Second)
-	for _ = range ticker.C {
-		retryCount += 1
-		dbHdl, err = redis.Dial("tcp", ":6379")
-		if err != nil {
-			if retryCount%DB_CONNECT_RETRY_LOG_COUNT == 0 {
-				logger.Err(fmt.Sprintln("Failed to dial out to Redis server. Ret    rying connection. Num retries = ", retryCount))
-			}
-		} else {
-			break
-		}
-	}
-
-	if dbHdl != nil {
-		logger.readSystemLoggingFromDb(dbHdl)
-		logger.readComponentLoggingFromDb(dbHdl)
-		dbHdl.Close()
-	}
-	return nil
+//logger writing to the given sinks instead of the local syslog. The level is not read from the DB,
+//for containers and unit tests where neither syslog nor redis is available
+func NewLoggerWithSinks(name string, level sysdCommonDefs.SRDebugLevel, sinks ...LogSink) *Writer {
+	srLogger, _ := NewLoggerWithConfig(name, LogConfig{GlobalLogging: true, Level: level}, nil, sinks...)
+	return srLogger
 }
 
 func (logger *Writer) SetGlobal(Enable bool) error {
@@ -321,220 +301,128 @@ func (logger *Writer) Write(message string) (int, error) {
 	return 0, nil
 }
 */
-func (logger *Writer) Crit(message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.CRIT {
-			return logger.SysLogger.Crit(fmt.Sprintln(message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintln(message))
+func (logger *Writer) logMessage(level sysdCommonDefs.SRDebugLevel, message string) error {
+	return logger.logModuleMessage("", level, message)
+}
+
+//single place for the level gating and the output of all the log calls.
+//module is empty for the component logger
+func (logger *Writer) logModuleMessage(module string, level sysdCommonDefs.SRDebugLevel, message string) error {
+	if !logger.isLevelEnabled(module, level) {
+		return nil
 	}
-	return nil
+	if module != "" {
+		message = module + ": " + message
+	}
+	if !logger.allowMessage(level, message) {
+		return nil
+	}
+	if logger.captureCaller {
+		message = "[" + getCallerInfo().String() + "] " + message
+	}
+	return logger.output(level, message)
 }
 
-func (logger *Writer) Err(message ...interface{}) error {
+//writes a message which passed the level gating
+func (logger *Writer) output(level sysdCommonDefs.SRDebugLevel, message string) error {
 	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.ERR {
-			return logger.SysLogger.Err(fmt.Sprintln(message))
+		if logger.enqueue(level, message) {
+			return nil
 		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintln(message))
+		return logger.writeSinks(level, message)
 	}
+	logger.nullLogger.Println(message)
 	return nil
 }
 
-func (logger *Writer) Warning(message ...interface{}) error {
+func (logger *Writer) isLevelEnabled(module string, level sysdCommonDefs.SRDebugLevel) bool {
 	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.WARN {
-			return logger.SysLogger.Warning(fmt.Sprintln(message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintln(message))
+		return logger.GlobalLogging && logger.GetModuleLevel(module) >= level
 	}
-	return nil
+	return logger.nullLogger != nil
+}
+
+func (logger *Writer) Crit(message ...interface{}) error {
+	return logger.logMessage(sysdCommonDefs.CRIT, fmt.Sprintln(message...))
+}
+
+func (logger *Writer) Err(message ...interface{}) error {
+	return logger.logMessage(sysdCommonDefs.ERR, fmt.Sprintln(message...))
+}
+
+func (logger *Writer) Warning(message ...interface{}) error {
+	return logger.logMessage(sysdCommonDefs.WARN, fmt.Sprintln(message...))
 }
 
 func (logger *Writer) Alert(message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.ALERT {
-			return logger.SysLogger.Alert(fmt.Sprintln(message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintln(message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.ALERT, fmt.Sprintln(message...))
 }
 
 func (logger *Writer) Emerg(message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.EMERG {
-			return logger.SysLogger.Emerg(fmt.Sprintln(message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintln(message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.EMERG, fmt.Sprintln(message...))
 }
 
 func (logger *Writer) Notice(message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.NOTICE {
-			return logger.SysLogger.Notice(fmt.Sprintln(message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintln(message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.NOTICE, fmt.Sprintln(message...))
 }
 
 func (logger *Writer) Info(message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.INFO {
-			return logger.SysLogger.Info(fmt.Sprintln(message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintln(message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.INFO, fmt.Sprintln(message...))
 }
 
 func (logger *Writer) Println(message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.INFO {
-			return logger.SysLogger.Info(fmt.Sprintln(message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintln(message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.INFO, fmt.Sprintln(message...))
 }
 
 func (logger *Writer) Debug(message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.DEBUG {
-			return logger.SysLogger.Debug(fmt.Sprintln(message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintln(message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.DEBUG, fmt.Sprintln(message...))
 }
 
 func (logger *Writer) Write(message string) (int, error) {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.TRACE {
-			n, err := logger.SysLogger.Write([]byte(message))
-			return n, err
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(message)
-	}
-	return 0, nil
+	return len(message), logger.logMessage(sysdCommonDefs.TRACE, message)
 }
 
 func (logger *Writer) Critf(format string, message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.CRIT {
-			return logger.SysLogger.Crit(fmt.Sprintf(format, message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintf(format, message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.CRIT, fmt.Sprintf(format, message...))
 }
 
 func (logger *Writer) Errf(format string, message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.ERR {
-			return logger.SysLogger.Err(fmt.Sprintf(format, message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintf(format, message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.ERR, fmt.Sprintf(format, message...))
 }
 
 func (logger *Writer) Warningf(format string, message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.WARN {
-			return logger.SysLogger.Warning(fmt.Sprintf(format, message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintf(format, message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.WARN, fmt.Sprintf(format, message...))
 }
 
 func (logger *Writer) Alertf(format string, message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.ALERT {
-			return logger.SysLogger.Alert(fmt.Sprintf(format, message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintf(format, message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.ALERT, fmt.Sprintf(format, message...))
 }
 
 func (logger *Writer) Emergf(format string, message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.EMERG {
-			return logger.SysLogger.Emerg(fmt.Sprintf(format, message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintf(format, message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.EMERG, fmt.Sprintf(format, message...))
 }
 
 func (logger *Writer) Noticef(format string, message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.NOTICE {
-			return logger.SysLogger.Notice(fmt.Sprintf(format, message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintf(format, message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.NOTICE, fmt.Sprintf(format, message...))
 }
 
 func (logger *Writer) Infof(format string, message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.INFO {
-			return logger.SysLogger.Info(fmt.Sprintf(format, message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintf(format, message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.INFO, fmt.Sprintf(format, message...))
 }
 
 func (logger *Writer) Printf(format string, message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.INFO {
-			return logger.SysLogger.Info(fmt.Sprintf(format, message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintf(format, message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.INFO, fmt.Sprintf(format, message...))
 }
 
 func (logger *Writer) Debugf(format string, message ...interface{}) error {
-	if logger.initialized {
-		if logger.GlobalLogging && logger.MyLogLevel >= sysdCommonDefs.DEBUG {
-			return logger.SysLogger.Debug(fmt.Sprintf(format, message))
-		}
-	} else if logger.nullLogger != nil {
-		logger.nullLogger.Println(fmt.Sprintf(format, message))
-	}
-	return nil
+	return logger.logMessage(sysdCommonDefs.DEBUG, fmt.Sprintf(format, message...))
 }
 
 func (logger *Writer) Close() error {
 	var err error
 	if logger.initialized {
-		err = logger.SysLogger.Close()
+		logger.DisableAsync()
+		err = logger.closeSinks()
 	}
 	logger = nil
 	return err
@@ -560,7 +448,7 @@ func (logger *Writer) SetupSubSocket() error {
 
 	logger.Info(fmt.Sprintf("Connected to publisher socket %s", sysdCommonDefs.PUB_SOCKET_ADDR))
 	if err = socket.SetRecvBuffer(1024 * 1024); err != nil {
-		logger.Err(fmt.Sprintln("Failed to set the buffer size for subsriber socket %s, error:", sysdCommonDefs.PUB_SOCKET_ADDR, err))
+		logger.Err(fmt.Sprintf("Failed to set the buffer size for subsriber socket %s, error:%s", sysdCommonDefs.PUB_SOCKET_ADDR, err))
 		return err
 	}
 	logger.subSocket = socket
@@ -595,6 +483,15 @@ func (logger *Writer) ProcessLoggingNotification(rxBuf []byte) error {
 			logger.SetLevel(cLog.Level)
 		}
 	}
+	if msg.Type == MODULE_LOG {
+		var mLog ModuleLogging
+		err = json.Unmarshal(msg.Payload, &mLog)
+		if err != nil {
+			logger.Err(fmt.Sprintln("Unable to unmarshal module logging notification: ", msg.Payload))
+			return err
+		}
+		return logger.processModuleLogging(mLog)
+	}
 	return nil
 }
 
@@ -616,15 +513,5 @@ func (logger *Writer) ListenForLoggingNotifications() error {
 		logger.Err(fmt.Sprintln("Failed to subscribe to logging notifications"))
 		return err
 	}
-	go logger.ProcessLogNotifications()
-	for {
-		rxBuf, err := logger.subSocket.Recv(0)
-		if err != nil {
-			logger.Err(fmt.Sprintln("Recv on logging subscriber socket failed with error:", err))
-			continue
-		}
-		logger.socketCh <- rxBuf
-	}
-	logger.Info(fmt.Sprintln("Existing logging config lister"))
-	return nil
+	return logger.ListenForNotifications(&subSocketNotificationSource{socket: logger.subSocket})
 }
@@ -0,0 +1,74 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// memoryLogger.go
+package logging
+
+import (
+	"infra/sysd/sysdCommonDefs"
+	"strings"
+)
+
+//logger recording its messages in memory, for the unit tests of the packages taking a LoggerIntf.
+//It is a complete Writer, module loggers, field loggers and the level settings apply
+type MemoryLogger struct {
+	*Writer
+	Sink *MemorySink
+}
+
+//records every message at or above the level
+func NewMemoryLogger(name string, level sysdCommonDefs.SRDebugLevel) *MemoryLogger {
+	sink := NewMemorySink(0)
+	return &MemoryLogger{Writer: NewLoggerWithSinks(name, level, sink), Sink: sink}
+}
+
+func (logger *MemoryLogger) Entries() []LogRecord {
+	logger.Flush()
+	return logger.Sink.Records()
+}
+
+//records logged at the level
+func (logger *MemoryLogger) EntriesAt(level sysdCommonDefs.SRDebugLevel) []LogRecord {
+	var entries []LogRecord
+	for _, entry := range logger.Entries() {
+		if entry.Level == level {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+//true if a record logged at the level contains the text
+func (logger *MemoryLogger) Contains(level sysdCommonDefs.SRDebugLevel, text string) bool {
+	for _, entry := range logger.EntriesAt(level) {
+		if strings.Contains(entry.Message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func (logger *MemoryLogger) Reset() {
+	logger.Flush()
+	logger.Sink.Reset()
+}
@@ -0,0 +1,283 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// moduleLogger.go
+package logging
+
+import (
+	"fmt"
+	"infra/sysd/sysdCommonDefs"
+	"path"
+	"strings"
+	"time"
+)
+
+//notification type for the module levels, carried on the sysd logging publisher socket
+//next to G_LOG and C_LOG and chosen outside their range
+const MODULE_LOG uint8 = 100
+
+//payload of a MODULE_LOG notification. Module is a module name or a glob pattern such as "bgp.peer.*".
+//A non zero Duration (in seconds) makes the level revert automatically, Clear removes the levels set for Module
+type ModuleLogging struct {
+	Component string
+	Module    string
+	Level     sysdCommonDefs.SRDebugLevel
+	Duration  int
+	Clear     bool
+}
+
+type moduleLevelRule struct {
+	pattern string
+	level   sysdCommonDefs.SRDebugLevel
+	expiry  time.Time //zero for a permanent level
+	timer   *time.Timer
+}
+
+//more specific patterns win: exact names first, then the pattern with more literal characters
+func (rule *moduleLevelRule) specificity() int {
+	idx := strings.IndexAny(rule.pattern, "*?[\\")
+	if idx < 0 {
+		return len(rule.pattern) + 1<<16
+	}
+	return idx
+}
+
+//named sub-logger, such as "bgp.fsm" or "bgp.peer.10.0.0.1", with a level of its own.
+//Without a module level the component level applies
+type ModuleLogger struct {
+	writer *Writer
+	name   string
+}
+
+func (logger *Writer) Module(name string) *ModuleLogger {
+	return &ModuleLogger{writer: logger, name: name}
+}
+
+func (ml *ModuleLogger) Module(name string) *ModuleLogger {
+	return &ModuleLogger{writer: ml.writer, name: ml.name + "." + name}
+}
+
+func (ml *ModuleLogger) Name() string {
+	return ml.name
+}
+
+func (ml *ModuleLogger) With(keyvals ...interface{}) *FieldLogger {
+	return &FieldLogger{writer: ml.writer, module: ml.name, keyvals: copyKeyvals(nil, keyvals)}
+}
+
+//sets the level of the modules matching pattern until it is cleared
+func (logger *Writer) SetModuleLevel(pattern string, level sysdCommonDefs.SRDebugLevel) error {
+	return logger.setModuleLevel(pattern, level, 0)
+}
+
+//sets the level of the modules matching pattern for duration, the previous level applies again afterwards
+func (logger *Writer) SetModuleLevelFor(pattern string, level sysdCommonDefs.SRDebugLevel, duration time.Duration) error {
+	if duration <= 0 {
+		return fmt.Errorf("Invalid duration %v for module level override", duration)
+	}
+	return logger.setModuleLevel(pattern, level, duration)
+}
+
+func (logger *Writer) setModuleLevel(pattern string, level sysdCommonDefs.SRDebugLevel, duration time.Duration) error {
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		return fmt.Errorf("Invalid module pattern %q", pattern)
+	}
+	rule := &moduleLevelRule{pattern: pattern, level: level}
+	if duration > 0 {
+		rule.expiry = time.Now().Add(duration)
+		rule.timer = time.AfterFunc(duration, func() { logger.expireModuleLevel(rule) })
+	}
+	logger.moduleLock.Lock()
+	rules := make([]*moduleLevelRule, 0, len(logger.moduleRules)+1)
+	for _, old := range logger.moduleRules {
+		//a timed override is added on top of a permanent level for the same pattern
+		if old.pattern == pattern && (old.expiry.IsZero() == rule.expiry.IsZero()) {
+			if old.timer != nil {
+				old.timer.Stop()
+			}
+			continue
+		}
+		rules = append(rules, old)
+	}
+	logger.moduleRules = append(rules, rule)
+	logger.moduleLock.Unlock()
+	logger.Debug(fmt.Sprintln("Changed logging level of modules", pattern, "to:", level, "for", logger.MyComponentName, "duration", duration))
+	return nil
+}
+
+func (logger *Writer) expireModuleLevel(rule *moduleLevelRule) {
+	logger.moduleLock.Lock()
+	found := false
+	for i, r := range logger.moduleRules {
+		if r == rule {
+			logger.moduleRules = append(logger.moduleRules[:i:i], logger.moduleRules[i+1:]...)
+			found = true
+			break
+		}
+	}
+	logger.moduleLock.Unlock()
+	if found {
+		logger.Debug(fmt.Sprintln("Logging level override of modules", rule.pattern, "expired for", logger.MyComponentName))
+	}
+}
+
+//removes the permanent and the timed levels set for pattern
+func (logger *Writer) ClearModuleLevel(pattern string) {
+	logger.moduleLock.Lock()
+	defer logger.moduleLock.Unlock()
+	rules := make([]*moduleLevelRule, 0, len(logger.moduleRules))
+	for _, rule := range logger.moduleRules {
+		if rule.pattern == pattern {
+			if rule.timer != nil {
+				rule.timer.Stop()
+			}
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	logger.moduleRules = rules
+}
+
+//level in effect for the module: the most specific timed override, else the most specific
+//permanent level, else the component level
+func (logger *Writer) GetModuleLevel(module string) sysdCommonDefs.SRDebugLevel {
+	if module == "" {
+		return logger.MyLogLevel
+	}
+	logger.moduleLock.RLock()
+	defer logger.moduleLock.RUnlock()
+	if len(logger.moduleRules) == 0 {
+		return logger.MyLogLevel
+	}
+	now := time.Now()
+	var best *moduleLevelRule
+	for _, rule := range logger.moduleRules {
+		timed := !rule.expiry.IsZero()
+		if timed && !now.Before(rule.expiry) {
+			continue
+		}
+		if match, _ := path.Match(rule.pattern, module); !match {
+			continue
+		}
+		if best != nil {
+			bestTimed := !best.expiry.IsZero()
+			if bestTimed && !timed {
+				continue
+			}
+			if bestTimed == timed && rule.specificity() < best.specificity() {
+				continue
+			}
+		}
+		best = rule
+	}
+	if best == nil {
+		return logger.MyLogLevel
+	}
+	return best.level
+}
+
+func (logger *Writer) processModuleLogging(mLog ModuleLogging) error {
+	if mLog.Component != logger.MyComponentName {
+		return nil
+	}
+	if mLog.Clear {
+		logger.ClearModuleLevel(mLog.Module)
+		return nil
+	}
+	if mLog.Duration > 0 {
+		return logger.SetModuleLevelFor(mLog.Module, mLog.Level, time.Duration(mLog.Duration)*time.Second)
+	}
+	return logger.SetModuleLevel(mLog.Module, mLog.Level)
+}
+
+func (ml *ModuleLogger) Crit(message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.CRIT, fmt.Sprintln(message...))
+}
+
+func (ml *ModuleLogger) Err(message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.ERR, fmt.Sprintln(message...))
+}
+
+func (ml *ModuleLogger) Warning(message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.WARN, fmt.Sprintln(message...))
+}
+
+func (ml *ModuleLogger) Alert(message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.ALERT, fmt.Sprintln(message...))
+}
+
+func (ml *ModuleLogger) Emerg(message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.EMERG, fmt.Sprintln(message...))
+}
+
+func (ml *ModuleLogger) Notice(message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.NOTICE, fmt.Sprintln(message...))
+}
+
+func (ml *ModuleLogger) Info(message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.INFO, fmt.Sprintln(message...))
+}
+
+func (ml *ModuleLogger) Println(message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.INFO, fmt.Sprintln(message...))
+}
+
+func (ml *ModuleLogger) Debug(message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.DEBUG, fmt.Sprintln(message...))
+}
+
+func (ml *ModuleLogger) Critf(format string, message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.CRIT, fmt.Sprintf(format, message...))
+}
+
+func (ml *ModuleLogger) Errf(format string, message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.ERR, fmt.Sprintf(format, message...))
+}
+
+func (ml *ModuleLogger) Warningf(format string, message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.WARN, fmt.Sprintf(format, message...))
+}
+
+func (ml *ModuleLogger) Alertf(format string, message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.ALERT, fmt.Sprintf(format, message...))
+}
+
+func (ml *ModuleLogger) Emergf(format string, message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.EMERG, fmt.Sprintf(format, message...))
+}
+
+func (ml *ModuleLogger) Noticef(format string, message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.NOTICE, fmt.Sprintf(format, message...))
+}
+
+func (ml *ModuleLogger) Infof(format string, message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.INFO, fmt.Sprintf(format, message...))
+}
+
+func (ml *ModuleLogger) Printf(format string, message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.INFO, fmt.Sprintf(format, message...))
+}
+
+func (ml *ModuleLogger) Debugf(format string, message ...interface{}) error {
+	return ml.writer.logModuleMessage(ml.name, sysdCommonDefs.DEBUG, fmt.Sprintf(format, message...))
+}
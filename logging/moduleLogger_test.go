@@ -0,0 +1,84 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package logging
+
+import (
+	"encoding/json"
+	"infra/sysd/sysdCommonDefs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestModuleLevels(t *testing.T) {
+	sink := NewMemorySink(0)
+	logger := NewLoggerWithSinks("bgpd", sysdCommonDefs.INFO, sink)
+	fsm := logger.Module("bgp.fsm")
+	peer := logger.Module("bgp").Module("peer.10.0.0.1")
+	if err := logger.SetModuleLevel("bgp.peer.*", sysdCommonDefs.DEBUG); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.SetModuleLevel("bgp.peer.10.0.0.1", sysdCommonDefs.ERR); err != nil {
+		t.Fatal(err)
+	}
+	if level := logger.GetModuleLevel("bgp.peer.10.0.0.2"); level != sysdCommonDefs.DEBUG {
+		t.Errorf("level of bgp.peer.10.0.0.2 %v, expected the glob level", level)
+	}
+	if level := logger.GetModuleLevel(peer.Name()); level != sysdCommonDefs.ERR {
+		t.Errorf("level of %s %v, expected the exact level", peer.Name(), level)
+	}
+	fsm.Debug("dropped by the component level")
+	peer.Info("dropped by the module level")
+	if err := logger.SetModuleLevelFor("bgp.*", sysdCommonDefs.TRACE, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	fsm.Debug("state change")
+	peer.With("state", "established").Debug("peer up")
+	records := sink.Records()
+	if len(records) != 2 || records[0].Message != "bgp.fsm: state change" ||
+		!strings.Contains(records[1].Message, "module=bgp.peer.10.0.0.1 msg=\"peer up\" state=established") {
+		t.Fatalf("records %+v", records)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if level := logger.GetModuleLevel(fsm.Name()); level != sysdCommonDefs.INFO {
+		t.Errorf("level of bgp.fsm %v after the override expired, expected the component level", level)
+	}
+	logger.ClearModuleLevel("bgp.peer.10.0.0.1")
+	if level := logger.GetModuleLevel(peer.Name()); level != sysdCommonDefs.DEBUG {
+		t.Errorf("level of %s %v after clear, expected the glob level", peer.Name(), level)
+	}
+}
+
+func TestModuleLoggingNotification(t *testing.T) {
+	logger := NewLoggerWithSinks("bgpd", sysdCommonDefs.INFO)
+	payload, _ := json.Marshal(ModuleLogging{Component: "bgpd", Module: "bgp.*", Level: sysdCommonDefs.DEBUG, Duration: 60})
+	notification, _ := json.Marshal(sysdCommonDefs.Notification{Type: MODULE_LOG, Payload: payload})
+	if err := logger.ProcessLoggingNotification(notification); err != nil {
+		t.Fatal(err)
+	}
+	if level := logger.GetModuleLevel("bgp.fsm"); level != sysdCommonDefs.DEBUG {
+		t.Errorf("level of bgp.fsm %v, expected debug", level)
+	}
+	logger.ClearModuleLevel("bgp.*")
+}
@@ -0,0 +1,173 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// rateLimit.go
+package logging
+
+import (
+	"fmt"
+	"infra/sysd/sysdCommonDefs"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RATE_LIMIT_REPORT_INTERVAL = 10 * time.Second //delay of the "message repeated" report of suppressed messages
+	RATE_LIMIT_MAX_BUCKETS     = 4096             //idle buckets are dropped above this number of distinct messages
+)
+
+//token bucket budget of every distinct message of a level
+type rateBudget struct {
+	rate  float64 //messages per second
+	burst float64
+}
+
+type messageBucket struct {
+	level      sysdCommonDefs.SRDebugLevel
+	message    string
+	tokens     float64
+	last       time.Time
+	suppressed int
+	reporting  bool //a report of the suppressed messages is scheduled
+}
+
+type rateLimiter struct {
+	lock           sync.Mutex
+	budgets        map[sysdCommonDefs.SRDebugLevel]rateBudget
+	buckets        map[string]*messageBucket
+	reportInterval time.Duration
+}
+
+//crit, alert and emerg messages are never rate limited
+func isRateLimitExempt(level sysdCommonDefs.SRDebugLevel) bool {
+	return level == sysdCommonDefs.CRIT || level == sysdCommonDefs.ALERT || level == sysdCommonDefs.EMERG
+}
+
+//limits every distinct message of level to rate messages per second with bursts of burst messages.
+//The messages over the budget are dropped and reported as "message repeated N times" afterwards.
+//A rate of 0 removes the limit of the level
+func (logger *Writer) SetRateLimit(level sysdCommonDefs.SRDebugLevel, rate float64, burst int) error {
+	if isRateLimitExempt(level) {
+		return fmt.Errorf("Level %s cannot be rate limited", ConvertLevelValToStr(level))
+	}
+	if rate < 0 || (rate > 0 && burst < 1) {
+		return fmt.Errorf("Invalid rate limit %v/s burst %d", rate, burst)
+	}
+	limiter := logger.getRateLimiter()
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if rate == 0 {
+		delete(limiter.budgets, level)
+	} else {
+		limiter.budgets[level] = rateBudget{rate: rate, burst: float64(burst)}
+	}
+	return nil
+}
+
+func (logger *Writer) SetRateLimitReportInterval(interval time.Duration) {
+	limiter := logger.getRateLimiter()
+	limiter.lock.Lock()
+	limiter.reportInterval = interval
+	limiter.lock.Unlock()
+}
+
+func (logger *Writer) getRateLimiter() *rateLimiter {
+	logger.rateLimiterOnce.Do(func() {
+		logger.rateLimiter = &rateLimiter{
+			budgets:        make(map[sysdCommonDefs.SRDebugLevel]rateBudget),
+			buckets:        make(map[string]*messageBucket),
+			reportInterval: RATE_LIMIT_REPORT_INTERVAL,
+		}
+	})
+	return logger.rateLimiter
+}
+
+//whether the message is within the budget of its level
+func (logger *Writer) allowMessage(level sysdCommonDefs.SRDebugLevel, message string) bool {
+	if isRateLimitExempt(level) {
+		return true
+	}
+	limiter := logger.getRateLimiter()
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	budget, ok := limiter.budgets[level]
+	if !ok {
+		return true
+	}
+	now := time.Now()
+	key := ConvertLevelValToStr(level) + " " + message
+	bucket := limiter.buckets[key]
+	if bucket == nil {
+		if len(limiter.buckets) >= RATE_LIMIT_MAX_BUCKETS {
+			limiter.dropIdleBuckets(now)
+		}
+		bucket = &messageBucket{level: level, message: message, tokens: budget.burst, last: now}
+		limiter.buckets[key] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * budget.rate
+	if bucket.tokens > budget.burst {
+		bucket.tokens = budget.burst
+	}
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true
+	}
+	bucket.suppressed++
+	if !bucket.reporting {
+		bucket.reporting = true
+		time.AfterFunc(limiter.reportInterval, func() { logger.reportSuppressed(key) })
+	}
+	return false
+}
+
+//drops the buckets which are back to a full budget and have nothing to report
+func (limiter *rateLimiter) dropIdleBuckets(now time.Time) {
+	for key, bucket := range limiter.buckets {
+		budget, ok := limiter.budgets[bucket.level]
+		if bucket.reporting {
+			continue
+		}
+		if !ok || bucket.tokens+now.Sub(bucket.last).Seconds()*budget.rate >= budget.burst {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+func (logger *Writer) reportSuppressed(key string) {
+	limiter := logger.getRateLimiter()
+	limiter.lock.Lock()
+	bucket := limiter.buckets[key]
+	if bucket == nil {
+		limiter.lock.Unlock()
+		return
+	}
+	suppressed := bucket.suppressed
+	bucket.suppressed = 0
+	bucket.reporting = false
+	limiter.lock.Unlock()
+	if suppressed > 0 {
+		logger.output(bucket.level, fmt.Sprintf("message repeated %d times: %s", suppressed, strings.TrimRight(bucket.message, "\n")))
+	}
+}
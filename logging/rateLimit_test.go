@@ -0,0 +1,66 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package logging
+
+import (
+	"infra/sysd/sysdCommonDefs"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	sink := NewMemorySink(0)
+	logger := NewLoggerWithSinks("asicd", sysdCommonDefs.INFO, sink)
+	if err := logger.SetRateLimit(sysdCommonDefs.ERR, 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.SetRateLimit(sysdCommonDefs.CRIT, 1, 3); err == nil {
+		t.Error("crit messages can be rate limited")
+	}
+	logger.SetRateLimitReportInterval(50 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		logger.Err("port fpPort1 flapped")
+		logger.Crit("fan failure")
+	}
+	logger.Err("another error")
+	records := sink.Records()
+	errCount, critCount := 0, 0
+	for _, record := range records {
+		switch record.Level {
+		case sysdCommonDefs.ERR:
+			errCount++
+		case sysdCommonDefs.CRIT:
+			critCount++
+		}
+	}
+	if errCount != 4 || critCount != 100 {
+		t.Errorf("%d err and %d crit records, expected 4 and 100", errCount, critCount)
+	}
+	time.Sleep(150 * time.Millisecond)
+	records = sink.Records()
+	last := records[len(records)-1]
+	if last.Message != "message repeated 97 times: port fpPort1 flapped" {
+		t.Errorf("last record %q, expected the repeat report", last.Message)
+	}
+}
@@ -0,0 +1,228 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// remoteSysLogSink.go
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"infra/sysd/sysdCommonDefs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	REMOTE_SYSLOG_DIAL_TIMEOUT          = 5 * time.Second
+	REMOTE_SYSLOG_WRITE_TIMEOUT         = time.Second
+	REMOTE_SYSLOG_RECONNECT_MIN_BACKOFF = 500 * time.Millisecond
+	REMOTE_SYSLOG_RECONNECT_MAX_BACKOFF = 30 * time.Second
+	REMOTE_SYSLOG_QUEUE_SIZE            = 1000 //messages kept while the collector is down
+	SYSLOG_FACILITY_DAEMON              = 3
+)
+
+type RemoteSysLogStats struct {
+	Sent    uint64
+	Dropped uint64 //oldest messages dropped from a full queue
+	Pending int
+}
+
+//RFC 5424 messages sent to a remote collector over udp or tcp.
+//Over tcp the messages are framed with octet counting (RFC 6587).
+//While the collector is down the messages are queued and a background goroutine
+//reconnects with backoff, WriteLog itself never dials
+type RemoteSysLogSink struct {
+	network      string
+	addr         string
+	appName      string
+	hostname     string
+	conn         net.Conn
+	pending      []string //messages not yet written, oldest first
+	reconnecting bool
+	closed       bool
+	stopCh       chan struct{}
+	stats        RemoteSysLogStats
+	lock         sync.Mutex
+}
+
+func NewRemoteSysLogSink(network string, addr string, appName string) (*RemoteSysLogSink, error) {
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("Unsupported network %s for remote syslog, use udp or tcp", network)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	sink := &RemoteSysLogSink{network: network, addr: addr, appName: appName, hostname: hostname, stopCh: make(chan struct{})}
+	if sink.conn, err = sink.dial(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (sink *RemoteSysLogSink) dial() (net.Conn, error) {
+	return net.DialTimeout(sink.network, sink.addr, REMOTE_SYSLOG_DIAL_TIMEOUT)
+}
+
+//syslog severity of the level
+func sysLogSeverity(level sysdCommonDefs.SRDebugLevel) int {
+	switch level {
+	case sysdCommonDefs.EMERG:
+		return 0
+	case sysdCommonDefs.ALERT:
+		return 1
+	case sysdCommonDefs.CRIT:
+		return 2
+	case sysdCommonDefs.ERR:
+		return 3
+	case sysdCommonDefs.WARN:
+		return 4
+	case sysdCommonDefs.NOTICE:
+		return 5
+	case sysdCommonDefs.INFO:
+		return 6
+	}
+	return 7
+}
+
+func (sink *RemoteSysLogSink) formatMessage(now time.Time, level sysdCommonDefs.SRDebugLevel, message string) string {
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s", SYSLOG_FACILITY_DAEMON*8+sysLogSeverity(level),
+		now.Format("2006-01-02T15:04:05.000000Z07:00"), sink.hostname, sink.appName, os.Getpid(),
+		strings.TrimRight(message, "\n"))
+	if sink.network == "tcp" {
+		return fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	return msg
+}
+
+//queues the message and writes the queue if the collector is connected. The message is
+//kept for the reconnect when the write fails
+func (sink *RemoteSysLogSink) WriteLog(level sysdCommonDefs.SRDebugLevel, message string) (err error) {
+	msg := sink.formatMessage(time.Now(), level, message)
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.closed {
+		return errors.New("Remote syslog sink closed")
+	}
+	if len(sink.pending) >= REMOTE_SYSLOG_QUEUE_SIZE {
+		copy(sink.pending, sink.pending[1:])
+		sink.pending = sink.pending[:len(sink.pending)-1]
+		sink.stats.Dropped++
+	}
+	sink.pending = append(sink.pending, msg)
+	if sink.conn == nil {
+		sink.startReconnect()
+		return nil
+	}
+	return sink.flush()
+}
+
+//writes the pending messages, each with a write deadline. On error the connection
+//is dropped and the messages left wait for the reconnect
+func (sink *RemoteSysLogSink) flush() error {
+	sent := 0
+	defer func() {
+		sink.pending = append(sink.pending[:0], sink.pending[sent:]...)
+	}()
+	for ; sent < len(sink.pending); sent++ {
+		sink.conn.SetWriteDeadline(time.Now().Add(REMOTE_SYSLOG_WRITE_TIMEOUT))
+		if _, err := sink.conn.Write([]byte(sink.pending[sent])); err != nil {
+			sink.conn.Close()
+			sink.conn = nil
+			sink.startReconnect()
+			return err
+		}
+		sink.stats.Sent++
+	}
+	return nil
+}
+
+func (sink *RemoteSysLogSink) startReconnect() {
+	if sink.reconnecting || sink.closed {
+		return
+	}
+	sink.reconnecting = true
+	go sink.reconnect()
+}
+
+//dials without holding the lock, at most once per backoff period, until connected or closed
+func (sink *RemoteSysLogSink) reconnect() {
+	backoff := REMOTE_SYSLOG_RECONNECT_MIN_BACKOFF
+	for {
+		select {
+		case <-sink.stopCh:
+			return
+		case <-time.After(backoff):
+		}
+		conn, err := sink.dial()
+		sink.lock.Lock()
+		if sink.closed {
+			sink.lock.Unlock()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		if err == nil {
+			sink.conn = conn
+			sink.reconnecting = false
+			sink.flush()
+			sink.lock.Unlock()
+			return
+		}
+		sink.lock.Unlock()
+		backoff *= 2
+		if backoff > REMOTE_SYSLOG_RECONNECT_MAX_BACKOFF {
+			backoff = REMOTE_SYSLOG_RECONNECT_MAX_BACKOFF
+		}
+	}
+}
+
+func (sink *RemoteSysLogSink) GetStats() RemoteSysLogStats {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	stats := sink.stats
+	stats.Pending = len(sink.pending)
+	return stats
+}
+
+//the messages still queued are discarded
+func (sink *RemoteSysLogSink) Close() error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.closed {
+		return nil
+	}
+	sink.closed = true
+	close(sink.stopCh)
+	sink.pending = nil
+	if sink.conn == nil {
+		return nil
+	}
+	err := sink.conn.Close()
+	sink.conn = nil
+	return err
+}
@@ -0,0 +1,106 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// traceContext.go
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+//HTTP header carrying the trace ID of a request
+const TRACE_ID_HEADER = "X-Trace-Id"
+
+type contextKey int
+
+const (
+	traceIdContextKey contextKey = iota
+	loggerContextKey
+)
+
+//random 16 byte ID in hex
+func NewTraceId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+func WithTraceId(ctx context.Context, traceId string) context.Context {
+	return context.WithValue(ctx, traceIdContextKey, traceId)
+}
+
+func TraceIdFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	traceId, _ := ctx.Value(traceIdContextKey).(string)
+	return traceId
+}
+
+//stores the logger in ctx for FromContext
+func NewContext(ctx context.Context, logger *FieldLogger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+//logger stored in ctx, with the trace ID of ctx as trace_id field. Without a stored logger
+//the returned logger drops all the records
+func FromContext(ctx context.Context) *FieldLogger {
+	var logger *FieldLogger
+	if ctx != nil {
+		logger, _ = ctx.Value(loggerContextKey).(*FieldLogger)
+	}
+	if logger == nil {
+		logger = (&Writer{}).With()
+	}
+	if traceId := TraceIdFromContext(ctx); traceId != "" {
+		return logger.With("trace_id", traceId)
+	}
+	return logger
+}
+
+//logger with the trace ID of ctx as trace_id field
+func (logger *Writer) FromContext(ctx context.Context) *FieldLogger {
+	if traceId := TraceIdFromContext(ctx); traceId != "" {
+		return logger.With("trace_id", traceId)
+	}
+	return logger.With()
+}
+
+//handler giving every request a context with the trace ID of its X-Trace-Id header, or a new one,
+//and the logger. The trace ID is returned in the response header
+func TraceHandler(logger *Writer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceId := r.Header.Get(TRACE_ID_HEADER)
+		if traceId == "" {
+			traceId = NewTraceId()
+		}
+		w.Header().Set(TRACE_ID_HEADER, traceId)
+		ctx := NewContext(WithTraceId(r.Context(), traceId), logger.With())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
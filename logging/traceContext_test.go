@@ -0,0 +1,75 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package logging
+
+import (
+	"context"
+	"infra/sysd/sysdCommonDefs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCallerCapture(t *testing.T) {
+	sink := NewMemorySink(0)
+	logger := NewLoggerWithSinks("ribd", sysdCommonDefs.INFO, sink)
+	logger.SetCallerCapture(true)
+	logger.Info("plain")
+	logger.With("route", "10.1.0.0/16").Info("structured")
+	records := sink.Records()
+	if len(records) != 2 {
+		t.Fatalf("records %+v", records)
+	}
+	if !strings.HasPrefix(records[0].Message, "[logging/traceContext_test.go:") ||
+		!strings.Contains(records[0].Message, "logging.TestCallerCapture g") {
+		t.Errorf("plain record without the caller: %q", records[0].Message)
+	}
+	if !strings.Contains(records[1].Message, "caller=logging/traceContext_test.go:") ||
+		!strings.Contains(records[1].Message, "func=logging.TestCallerCapture goroutine=") {
+		t.Errorf("structured record without the caller: %q", records[1].Message)
+	}
+}
+
+func TestTraceHandler(t *testing.T) {
+	sink := NewMemorySink(0)
+	logger := NewLoggerWithSinks("confd", sysdCommonDefs.INFO, sink)
+	handler := TraceHandler(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Info("handling request", "path", r.URL.Path)
+	}))
+	request := httptest.NewRequest("GET", "/public/v1/config/Port", nil)
+	request.Header.Set(TRACE_ID_HEADER, "0123abcd")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if response.Header().Get(TRACE_ID_HEADER) != "0123abcd" {
+		t.Errorf("response trace ID %q", response.Header().Get(TRACE_ID_HEADER))
+	}
+	records := sink.Records()
+	if len(records) != 1 || !strings.HasSuffix(records[0].Message, "trace_id=0123abcd path=/public/v1/config/Port") {
+		t.Errorf("records %+v", records)
+	}
+	if FromContext(context.Background()).Info("dropped") != nil {
+		t.Error("logger of a context without a logger failed")
+	}
+}
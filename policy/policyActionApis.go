@@ -52,16 +52,16 @@ type PolicyAction struct {
 }
 
 type PolicyActionConfig struct {
-	Name                           string
-	ActionType                     string
-	SetAdminDistanceValue          int
-	Accept                         bool
-	Reject                         bool
-	RedistributeAction             string
-	RedistributeTargetProtocol     string
-	NetworkStatementTargetProtocol string
-	GenerateASSet                  bool
-	SendSummaryOnly                bool
+	Name                           string `json:"Name" yaml:"Name"`
+	ActionType                     string `json:"ActionType,omitempty" yaml:"ActionType,omitempty"`
+	SetAdminDistanceValue          int    `json:"SetAdminDistanceValue,omitempty" yaml:"SetAdminDistanceValue,omitempty"`
+	Accept                         bool   `json:"Accept,omitempty" yaml:"Accept,omitempty"`
+	Reject                         bool   `json:"Reject,omitempty" yaml:"Reject,omitempty"`
+	RedistributeAction             string `json:"RedistributeAction,omitempty" yaml:"RedistributeAction,omitempty"`
+	RedistributeTargetProtocol     string `json:"RedistributeTargetProtocol,omitempty" yaml:"RedistributeTargetProtocol,omitempty"`
+	NetworkStatementTargetProtocol string `json:"NetworkStatementTargetProtocol,omitempty" yaml:"NetworkStatementTargetProtocol,omitempty"`
+	GenerateASSet                  bool   `json:"GenerateASSet,omitempty" yaml:"GenerateASSet,omitempty"`
+	SendSummaryOnly                bool   `json:"SendSummaryOnly,omitempty" yaml:"SendSummaryOnly,omitempty"`
 }
 
 func (db *PolicyEngineDB) CreatePolicyRouteDispositionAction(cfg PolicyActionConfig) (val bool, err error) {
@@ -265,6 +265,12 @@ func (db *PolicyEngineDB) CreatePolicyAction(cfg PolicyActionConfig) (val bool,
 		err = errors.New("Unknown action type")
 		return false, err
 	}
+	if err == nil {
+		if actionItem := db.PolicyActionsDB.Get(patriciaDB.Prefix(cfg.Name)); actionItem != nil {
+			db.updatePolicyDependencies(PolicyObjectTypeAction, PolicyNotifyCreate, cfg.Name, actionItem)
+			db.notifyPolicySubscribers(PolicyObjectTypeAction, PolicyNotifyCreate, cfg.Name, nil, actionItem)
+		}
+	}
 	return val, err
 }
 
@@ -277,15 +283,16 @@ func (db *PolicyEngineDB) DeletePolicyAction(cfg PolicyActionConfig) (val bool,
 		return false, err
 	}
 	action := actionItem.(PolicyAction)
-	if len(action.PolicyStmtList) != 0 {
-		db.Logger.Err(fmt.Sprintln("This action is currently being used by one or more policy statements. Try deleting the stmt before deleting the action"))
-		err = errors.New("This action is currently being used by one or more policy statements. Try deleting the stmt before deleting the action")
+	err = db.ValidatePolicyObjectDelete(PolicyObjectTypeAction, cfg.Name)
+	if err != nil {
 		return false, err
 	}
 	deleted := db.PolicyActionsDB.Delete(patriciaDB.Prefix(cfg.Name))
 	if deleted {
 		db.Logger.Info(fmt.Sprintln("Found and deleted actions ", cfg.Name))
 		db.LocalPolicyActionsDB.updateLocalDB(patriciaDB.Prefix(cfg.Name), del)
+		db.updatePolicyDependencies(PolicyObjectTypeAction, PolicyNotifyDelete, cfg.Name, nil)
+		db.notifyPolicySubscribers(PolicyObjectTypeAction, PolicyNotifyDelete, cfg.Name, action, nil)
 	}
 	return true, err
 }
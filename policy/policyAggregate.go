@@ -0,0 +1,231 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// policyAggregate.go
+package policy
+
+import (
+	"net"
+	"sort"
+)
+
+//aggregate route maintained by the policy engine for the aggregate actions
+type PolicyAggregate struct {
+	Prefix          string //CIDR
+	AddressFamily   int
+	GenerateASSet   bool
+	SendSummaryOnly bool
+	PolicyStmt      string   //stmt whose aggregate action created the aggregate
+	Contributors    []string //more specific routes, CIDR
+}
+
+type policyAggregateEntry struct {
+	aggregate    PolicyAggregate
+	contributors map[string]map[string]bool //route to the stmts it contributes through
+}
+
+type PolicyAggregatefunc func(aggregate PolicyAggregate, params interface{})
+type PolicyAggregateSuppressfunc func(route string, aggregate PolicyAggregate, suppressed bool, params interface{})
+
+func (db *PolicyEngineDB) SetAggregateCreateFunc(createfunc PolicyAggregatefunc) {
+	db.AggregateCreateFunc = createfunc
+}
+func (db *PolicyEngineDB) SetAggregateWithdrawFunc(withdrawfunc PolicyAggregatefunc) {
+	db.AggregateWithdrawFunc = withdrawfunc
+}
+func (db *PolicyEngineDB) SetAggregateSuppressFunc(suppressfunc PolicyAggregateSuppressfunc) {
+	db.AggregateSuppressFunc = suppressfunc
+}
+
+func (entry *policyAggregateEntry) snapshot() PolicyAggregate {
+	aggregate := entry.aggregate
+	aggregate.Contributors = make([]string, 0, len(entry.contributors))
+	for route := range entry.contributors {
+		aggregate.Contributors = append(aggregate.Contributors, route)
+	}
+	sort.Strings(aggregate.Contributors)
+	return aggregate
+}
+
+//aggregate prefixes of the matched prefix conditions that the route is a more specific of
+func (db *PolicyEngineDB) aggregatePrefixesForRoute(route string, conditionInfoList []interface{}) (prefixList []*net.IPNet) {
+	_, routeNet, err := net.ParseCIDR(route)
+	if err != nil {
+		db.Logger.Err("Invalid route ", route, " for aggregation")
+		return nil
+	}
+	routeMaskLen, _ := routeNet.Mask.Size()
+	prefixInfoList := make([]MatchPrefixConditionInfo, 0)
+	for _, conditionInfo := range conditionInfoList {
+		prefixInfo, ok := conditionInfo.(MatchPrefixConditionInfo)
+		if !ok {
+			continue
+		}
+		if !prefixInfo.UsePrefixSet {
+			prefixInfoList = append(prefixInfoList, prefixInfo)
+			continue
+		}
+		if item := db.PolicyPrefixSetDB.Get([]byte(prefixInfo.PrefixSet)); item != nil {
+			prefixInfoList = append(prefixInfoList, item.(PolicyPrefixSet).PrefixInfoList...)
+		}
+	}
+	for _, prefixInfo := range prefixInfoList {
+		_, aggregateNet, err := net.ParseCIDR(prefixInfo.Prefix.IpPrefix)
+		if err != nil {
+			continue
+		}
+		aggregateMaskLen, _ := aggregateNet.Mask.Size()
+		if routeMaskLen <= aggregateMaskLen || !db.FindPrefixMatch(route, nil, PolicyCondition{ConditionInfo: prefixInfo}) {
+			continue
+		}
+		prefixList = append(prefixList, aggregateNet)
+	}
+	return prefixList
+}
+
+//adds the route as a contributor to the aggregates of the matched conditions, creating the aggregates
+//on their first contributor. An aggregate keeps the action info of the stmt which created it, a stmt
+//with a different one cannot contribute to it
+func (db *PolicyEngineDB) addAggregateContributor(route string, policyStmt string, actionInfo PolicyAggregateActionInfo,
+	conditionInfoList []interface{}, params interface{}) {
+	db.Logger.Info("addAggregateContributor ", route, " stmt ", policyStmt)
+	var created []PolicyAggregate
+	var suppressed []PolicyAggregate
+	db.AggregateLock.Lock()
+	for _, aggregateNet := range db.aggregatePrefixesForRoute(route, conditionInfoList) {
+		prefix := aggregateNet.String()
+		entry, ok := db.AggregateDB[prefix]
+		if !ok {
+			entry = &policyAggregateEntry{
+				aggregate: PolicyAggregate{Prefix: prefix, AddressFamily: GetPrefixAddressFamily(aggregateNet),
+					GenerateASSet: actionInfo.GenerateASSet, SendSummaryOnly: actionInfo.SendSummaryOnly, PolicyStmt: policyStmt},
+				contributors: make(map[string]map[string]bool),
+			}
+			db.AggregateDB[prefix] = entry
+		} else if entry.aggregate.GenerateASSet != actionInfo.GenerateASSet || entry.aggregate.SendSummaryOnly != actionInfo.SendSummaryOnly {
+			db.Logger.Err("Aggregate action of stmt ", policyStmt, " conflicts with the one of stmt ", entry.aggregate.PolicyStmt,
+				" for aggregate ", prefix, ", route ", route, " not added")
+			continue
+		}
+		if entry.contributors[route] == nil {
+			entry.contributors[route] = make(map[string]bool)
+		}
+		if entry.contributors[route][policyStmt] {
+			continue
+		}
+		entry.contributors[route][policyStmt] = true
+		if len(entry.contributors) == 1 && len(entry.contributors[route]) == 1 {
+			db.Logger.Info("First contributor ", route, " for aggregate ", prefix)
+			created = append(created, entry.snapshot())
+		}
+		if entry.aggregate.SendSummaryOnly && len(entry.contributors[route]) == 1 {
+			suppressed = append(suppressed, entry.snapshot())
+		}
+	}
+	db.AggregateLock.Unlock()
+	for _, aggregate := range created {
+		if db.AggregateCreateFunc != nil {
+			db.AggregateCreateFunc(aggregate, params)
+		}
+	}
+	for _, aggregate := range suppressed {
+		if db.AggregateSuppressFunc != nil {
+			db.AggregateSuppressFunc(route, aggregate, true, params)
+		}
+	}
+}
+
+//removes the route contributing through policyStmt from all aggregates, withdrawing the aggregates
+//which lost their last contributor
+func (db *PolicyEngineDB) removeAggregateContributor(route string, policyStmt string, params interface{}) {
+	var withdrawn []PolicyAggregate
+	var unsuppressed []PolicyAggregate
+	db.AggregateLock.Lock()
+	for prefix, entry := range db.AggregateDB {
+		if !entry.contributors[route][policyStmt] {
+			continue
+		}
+		db.Logger.Info("removeAggregateContributor ", route, " stmt ", policyStmt, " aggregate ", prefix)
+		delete(entry.contributors[route], policyStmt)
+		if len(entry.contributors[route]) != 0 {
+			continue
+		}
+		delete(entry.contributors, route)
+		if entry.aggregate.SendSummaryOnly {
+			unsuppressed = append(unsuppressed, entry.snapshot())
+		}
+		if len(entry.contributors) == 0 {
+			db.Logger.Info("Last contributor ", route, " removed for aggregate ", prefix)
+			delete(db.AggregateDB, prefix)
+			withdrawn = append(withdrawn, entry.snapshot())
+		}
+	}
+	db.AggregateLock.Unlock()
+	for _, aggregate := range unsuppressed {
+		if db.AggregateSuppressFunc != nil {
+			db.AggregateSuppressFunc(route, aggregate, false, params)
+		}
+	}
+	for _, aggregate := range withdrawn {
+		if db.AggregateWithdrawFunc != nil {
+			db.AggregateWithdrawFunc(aggregate, params)
+		}
+	}
+}
+
+func (db *PolicyEngineDB) GetAggregate(prefix string) (aggregate PolicyAggregate, found bool) {
+	_, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return aggregate, false
+	}
+	db.AggregateLock.RLock()
+	defer db.AggregateLock.RUnlock()
+	entry, found := db.AggregateDB[ipNet.String()]
+	if !found {
+		return aggregate, false
+	}
+	return entry.snapshot(), true
+}
+
+func (db *PolicyEngineDB) GetAllAggregates() (aggregateList []PolicyAggregate) {
+	db.AggregateLock.RLock()
+	defer db.AggregateLock.RUnlock()
+	aggregateList = make([]PolicyAggregate, 0, len(db.AggregateDB))
+	for _, entry := range db.AggregateDB {
+		aggregateList = append(aggregateList, entry.snapshot())
+	}
+	sort.Slice(aggregateList, func(i, j int) bool { return aggregateList[i].Prefix < aggregateList[j].Prefix })
+	return aggregateList
+}
+
+//a route is suppressed if it contributes to a summary only aggregate
+func (db *PolicyEngineDB) IsRouteSuppressed(route string) bool {
+	db.AggregateLock.RLock()
+	defer db.AggregateLock.RUnlock()
+	for _, entry := range db.AggregateDB {
+		if entry.aggregate.SendSummaryOnly && entry.contributors[route] != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -27,6 +27,7 @@ package policy
 import (
 	"errors"
 	"fmt"
+	"net"
 	"reflect"
 	"strconv"
 	"strings"
@@ -48,11 +49,11 @@ type PolicyStmt struct { //policy engine uses this
 	//	GlobalStmt      bool
 }
 type PolicyStmtConfig struct {
-	Name            string
-	AdminState      string
-	MatchConditions string
-	Conditions      []string
-	Actions         []string
+	Name            string   `json:"Name" yaml:"Name"`
+	AdminState      string   `json:"AdminState,omitempty" yaml:"AdminState,omitempty"`
+	MatchConditions string   `json:"MatchConditions,omitempty" yaml:"MatchConditions,omitempty"`
+	Conditions      []string `json:"Conditions,omitempty" yaml:"Conditions,omitempty"`
+	Actions         []string `json:"Actions,omitempty" yaml:"Actions,omitempty"`
 }
 
 type Policy struct {
@@ -69,19 +70,19 @@ type Policy struct {
 }
 
 type PolicyDefinitionStmtPrecedence struct {
-	Precedence int
-	Statement  string
+	Precedence int    `json:"Precedence,omitempty" yaml:"Precedence,omitempty"`
+	Statement  string `json:"Statement,omitempty" yaml:"Statement,omitempty"`
 }
 type PolicyDefinitionConfig struct {
-	Name                       string
-	Precedence                 int
-	MatchType                  string
-	PolicyDefinitionStatements []PolicyDefinitionStmtPrecedence
-	Export                     bool
-	Import                     bool
-	Global                     bool
-	PolicyType                 string
-	Extensions                 interface{}
+	Name                       string                           `json:"Name" yaml:"Name"`
+	Precedence                 int                              `json:"Precedence,omitempty" yaml:"Precedence,omitempty"`
+	MatchType                  string                           `json:"MatchType,omitempty" yaml:"MatchType,omitempty"`
+	PolicyDefinitionStatements []PolicyDefinitionStmtPrecedence `json:"PolicyDefinitionStatements,omitempty" yaml:"PolicyDefinitionStatements,omitempty"`
+	Export                     bool                             `json:"Export,omitempty" yaml:"Export,omitempty"`
+	Import                     bool                             `json:"Import,omitempty" yaml:"Import,omitempty"`
+	Global                     bool                             `json:"Global,omitempty" yaml:"Global,omitempty"`
+	PolicyType                 string                           `json:"PolicyType,omitempty" yaml:"PolicyType,omitempty"`
+	Extensions                 interface{}                      `json:"-" yaml:"-"`
 }
 
 type PrefixPolicyListInfo struct {
@@ -129,6 +130,12 @@ func (db *PolicyEngineDB) UpdateProtocolPolicyTable(protoType string, name strin
 func (db *PolicyEngineDB) UpdatePrefixPolicyTableWithPrefix(ipAddr string, name string, op int, lowRange int, highRange int) {
 	db.Logger.Info(fmt.Sprintln("updatePrefixPolicyTableWithPrefix ", ipAddr))
 	var i int
+	_, ipNet, err := net.ParseCIDR(ipAddr)
+	if err != nil {
+		db.Logger.Err(fmt.Sprintln("ipPrefix invalid "))
+		return
+	}
+	prefixPolicyListDB := db.prefixPolicyListDB(GetPrefixAddressFamily(ipNet))
 	ipPrefix, err := netUtils.GetNetworkPrefixFromCIDR(ipAddr)
 	if err != nil {
 		db.Logger.Err(fmt.Sprintln("ipPrefix invalid "))
@@ -136,7 +143,7 @@ func (db *PolicyEngineDB) UpdatePrefixPolicyTableWithPrefix(ipAddr string, name
 	}
 	var policyList []PrefixPolicyListInfo
 	var prefixPolicyListInfo PrefixPolicyListInfo
-	policyListItem := db.PrefixPolicyListDB.Get(ipPrefix)
+	policyListItem := prefixPolicyListDB.Get(ipPrefix)
 	if policyListItem != nil && reflect.TypeOf(policyListItem).Kind() != reflect.Slice {
 		db.Logger.Err(fmt.Sprintln("Incorrect data type for this prefix "))
 		return
@@ -173,7 +180,7 @@ func (db *PolicyEngineDB) UpdatePrefixPolicyTableWithPrefix(ipAddr string, name
 			policyList = append(policyList[:i], policyList[i+1:]...)
 		}
 	}
-	db.PrefixPolicyListDB.Set(ipPrefix, policyList)
+	prefixPolicyListDB.Set(ipPrefix, policyList)
 }
 func (db *PolicyEngineDB) UpdatePrefixPolicyTableWithMaskRange(ipAddr string, masklength string, name string, op int) {
 	db.Logger.Info(fmt.Sprintln("updatePrefixPolicyTableWithMaskRange"))
@@ -208,6 +215,14 @@ func (db *PolicyEngineDB) UpdatePrefixPolicyTableWithMaskRange(ipAddr string, ma
 }
 func (db *PolicyEngineDB) UpdatePrefixPolicyTableWithPrefixSet(prefixSet string, name string, op int) {
 	db.Logger.Info(fmt.Sprintln("updatePrefixPolicyTableWithPrefixSet"))
+	item := db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(prefixSet))
+	if item == nil {
+		db.Logger.Err(fmt.Sprintln("prefix set ", prefixSet, " not defined"))
+		return
+	}
+	for _, prefixInfo := range item.(PolicyPrefixSet).PrefixInfoList {
+		db.UpdatePrefixPolicyTableWithPrefix(prefixInfo.Prefix.IpPrefix, name, op, prefixInfo.LowRange, prefixInfo.HighRange)
+	}
 }
 func (db *PolicyEngineDB) UpdatePrefixPolicyTable(conditionInfo interface{}, name string, op int) {
 	condition := conditionInfo.(MatchPrefixConditionInfo)
@@ -232,6 +247,8 @@ func (db *PolicyEngineDB) UpdatePrefixPolicyTable(conditionInfo interface{}, nam
 func (db *PolicyEngineDB) UpdateStatements(policy Policy, stmt PolicyStmt, op int) (err error) {
 	db.Logger.Info(fmt.Sprintln("UpdateStatements for stmt ", stmt.Name))
 	var i int
+	oldStmt := stmt
+	oldStmt.PolicyList = copyNameList(stmt.PolicyList)
 	if stmt.PolicyList == nil {
 		if op == del {
 			db.Logger.Info(fmt.Sprintln("stmt.PolicyList nil"))
@@ -256,6 +273,8 @@ func (db *PolicyEngineDB) UpdateStatements(policy Policy, stmt PolicyStmt, op in
 		}
 	}
 	db.PolicyStmtDB.Set(patriciaDB.Prefix(stmt.Name), stmt)
+	db.updatePolicyDependencies(PolicyObjectTypeStmt, PolicyNotifyUpdate, stmt.Name, stmt)
+	db.notifyPolicySubscribers(PolicyObjectTypeStmt, PolicyNotifyUpdate, stmt.Name, oldStmt, stmt)
 	return err
 }
 
@@ -300,6 +319,8 @@ func (db *PolicyEngineDB) UpdateConditions(policyStmt PolicyStmt, conditionName
 		return err
 	}
 	condition := conditionItem.(PolicyCondition)
+	oldCondition := condition
+	oldCondition.PolicyStmtList = copyNameList(condition.PolicyStmtList)
 	switch condition.ConditionType {
 	case policyCommonDefs.PolicyConditionTypeProtocolMatch:
 		db.Logger.Info(fmt.Sprintln("PolicyConditionTypeProtocolMatch"))
@@ -335,12 +356,16 @@ func (db *PolicyEngineDB) UpdateConditions(policyStmt PolicyStmt, conditionName
 		}
 	}
 	db.PolicyConditionsDB.Set(patriciaDB.Prefix(conditionName), condition)
+	db.updatePolicyDependencies(PolicyObjectTypeCondition, PolicyNotifyUpdate, conditionName, condition)
+	db.notifyPolicySubscribers(PolicyObjectTypeCondition, PolicyNotifyUpdate, conditionName, oldCondition, condition)
 	return err
 }
 
 func (db *PolicyEngineDB) UpdateActions(policyStmt PolicyStmt, action PolicyAction, op int) (err error) {
 	db.Logger.Info(fmt.Sprintln("updateActions for action ", action.Name))
 	var i int
+	oldAction := action
+	oldAction.PolicyStmtList = copyNameList(action.PolicyStmtList)
 	if action.PolicyStmtList == nil {
 		if op == del {
 			db.Logger.Info(fmt.Sprintln("action.PolicyStmtList empty"))
@@ -367,6 +392,8 @@ func (db *PolicyEngineDB) UpdateActions(policyStmt PolicyStmt, action PolicyActi
 	}
 
 	db.PolicyActionsDB.Set(patriciaDB.Prefix(action.Name), action)
+	db.updatePolicyDependencies(PolicyObjectTypeAction, PolicyNotifyUpdate, action.Name, action)
+	db.notifyPolicySubscribers(PolicyObjectTypeAction, PolicyNotifyUpdate, action.Name, oldAction, action)
 	return err
 }
 func (db *PolicyEngineDB) ValidatePolicyStatementCreate(cfg PolicyStmtConfig) (err error) {
@@ -387,7 +414,7 @@ func (db *PolicyEngineDB) ValidatePolicyStatementCreate(cfg PolicyStmtConfig) (e
 		err = errors.New("Cannot have more than 1 action in a policy")
 		return err
 	}
-	if cfg.Actions[0] != "permit" && cfg.Actions[0] != "deny" {
+	if len(cfg.Actions) == 1 && cfg.Actions[0] != "permit" && cfg.Actions[0] != "deny" {
 		db.Logger.Err("Invalid stmt actions, can only be one of permit/deny")
 		return errors.New("Invalid stmt actions")
 	}
@@ -411,7 +438,7 @@ func (db *PolicyEngineDB) CreatePolicyStatement(cfg PolicyStmtConfig) (err error
 		newPolicyStmt.Name = cfg.Name
 		newPolicyStmt.MatchConditions = cfg.MatchConditions
 		if len(cfg.Conditions) > 0 {
-			db.Logger.Info(fmt.Sprintln("Policy Statement has %d ", len(cfg.Conditions), " number of conditions"))
+			db.Logger.Info(fmt.Sprintln("Policy Statement has ", len(cfg.Conditions), " number of conditions"))
 			newPolicyStmt.Conditions = make([]string, 0)
 			for i = 0; i < len(cfg.Conditions); i++ {
 				newPolicyStmt.Conditions = append(newPolicyStmt.Conditions, cfg.Conditions[i])
@@ -424,7 +451,7 @@ func (db *PolicyEngineDB) CreatePolicyStatement(cfg PolicyStmtConfig) (err error
 			}
 		}
 		if len(cfg.Actions) > 0 {
-			db.Logger.Info(fmt.Sprintln("Policy Statement has %d ", len(cfg.Actions), " number of actions"))
+			db.Logger.Info(fmt.Sprintln("Policy Statement has ", len(cfg.Actions), " number of actions"))
 			if len(cfg.Actions) > 1 {
 				db.Logger.Err(fmt.Sprintln("Cannot have more than 1 action in a policy"))
 				err = errors.New("Cannot have more than 1 action in a policy")
@@ -440,6 +467,9 @@ func (db *PolicyEngineDB) CreatePolicyStatement(cfg PolicyStmtConfig) (err error
 			return err
 		}
 		db.LocalPolicyStmtDB.updateLocalDB(patriciaDB.Prefix(cfg.Name), add)
+		db.invalidatePolicyIndex()
+		db.updatePolicyDependencies(PolicyObjectTypeStmt, PolicyNotifyCreate, cfg.Name, newPolicyStmt)
+		db.notifyPolicySubscribers(PolicyObjectTypeStmt, PolicyNotifyCreate, cfg.Name, nil, newPolicyStmt)
 	} else {
 		db.Logger.Err(fmt.Sprintln("Duplicate Policy definition name"))
 		err = errors.New("Duplicate policy definition")
@@ -455,16 +485,7 @@ func (db *PolicyEngineDB) ValidatePolicyStatementDelete(cfg PolicyStmtConfig) (e
 		err = errors.New("No policy statement with this name found")
 		return err
 	}
-	policyStmtInfoGet := db.PolicyStmtDB.Get(patriciaDB.Prefix(cfg.Name))
-	if policyStmtInfoGet != nil {
-		policyStmtInfo := policyStmtInfoGet.(PolicyStmt)
-		if len(policyStmtInfo.PolicyList) != 0 {
-			db.Logger.Err(fmt.Sprintln("This policy stmt is being used by one or more policies. Delete the policies before deleting the stmt"))
-			err = errors.New("This policy stmt is being used by one or more policies. Delete the policies before deleting the stmt")
-			return err
-		}
-	}
-	return nil
+	return db.ValidatePolicyObjectDelete(PolicyObjectTypeStmt, cfg.Name)
 }
 func (db *PolicyEngineDB) DeletePolicyStatement(cfg PolicyStmtConfig) (err error) {
 	db.Logger.Info(fmt.Sprintln("DeletePolicyStatement for name ", cfg.Name))
@@ -476,9 +497,8 @@ func (db *PolicyEngineDB) DeletePolicyStatement(cfg PolicyStmtConfig) (err error
 	policyStmtInfoGet := db.PolicyStmtDB.Get(patriciaDB.Prefix(cfg.Name))
 	if policyStmtInfoGet != nil {
 		policyStmtInfo := policyStmtInfoGet.(PolicyStmt)
-		if len(policyStmtInfo.PolicyList) != 0 {
-			db.Logger.Err(fmt.Sprintln("This policy stmt is being used by one or more policies. Delete the policies before deleting the stmt"))
-			err = errors.New("This policy stmt is being used by one or more policies. Delete the policies before deleting the stmt")
+		err = db.ValidatePolicyObjectDelete(PolicyObjectTypeStmt, cfg.Name)
+		if err != nil {
 			return err
 		}
 		//invalidate localPolicyStmt
@@ -495,6 +515,10 @@ func (db *PolicyEngineDB) DeletePolicyStatement(cfg PolicyStmtConfig) (err error
 			return err
 		}
 		db.LocalPolicyStmtDB.updateLocalDB(patriciaDB.Prefix(cfg.Name), del)
+		db.invalidatePolicyIndex()
+		db.deletePolicyCounters(db.PolicyStmtStatsDB, cfg.Name)
+		db.updatePolicyDependencies(PolicyObjectTypeStmt, PolicyNotifyDelete, cfg.Name, nil)
+		db.notifyPolicySubscribers(PolicyObjectTypeStmt, PolicyNotifyDelete, cfg.Name, policyStmtInfo, nil)
 		//update other tables
 		if len(policyStmtInfo.Conditions) > 0 {
 			for i := 0; i < len(policyStmtInfo.Conditions); i++ {
@@ -546,7 +570,10 @@ func (db *PolicyEngineDB) UpdateApplyPolicy(info ApplyPolicyInfo, apply bool) {
 	if HasActionInfo(db.ApplyPolicyMap[applyPolicy.Name], action) {
 		//for now do nothing, need to handle on update of conditions/stmt/policy
 	} else {
-		db.ApplyPolicyMap[applyPolicy.Name] = append(db.ApplyPolicyMap[applyPolicy.Name], ApplyPolicyInfo{applyPolicy, action, conditions})
+		newInfo := ApplyPolicyInfo{applyPolicy, action, conditions}
+		db.ApplyPolicyMap[applyPolicy.Name] = append(db.ApplyPolicyMap[applyPolicy.Name], newInfo)
+		db.updatePolicyDependencies(PolicyObjectTypeApplyPolicy, PolicyNotifyCreate, applyPolicy.Name, newInfo)
+		db.notifyPolicySubscribers(PolicyObjectTypeApplyPolicy, PolicyNotifyCreate, applyPolicy.Name, nil, newInfo)
 	}
 	if apply {
 		db.PolicyEngineTraverseAndApplyPolicy(info)
@@ -572,7 +599,7 @@ func (db *PolicyEngineDB) ValidatePolicyDefinitionCreate(cfg PolicyDefinitionCon
 			return err
 		}
 		stmt := Item.(PolicyStmt)
-		for cds := 0; cds < len(stmt.Actions); cds++ {
+		for cds := 0; cds < len(stmt.Conditions); cds++ {
 			if !db.ConditionCheckForPolicyType(stmt.Conditions[cds], cfg.PolicyType) {
 				db.Logger.Err(fmt.Sprintln("Trying to add statement with incompatible condition ", stmt.Conditions[cds], " to this policy of policyType: ", cfg.PolicyType))
 				return errors.New("Incompatible condition type ")
@@ -592,7 +619,7 @@ func (db *PolicyEngineDB) CreatePolicyDefinition(cfg PolicyDefinitionConfig) (er
 		newPolicy.Name = cfg.Name
 		newPolicy.Precedence = cfg.Precedence
 		newPolicy.MatchType = cfg.MatchType
-		db.Logger.Info(fmt.Sprintln("Policy has %d ", len(cfg.PolicyDefinitionStatements), " number of statements"))
+		db.Logger.Info(fmt.Sprintln("Policy has ", len(cfg.PolicyDefinitionStatements), " number of statements"))
 		newPolicy.PolicyStmtPrecedenceMap = make(map[int]string)
 		for i = 0; i < len(cfg.PolicyDefinitionStatements); i++ {
 			var stmt PolicyStmt
@@ -641,6 +668,7 @@ func (db *PolicyEngineDB) CreatePolicyDefinition(cfg PolicyDefinitionConfig) (er
 			}
 		}
 		newPolicy.LocalDBSliceIdx = int8(len(*db.LocalPolicyDB))
+		newPolicy.PolicyType = cfg.PolicyType
 		newPolicy.Extensions = cfg.Extensions
 		if ok := db.PolicyDB.Insert(patriciaDB.Prefix(cfg.Name), newPolicy); ok != true {
 			db.Logger.Info(fmt.Sprintln(" return value not ok"))
@@ -648,6 +676,8 @@ func (db *PolicyEngineDB) CreatePolicyDefinition(cfg PolicyDefinitionConfig) (er
 			return err
 		}
 		db.LocalPolicyDB.updateLocalDB(patriciaDB.Prefix(cfg.Name), add)
+		db.updatePolicyDependencies(PolicyObjectTypeDefinition, PolicyNotifyCreate, cfg.Name, newPolicy)
+		db.notifyPolicySubscribers(PolicyObjectTypeDefinition, PolicyNotifyCreate, cfg.Name, nil, newPolicy)
 	} else {
 		db.Logger.Err(fmt.Sprintln("Duplicate Policy definition name"))
 		err = errors.New("Duplicate policy definition")
@@ -664,12 +694,8 @@ func (db *PolicyEngineDB) ValidatePolicyDefinitionDelete(cfg PolicyDefinitionCon
 		return err
 	}
 	policy := policyItem.(Policy)
-	if db.ApplyPolicyMap[policy.Name] != nil {
-		db.Logger.Err(" Policy being applied, cannot delete it")
-		err = errors.New(fmt.Sprintln("Policy being used, cannot delete"))
-		return err
-	}
-	return err
+	//applied policies are reversed by the delete, so the apply bindings do not block it
+	return db.validatePolicyObjectDelete(PolicyObjectTypeDefinition, policy.Name, PolicyObjectTypeApplyPolicy)
 }
 func (db *PolicyEngineDB) DeletePolicyDefinition(cfg PolicyDefinitionConfig) (err error) {
 	db.Logger.Info(fmt.Sprintln("DeletePolicyDefinition for name ", cfg.Name))
@@ -678,6 +704,10 @@ func (db *PolicyEngineDB) DeletePolicyDefinition(cfg PolicyDefinitionConfig) (er
 		err = errors.New("No policy with this name found")
 		return err
 	}
+	err = db.ValidatePolicyDefinitionDelete(cfg)
+	if err != nil {
+		return err
+	}
 	policyInfoGet := db.PolicyDB.Get(patriciaDB.Prefix(cfg.Name))
 	if policyInfoGet != nil {
 		policyInfo := policyInfoGet.(Policy)
@@ -689,6 +719,17 @@ func (db *PolicyEngineDB) DeletePolicyDefinition(cfg PolicyDefinitionConfig) (er
 			return err
 		}
 		db.LocalPolicyDB.updateLocalDB(patriciaDB.Prefix(cfg.Name), del)
+		db.deletePolicyCounters(db.PolicyStatsDB, cfg.Name)
+		//the policy has been reversed, drop its apply bindings
+		if applyList, ok := db.ApplyPolicyMap[cfg.Name]; ok {
+			delete(db.ApplyPolicyMap, cfg.Name)
+			db.updatePolicyDependencies(PolicyObjectTypeApplyPolicy, PolicyNotifyDelete, cfg.Name, nil)
+			for _, info := range applyList {
+				db.notifyPolicySubscribers(PolicyObjectTypeApplyPolicy, PolicyNotifyDelete, cfg.Name, info, nil)
+			}
+		}
+		db.updatePolicyDependencies(PolicyObjectTypeDefinition, PolicyNotifyDelete, cfg.Name, nil)
+		db.notifyPolicySubscribers(PolicyObjectTypeDefinition, PolicyNotifyDelete, cfg.Name, policyInfo, nil)
 		var stmt PolicyStmt
 		for _, v := range policyInfo.PolicyStmtPrecedenceMap {
 			err = db.UpdateGlobalStatementTable(policyInfo.Name, v, del)
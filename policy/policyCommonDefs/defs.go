@@ -38,3 +38,8 @@ const (
 	PolicyPath_Export                         = 2
 	PolicyPath_All                            = 3
 )
+
+const (
+	PolicyAddressFamilyIPv4 = 1
+	PolicyAddressFamilyIPv6 = 2
+)
@@ -27,6 +27,7 @@ package policy
 import (
 	"errors"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"utils/netUtils"
@@ -35,30 +36,31 @@ import (
 )
 
 type PolicyPrefix struct {
-	IpPrefix        string //CIDR eg: 1.1.1.2/24
-	MasklengthRange string //exact or a specific range 21..24
+	IpPrefix        string `json:"IpPrefix,omitempty" yaml:"IpPrefix,omitempty"`               //CIDR eg: 1.1.1.2/24
+	MasklengthRange string `json:"MasklengthRange,omitempty" yaml:"MasklengthRange,omitempty"` //exact or a specific range 21..24
 }
 type PolicyDstIpMatchPrefixSetCondition struct {
-	PrefixSet string
-	Prefix    PolicyPrefix
+	PrefixSet string       `json:"PrefixSet,omitempty" yaml:"PrefixSet,omitempty"`
+	Prefix    PolicyPrefix `json:"Prefix,omitempty" yaml:"Prefix,omitempty"`
 }
 
 type MatchPrefixConditionInfo struct {
-	UsePrefixSet bool
-	PrefixSet    string
-	DstIpMatch   bool
-	SrcIpMatch   bool
-	Prefix       PolicyPrefix
-	IpPrefix     patriciaDB.Prefix //network prefix
-	LowRange     int
-	HighRange    int
+	UsePrefixSet  bool
+	PrefixSet     string
+	DstIpMatch    bool
+	SrcIpMatch    bool
+	Prefix        PolicyPrefix
+	IpPrefix      patriciaDB.Prefix //network prefix
+	AddressFamily int               //policyCommonDefs.PolicyAddressFamilyIPv4/IPv6
+	LowRange      int
+	HighRange     int
 }
 type PolicyConditionConfig struct {
-	Name                          string
-	ConditionType                 string
-	MatchProtocolConditionInfo    string
-	MatchDstIpPrefixConditionInfo PolicyDstIpMatchPrefixSetCondition
-	MatchNeighborConditionInfo    string
+	Name                          string                             `json:"Name" yaml:"Name"`
+	ConditionType                 string                             `json:"ConditionType,omitempty" yaml:"ConditionType,omitempty"`
+	MatchProtocolConditionInfo    string                             `json:"MatchProtocolConditionInfo,omitempty" yaml:"MatchProtocolConditionInfo,omitempty"`
+	MatchDstIpPrefixConditionInfo PolicyDstIpMatchPrefixSetCondition `json:"MatchDstIpPrefixConditionInfo,omitempty" yaml:"MatchDstIpPrefixConditionInfo,omitempty"`
+	MatchNeighborConditionInfo    string                             `json:"MatchNeighborConditionInfo,omitempty" yaml:"MatchNeighborConditionInfo,omitempty"`
 	//MatchNeighborConditionInfo   PolicyMatchNeighborSetCondition
 	//MatchTagConditionInfo   PolicyMatchTagSetCondition
 }
@@ -72,6 +74,51 @@ type PolicyCondition struct {
 	LocalDBSliceIdx      int
 }
 
+//builds the match info for a single prefix with an "exact" or "low-high" masklength range
+func (db *PolicyEngineDB) GetMatchPrefixConditionInfo(prefix PolicyPrefix) (conditionInfo MatchPrefixConditionInfo, err error) {
+	conditionInfo.HighRange = -1
+	conditionInfo.LowRange = -1
+	conditionInfo.UsePrefixSet = false
+	conditionInfo.Prefix.IpPrefix = prefix.IpPrefix
+	conditionInfo.Prefix.MasklengthRange = prefix.MasklengthRange
+	_, ipNet, err := net.ParseCIDR(conditionInfo.Prefix.IpPrefix)
+	if err != nil {
+		db.Logger.Err(fmt.Sprintln("ipPrefix invalid "))
+		return conditionInfo, errors.New("ipPrefix invalid")
+	}
+	conditionInfo.AddressFamily = GetPrefixAddressFamily(ipNet)
+	conditionInfo.IpPrefix, err = netUtils.GetNetworkPrefixFromCIDR(conditionInfo.Prefix.IpPrefix)
+	if err != nil {
+		db.Logger.Err(fmt.Sprintln("ipPrefix invalid "))
+		return conditionInfo, errors.New("ipPrefix invalid")
+	}
+	if prefix.MasklengthRange == "exact" {
+		return conditionInfo, nil
+	}
+	maskList := strings.Split(conditionInfo.Prefix.MasklengthRange, "-")
+	if len(maskList) != 2 {
+		db.Logger.Err(fmt.Sprintln("Invalid masklength range"))
+		return conditionInfo, errors.New("Invalid masklength range")
+	}
+	conditionInfo.LowRange, err = strconv.Atoi(maskList[0])
+	if err != nil {
+		db.Logger.Err(fmt.Sprintln("lowRange mask not valid"))
+		return conditionInfo, errors.New("lowRange mask not valid")
+	}
+	conditionInfo.HighRange, err = strconv.Atoi(maskList[1])
+	if err != nil {
+		db.Logger.Err(fmt.Sprintln("highRange mask not valid"))
+		return conditionInfo, errors.New("highRange mask not valid")
+	}
+	_, maxMaskLen := ipNet.Mask.Size()
+	if conditionInfo.LowRange < 0 || conditionInfo.LowRange > conditionInfo.HighRange || conditionInfo.HighRange > maxMaskLen {
+		db.Logger.Err(fmt.Sprintln("Invalid masklength range ", conditionInfo.Prefix.MasklengthRange, " for ", conditionInfo.Prefix.IpPrefix))
+		return conditionInfo, errors.New("Invalid masklength range")
+	}
+	db.Logger.Info(fmt.Sprintln("lowRange = ", conditionInfo.LowRange, " highrange = ", conditionInfo.HighRange))
+	return conditionInfo, nil
+}
+
 func (db *PolicyEngineDB) CreatePolicyDstIpMatchPrefixSetCondition(inCfg PolicyConditionConfig) (val bool, err error) {
 	db.Logger.Info(fmt.Sprintln("CreatePolicyDstIpMatchPrefixSetCondition"))
 	cfg := inCfg.MatchDstIpPrefixConditionInfo
@@ -91,34 +138,16 @@ func (db *PolicyEngineDB) CreatePolicyDstIpMatchPrefixSetCondition(inCfg PolicyC
 	}
 	if len(cfg.Prefix.IpPrefix) != 0 {
 		conditionGetBulkInfo = "match destination Prefix " + cfg.Prefix.IpPrefix + "MasklengthRange " + cfg.Prefix.MasklengthRange
-		conditionInfo.UsePrefixSet = false
-		conditionInfo.Prefix.IpPrefix = cfg.Prefix.IpPrefix
-		conditionInfo.Prefix.MasklengthRange = cfg.Prefix.MasklengthRange
-		conditionInfo.IpPrefix, err = netUtils.GetNetworkPrefixFromCIDR(conditionInfo.Prefix.IpPrefix)
+		conditionInfo, err = db.GetMatchPrefixConditionInfo(cfg.Prefix)
 		if err != nil {
-			db.Logger.Err(fmt.Sprintln("ipPrefix invalid "))
-			return
-		}
-		if cfg.Prefix.MasklengthRange == "exact" {
-		} else {
-			maskList := strings.Split(conditionInfo.Prefix.MasklengthRange, "-")
-			if len(maskList) != 2 {
-				db.Logger.Err(fmt.Sprintln("Invalid masklength range"))
-				return
-			}
-			conditionInfo.LowRange, err = strconv.Atoi(maskList[0])
-			if err != nil {
-				db.Logger.Err(fmt.Sprintln("lowRange mask not valid"))
-				return
-			}
-			conditionInfo.HighRange, err = strconv.Atoi(maskList[1])
-			if err != nil {
-				db.Logger.Err(fmt.Sprintln("highRange mask not valid"))
-				return
-			}
-			db.Logger.Info(fmt.Sprintln("lowRange = ", conditionInfo.LowRange, " highrange = ", conditionInfo.HighRange))
+			return false, err
 		}
 	} else if len(cfg.PrefixSet) != 0 {
+		if db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(cfg.PrefixSet)) == nil {
+			db.Logger.Err(fmt.Sprintln("prefix set ", cfg.PrefixSet, " not defined"))
+			err = errors.New("prefix set not defined")
+			return false, err
+		}
 		conditionInfo.UsePrefixSet = true
 		conditionInfo.PrefixSet = cfg.PrefixSet
 		conditionGetBulkInfo = "match destination Prefix " + cfg.PrefixSet
@@ -135,6 +164,10 @@ func (db *PolicyEngineDB) CreatePolicyDstIpMatchPrefixSetCondition(inCfg PolicyC
 			return false, err
 		}
 		db.LocalPolicyConditionsDB.updateLocalDB(patriciaDB.Prefix(inCfg.Name), add)
+		db.invalidatePolicyIndex()
+		if conditionInfo.UsePrefixSet {
+			db.updatePrefixSetConditions(conditionInfo.PrefixSet, inCfg.Name, add)
+		}
 	} else {
 		db.Logger.Err(fmt.Sprintln("Duplicate Condition name"))
 		err = errors.New("Duplicate policy condition definition")
@@ -158,6 +191,7 @@ func (db *PolicyEngineDB) CreatePolicyMatchProtocolCondition(cfg PolicyCondition
 			return false, err
 		}
 		db.LocalPolicyConditionsDB.updateLocalDB(patriciaDB.Prefix(cfg.Name), add)
+		db.invalidatePolicyIndex()
 	} else {
 		db.Logger.Err(fmt.Sprintln("Duplicate Condition name"))
 		err = errors.New("Duplicate policy condition definition")
@@ -183,6 +217,7 @@ func (db *PolicyEngineDB) CreatePolicyMatchNeighborCondition(cfg PolicyCondition
 			return false, err
 		}
 		db.LocalPolicyConditionsDB.updateLocalDB(patriciaDB.Prefix(cfg.Name), add)
+		db.invalidatePolicyIndex()
 	} else {
 		db.Logger.Err(fmt.Sprintln("Duplicate Condition name"))
 		err = errors.New("Duplicate policy condition definition")
@@ -215,30 +250,15 @@ func (db *PolicyEngineDB) ValidateConditionConfigCreate(inCfg PolicyConditionCon
 			return err
 		}
 		if len(cfg.Prefix.IpPrefix) != 0 {
-			_, err = netUtils.GetNetworkPrefixFromCIDR(cfg.Prefix.IpPrefix)
+			_, err = db.GetMatchPrefixConditionInfo(cfg.Prefix)
 			if err != nil {
-				db.Logger.Err(fmt.Sprintln("ipPrefix invalid "))
-				return errors.New("ipPrefix invalid")
-			}
-			if cfg.Prefix.MasklengthRange == "exact" {
-			} else {
-				maskList := strings.Split(cfg.Prefix.MasklengthRange, "-")
-				if len(maskList) != 2 {
-					db.Logger.Err(fmt.Sprintln("Invalid masklength range"))
-					return errors.New("Invalid masklength range")
-				}
-				_, err = strconv.Atoi(maskList[0])
-				if err != nil {
-					db.Logger.Err(fmt.Sprintln("lowRange mask not valid"))
-					return errors.New("lowRange mask not valid")
-				}
-				_, err = strconv.Atoi(maskList[1])
-				if err != nil {
-					db.Logger.Err(fmt.Sprintln("highRange mask not valid"))
-					return errors.New("highRange mask not valid")
-				}
+				return err
 			}
 		}
+		if len(cfg.PrefixSet) != 0 && db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(cfg.PrefixSet)) == nil {
+			db.Logger.Err(fmt.Sprintln("prefix set ", cfg.PrefixSet, " not defined"))
+			return errors.New("prefix set not defined")
+		}
 	case "MatchNeighbor":
 		break
 	default:
@@ -266,6 +286,12 @@ func (db *PolicyEngineDB) CreatePolicyCondition(cfg PolicyConditionConfig) (val
 		err = errors.New("Unknown condition type")
 		return false, err
 	}
+	if err == nil {
+		if conditionItem := db.PolicyConditionsDB.Get(patriciaDB.Prefix(cfg.Name)); conditionItem != nil {
+			db.updatePolicyDependencies(PolicyObjectTypeCondition, PolicyNotifyCreate, cfg.Name, conditionItem)
+			db.notifyPolicySubscribers(PolicyObjectTypeCondition, PolicyNotifyCreate, cfg.Name, nil, conditionItem)
+		}
+	}
 	return val, err
 }
 func (db *PolicyEngineDB) ValidateConditionConfigDelete(cfg PolicyConditionConfig) (err error) {
@@ -275,10 +301,8 @@ func (db *PolicyEngineDB) ValidateConditionConfigDelete(cfg PolicyConditionConfi
 		err = errors.New("Condition not found")
 		return err
 	}
-	condition := conditionItem.(PolicyCondition)
-	if len(condition.PolicyStmtList) != 0 {
-		db.Logger.Err(fmt.Sprintln("This condition is currently being used by a policy statement. Try deleting the stmt before deleting the condition"))
-		err = errors.New("This condition is currently being used by a policy statement. Try deleting the stmt before deleting the condition")
+	err = db.ValidatePolicyObjectDelete(PolicyObjectTypeCondition, cfg.Name)
+	if err != nil {
 		return err
 	}
 	return nil
@@ -292,15 +316,21 @@ func (db *PolicyEngineDB) DeletePolicyCondition(cfg PolicyConditionConfig) (val
 		return false, err
 	}
 	condition := conditionItem.(PolicyCondition)
-	if len(condition.PolicyStmtList) != 0 {
-		db.Logger.Err(fmt.Sprintln("This condition is currently being used by a policy statement. Try deleting the stmt before deleting the condition"))
-		err = errors.New("This condition is currently being used by a policy statement. Try deleting the stmt before deleting the condition")
+	err = db.ValidatePolicyObjectDelete(PolicyObjectTypeCondition, cfg.Name)
+	if err != nil {
 		return false, err
 	}
 	deleted := db.PolicyConditionsDB.Delete(patriciaDB.Prefix(cfg.Name))
 	if deleted {
 		db.Logger.Info(fmt.Sprintln("Found and deleted condition ", cfg.Name))
 		db.LocalPolicyConditionsDB.updateLocalDB(patriciaDB.Prefix(cfg.Name), del)
+		db.invalidatePolicyIndex()
+		db.deletePolicyCounters(db.PolicyConditionStatsDB, cfg.Name)
+		if conditionInfo, ok := condition.ConditionInfo.(MatchPrefixConditionInfo); ok && conditionInfo.UsePrefixSet {
+			db.updatePrefixSetConditions(conditionInfo.PrefixSet, cfg.Name, del)
+		}
+		db.updatePolicyDependencies(PolicyObjectTypeCondition, PolicyNotifyDelete, cfg.Name, nil)
+		db.notifyPolicySubscribers(PolicyObjectTypeCondition, PolicyNotifyDelete, cfg.Name, condition, nil)
 	}
 	return true, err
 }
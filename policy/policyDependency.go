@@ -0,0 +1,189 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// policyDependency.go
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"utils/patriciaDB"
+)
+
+//node of the dependency graph. Apply bindings are named after the applied policy
+type PolicyObjectRef struct {
+	ObjectType int
+	Name       string
+}
+
+func (ref PolicyObjectRef) String() string {
+	return PolicyObjectTypeName(ref.ObjectType) + " " + ref.Name
+}
+
+func PolicyObjectTypeName(objectType int) string {
+	switch objectType {
+	case PolicyObjectTypePrefixSet:
+		return "prefix set"
+	case PolicyObjectTypeCondition:
+		return "condition"
+	case PolicyObjectTypeAction:
+		return "action"
+	case PolicyObjectTypeStmt:
+		return "policy statement"
+	case PolicyObjectTypeDefinition:
+		return "policy"
+	case PolicyObjectTypeApplyPolicy:
+		return "apply policy"
+	}
+	return "unknown object"
+}
+
+//error returned when deleting an object that other objects still use
+type PolicyObjectInUseError struct {
+	Object     PolicyObjectRef
+	Dependants []PolicyObjectRef
+}
+
+func (e *PolicyObjectInUseError) Error() string {
+	dependants := make([]string, 0, len(e.Dependants))
+	for _, ref := range e.Dependants {
+		dependants = append(dependants, ref.String())
+	}
+	return fmt.Sprintf("%s is in use by %s", e.Object, strings.Join(dependants, ", "))
+}
+
+//objects directly used by the object with the given value
+func (db *PolicyEngineDB) policyObjectUses(objectType int, name string, value interface{}) (uses []PolicyObjectRef) {
+	switch objectType {
+	case PolicyObjectTypeCondition:
+		if conditionInfo, ok := value.(PolicyCondition).ConditionInfo.(MatchPrefixConditionInfo); ok && conditionInfo.UsePrefixSet {
+			uses = append(uses, PolicyObjectRef{PolicyObjectTypePrefixSet, conditionInfo.PrefixSet})
+		}
+	case PolicyObjectTypeStmt:
+		stmt := value.(PolicyStmt)
+		for _, condition := range stmt.Conditions {
+			uses = append(uses, PolicyObjectRef{PolicyObjectTypeCondition, condition})
+		}
+		for _, action := range stmt.Actions {
+			//permit/deny are not action objects
+			if db.PolicyActionsDB.Get(patriciaDB.Prefix(action)) != nil {
+				uses = append(uses, PolicyObjectRef{PolicyObjectTypeAction, action})
+			}
+		}
+	case PolicyObjectTypeDefinition:
+		for _, stmt := range value.(Policy).PolicyStmtPrecedenceMap {
+			uses = append(uses, PolicyObjectRef{PolicyObjectTypeStmt, stmt})
+		}
+	case PolicyObjectTypeApplyPolicy:
+		//all the bindings of the policy
+		for _, info := range db.ApplyPolicyMap[name] {
+			uses = append(uses, PolicyObjectRef{PolicyObjectTypeDefinition, info.ApplyPolicy.Name})
+			if info.Action.Name != "" && db.PolicyActionsDB.Get(patriciaDB.Prefix(info.Action.Name)) != nil {
+				uses = append(uses, PolicyObjectRef{PolicyObjectTypeAction, info.Action.Name})
+			}
+			for _, condition := range info.Conditions {
+				uses = append(uses, PolicyObjectRef{PolicyObjectTypeCondition, condition})
+			}
+		}
+	}
+	return uses
+}
+
+//called by the config APIs for every object change, before the subscribers are notified
+func (db *PolicyEngineDB) updatePolicyDependencies(objectType int, op int, name string, newValue interface{}) {
+	ref := PolicyObjectRef{objectType, name}
+	var uses []PolicyObjectRef
+	if op != PolicyNotifyDelete {
+		uses = db.policyObjectUses(objectType, name, newValue)
+	}
+	db.DependencyLock.Lock()
+	defer db.DependencyLock.Unlock()
+	for used := range db.PolicyObjectUsesDB[ref] {
+		delete(db.PolicyObjectUsedByDB[used], ref)
+		if len(db.PolicyObjectUsedByDB[used]) == 0 {
+			delete(db.PolicyObjectUsedByDB, used)
+		}
+	}
+	delete(db.PolicyObjectUsesDB, ref)
+	if len(uses) == 0 {
+		return
+	}
+	db.PolicyObjectUsesDB[ref] = make(map[PolicyObjectRef]bool)
+	for _, used := range uses {
+		db.PolicyObjectUsesDB[ref][used] = true
+		if db.PolicyObjectUsedByDB[used] == nil {
+			db.PolicyObjectUsedByDB[used] = make(map[PolicyObjectRef]bool)
+		}
+		db.PolicyObjectUsedByDB[used][ref] = true
+	}
+}
+
+func sortedPolicyObjectRefs(refs map[PolicyObjectRef]bool) []PolicyObjectRef {
+	refList := make([]PolicyObjectRef, 0, len(refs))
+	for ref := range refs {
+		refList = append(refList, ref)
+	}
+	sort.Slice(refList, func(i, j int) bool {
+		if refList[i].ObjectType != refList[j].ObjectType {
+			return refList[i].ObjectType < refList[j].ObjectType
+		}
+		return refList[i].Name < refList[j].Name
+	})
+	return refList
+}
+
+//objects which directly use the object ("who uses X")
+func (db *PolicyEngineDB) GetPolicyObjectDependants(objectType int, name string) []PolicyObjectRef {
+	db.DependencyLock.RLock()
+	defer db.DependencyLock.RUnlock()
+	return sortedPolicyObjectRefs(db.PolicyObjectUsedByDB[PolicyObjectRef{objectType, name}])
+}
+
+//objects directly used by the object ("what does X use")
+func (db *PolicyEngineDB) GetPolicyObjectDependencies(objectType int, name string) []PolicyObjectRef {
+	db.DependencyLock.RLock()
+	defer db.DependencyLock.RUnlock()
+	return sortedPolicyObjectRefs(db.PolicyObjectUsesDB[PolicyObjectRef{objectType, name}])
+}
+
+//returns a PolicyObjectInUseError listing the dependants if the object cannot be deleted
+func (db *PolicyEngineDB) ValidatePolicyObjectDelete(objectType int, name string) (err error) {
+	return db.validatePolicyObjectDelete(objectType, name, 0)
+}
+
+//as ValidatePolicyObjectDelete, dependants of the ignoredTypes mask do not block the delete
+func (db *PolicyEngineDB) validatePolicyObjectDelete(objectType int, name string, ignoredTypes int) (err error) {
+	var dependants []PolicyObjectRef
+	for _, ref := range db.GetPolicyObjectDependants(objectType, name) {
+		if ref.ObjectType&ignoredTypes == 0 {
+			dependants = append(dependants, ref)
+		}
+	}
+	if len(dependants) == 0 {
+		return nil
+	}
+	err = &PolicyObjectInUseError{Object: PolicyObjectRef{objectType, name}, Dependants: dependants}
+	db.Logger.Err(err.Error())
+	return err
+}
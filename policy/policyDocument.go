@@ -0,0 +1,502 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// policyDocument.go
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"utils/patriciaDB"
+	"utils/policy/policyCommonDefs"
+)
+
+const (
+	PolicyDocumentFormatJSON = "json"
+	PolicyDocumentFormatYAML = "yaml"
+)
+
+type PolicyApplyConfig struct {
+	ApplyPolicy string   `json:"ApplyPolicy" yaml:"ApplyPolicy"`
+	Action      string   `json:"Action" yaml:"Action"`
+	Conditions  []string `json:"Conditions,omitempty" yaml:"Conditions,omitempty"` //extra condition names
+}
+
+//complete policy configuration of a PolicyEngineDB, in the order it has to be created
+type PolicyDocument struct {
+	PrefixSets    []PolicyPrefixSetConfig  `json:"PrefixSets,omitempty" yaml:"PrefixSets,omitempty"`
+	Conditions    []PolicyConditionConfig  `json:"Conditions,omitempty" yaml:"Conditions,omitempty"`
+	Actions       []PolicyActionConfig     `json:"Actions,omitempty" yaml:"Actions,omitempty"`
+	Statements    []PolicyStmtConfig       `json:"Statements,omitempty" yaml:"Statements,omitempty"`
+	Definitions   []PolicyDefinitionConfig `json:"Definitions,omitempty" yaml:"Definitions,omitempty"`
+	ApplyPolicies []PolicyApplyConfig      `json:"ApplyPolicies,omitempty" yaml:"ApplyPolicies,omitempty"`
+}
+
+func PolicyDocumentFormatFromFileName(fileName string) (format string, err error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json":
+		format = PolicyDocumentFormatJSON
+	case ".yaml", ".yml":
+		format = PolicyDocumentFormatYAML
+	default:
+		err = errors.New(fmt.Sprintln("Cannot determine policy document format for file ", fileName))
+	}
+	return format, err
+}
+
+func ParsePolicyDocument(data []byte, format string) (doc PolicyDocument, err error) {
+	switch format {
+	case PolicyDocumentFormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&doc)
+	case PolicyDocumentFormatYAML:
+		err = yaml.UnmarshalStrict(data, &doc)
+	default:
+		err = errors.New(fmt.Sprintln("Unknown policy document format ", format))
+	}
+	return doc, err
+}
+
+func MarshalPolicyDocument(doc PolicyDocument, format string) (data []byte, err error) {
+	switch format {
+	case PolicyDocumentFormatJSON:
+		data, err = json.MarshalIndent(doc, "", "    ")
+		if err == nil {
+			data = append(data, '\n')
+		}
+	case PolicyDocumentFormatYAML:
+		data, err = yaml.Marshal(doc)
+	default:
+		err = errors.New(fmt.Sprintln("Unknown policy document format ", format))
+	}
+	return data, err
+}
+
+func (db *PolicyEngineDB) ImportPolicyDocument(data []byte, format string) (err error) {
+	doc, err := ParsePolicyDocument(data, format)
+	if err != nil {
+		db.Logger.Err(fmt.Sprintln("Error parsing policy document: ", err))
+		return err
+	}
+	return db.ApplyPolicyDocument(doc)
+}
+
+func (db *PolicyEngineDB) ImportPolicyDocumentFile(fileName string) (err error) {
+	format, err := PolicyDocumentFormatFromFileName(fileName)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		db.Logger.Err(fmt.Sprintln("Error reading policy document ", fileName, ": ", err))
+		return err
+	}
+	return db.ImportPolicyDocument(data, format)
+}
+
+func (db *PolicyEngineDB) ExportPolicyDocumentData(format string) (data []byte, err error) {
+	return MarshalPolicyDocument(db.ExportPolicyDocument(), format)
+}
+
+func (db *PolicyEngineDB) ExportPolicyDocumentFile(fileName string) (err error) {
+	format, err := PolicyDocumentFormatFromFileName(fileName)
+	if err != nil {
+		return err
+	}
+	data, err := db.ExportPolicyDocumentData(format)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0644)
+}
+
+//exports all the objects sorted by name so that the output is stable and diffable
+func (db *PolicyEngineDB) ExportPolicyDocument() (doc PolicyDocument) {
+	db.PolicyPrefixSetDB.Visit(func(prefix patriciaDB.Prefix, item patriciaDB.Item) error {
+		doc.PrefixSets = append(doc.PrefixSets, prefixSetConfig(item.(PolicyPrefixSet)))
+		return nil
+	})
+	db.PolicyConditionsDB.Visit(func(prefix patriciaDB.Prefix, item patriciaDB.Item) error {
+		doc.Conditions = append(doc.Conditions, conditionConfig(item.(PolicyCondition)))
+		return nil
+	})
+	db.PolicyActionsDB.Visit(func(prefix patriciaDB.Prefix, item patriciaDB.Item) error {
+		doc.Actions = append(doc.Actions, actionConfig(item.(PolicyAction)))
+		return nil
+	})
+	db.PolicyStmtDB.Visit(func(prefix patriciaDB.Prefix, item patriciaDB.Item) error {
+		doc.Statements = append(doc.Statements, stmtConfig(item.(PolicyStmt)))
+		return nil
+	})
+	db.PolicyDB.Visit(func(prefix patriciaDB.Prefix, item patriciaDB.Item) error {
+		doc.Definitions = append(doc.Definitions, definitionConfig(item.(Policy)))
+		return nil
+	})
+	policyNames := make([]string, 0)
+	for name := range db.ApplyPolicyMap {
+		policyNames = append(policyNames, name)
+	}
+	sort.Strings(policyNames)
+	for _, name := range policyNames {
+		for _, info := range db.ApplyPolicyMap[name] {
+			doc.ApplyPolicies = append(doc.ApplyPolicies, PolicyApplyConfig{ApplyPolicy: info.ApplyPolicy.Name, Action: info.Action.Name, Conditions: info.Conditions})
+		}
+	}
+	return doc
+}
+
+//validates the document against itself and the objects already in the DB.
+//Objects already present with an identical config are accepted and left alone.
+func (db *PolicyEngineDB) ValidatePolicyDocument(doc PolicyDocument) (err error) {
+	db.Logger.Info(fmt.Sprintln("ValidatePolicyDocument"))
+	prefixSets := make(map[string]bool)
+	for _, cfg := range doc.PrefixSets {
+		if err = checkDocumentName("prefix set", cfg.Name, prefixSets); err != nil {
+			return err
+		}
+		if item := db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(cfg.Name)); item != nil {
+			if !samePolicyConfig(prefixSetConfig(item.(PolicyPrefixSet)), cfg) {
+				return errors.New(fmt.Sprintln("prefix set ", cfg.Name, " conflicts with the existing definition"))
+			}
+			continue
+		}
+		if err = db.ValidatePolicyPrefixSetCreate(cfg); err != nil {
+			return err
+		}
+	}
+	conditions := make(map[string]bool)
+	for _, cfg := range doc.Conditions {
+		if err = checkDocumentName("condition", cfg.Name, conditions); err != nil {
+			return err
+		}
+		if item := db.PolicyConditionsDB.Get(patriciaDB.Prefix(cfg.Name)); item != nil {
+			if !samePolicyConfig(conditionConfig(item.(PolicyCondition)), cfg) {
+				return errors.New(fmt.Sprintln("condition ", cfg.Name, " conflicts with the existing definition"))
+			}
+			continue
+		}
+		if cfg.ConditionType == "MatchDstIpPrefix" && prefixSets[cfg.MatchDstIpPrefixConditionInfo.PrefixSet] {
+			//prefix set is created by this document, so it is not in the DB yet
+			if len(cfg.MatchDstIpPrefixConditionInfo.Prefix.IpPrefix) != 0 {
+				return errors.New("Cannot provide both prefix set and individual prefix")
+			}
+			continue
+		}
+		if err = db.ValidateConditionConfigCreate(cfg); err != nil {
+			return err
+		}
+	}
+	actions := make(map[string]bool)
+	for _, cfg := range doc.Actions {
+		if err = checkDocumentName("action", cfg.Name, actions); err != nil {
+			return err
+		}
+		if item := db.PolicyActionsDB.Get(patriciaDB.Prefix(cfg.Name)); item != nil {
+			if !samePolicyConfig(actionConfig(item.(PolicyAction)), cfg) {
+				return errors.New(fmt.Sprintln("action ", cfg.Name, " conflicts with the existing definition"))
+			}
+			continue
+		}
+		if _, err = PolicyActionStrToIntType(cfg.ActionType); err != nil {
+			return errors.New(fmt.Sprintln("Unknown action type ", cfg.ActionType, " for action ", cfg.Name))
+		}
+	}
+	stmts := make(map[string]bool)
+	for _, cfg := range doc.Statements {
+		if err = checkDocumentName("statement", cfg.Name, stmts); err != nil {
+			return err
+		}
+		if item := db.PolicyStmtDB.Get(patriciaDB.Prefix(cfg.Name)); item != nil {
+			if !samePolicyConfig(stmtConfig(item.(PolicyStmt)), cfg) {
+				return errors.New(fmt.Sprintln("statement ", cfg.Name, " conflicts with the existing definition"))
+			}
+			continue
+		}
+		if !validMatchConditions(cfg.MatchConditions) {
+			return errors.New(fmt.Sprintln("Invalid match conditions for statement ", cfg.Name, " - try any/all"))
+		}
+		if len(cfg.Actions) > 1 {
+			return errors.New(fmt.Sprintln("Cannot have more than 1 action in statement ", cfg.Name))
+		}
+		if len(cfg.Actions) == 1 && cfg.Actions[0] != "permit" && cfg.Actions[0] != "deny" {
+			return errors.New(fmt.Sprintln("Invalid actions for statement ", cfg.Name, ", can only be one of permit/deny"))
+		}
+		for _, condition := range cfg.Conditions {
+			if !conditions[condition] && db.PolicyConditionsDB.Get(patriciaDB.Prefix(condition)) == nil {
+				return errors.New(fmt.Sprintln("Condition ", condition, " used by statement ", cfg.Name, " not found"))
+			}
+		}
+	}
+	policies := make(map[string]bool)
+	for _, cfg := range doc.Definitions {
+		if err = checkDocumentName("policy", cfg.Name, policies); err != nil {
+			return err
+		}
+		if item := db.PolicyDB.Get(patriciaDB.Prefix(cfg.Name)); item != nil {
+			if !samePolicyConfig(definitionConfig(item.(Policy)), cfg) {
+				return errors.New(fmt.Sprintln("policy ", cfg.Name, " conflicts with the existing definition"))
+			}
+			continue
+		}
+		precedences := make(map[int]bool)
+		for _, stmt := range cfg.PolicyDefinitionStatements {
+			if precedences[stmt.Precedence] {
+				return errors.New(fmt.Sprintln("Cannot add multiple statements at the same priority level to policy ", cfg.Name))
+			}
+			precedences[stmt.Precedence] = true
+			if !stmts[stmt.Statement] && db.PolicyStmtDB.Get(patriciaDB.Prefix(stmt.Statement)) == nil {
+				return errors.New(fmt.Sprintln("Statement ", stmt.Statement, " used by policy ", cfg.Name, " not found"))
+			}
+		}
+	}
+	for _, cfg := range doc.ApplyPolicies {
+		if !policies[cfg.ApplyPolicy] && db.PolicyDB.Get(patriciaDB.Prefix(cfg.ApplyPolicy)) == nil {
+			return errors.New(fmt.Sprintln("Policy ", cfg.ApplyPolicy, " to be applied not found"))
+		}
+		if !actions[cfg.Action] && db.PolicyActionsDB.Get(patriciaDB.Prefix(cfg.Action)) == nil {
+			return errors.New(fmt.Sprintln("Action ", cfg.Action, " to apply policy ", cfg.ApplyPolicy, " with not found"))
+		}
+		for _, condition := range cfg.Conditions {
+			if !conditions[condition] && db.PolicyConditionsDB.Get(patriciaDB.Prefix(condition)) == nil {
+				return errors.New(fmt.Sprintln("Condition ", condition, " to apply policy ", cfg.ApplyPolicy, " with not found"))
+			}
+		}
+	}
+	return err
+}
+
+//creates the objects of the document which are not yet in the DB. Either the whole
+//document is applied or, on error, the objects created so far are deleted again.
+func (db *PolicyEngineDB) ApplyPolicyDocument(doc PolicyDocument) (err error) {
+	db.Logger.Info(fmt.Sprintln("ApplyPolicyDocument"))
+	err = db.ValidatePolicyDocument(doc)
+	if err != nil {
+		db.Logger.Err(fmt.Sprintln("Policy document validation failed: ", err))
+		return err
+	}
+	undoList := make([]func(), 0)
+	defer func() {
+		if err != nil {
+			db.Logger.Err(fmt.Sprintln("Error applying policy document: ", err, ", rolling back"))
+			for i := len(undoList) - 1; i >= 0; i-- {
+				undoList[i]()
+			}
+		}
+	}()
+	for _, cfg := range doc.PrefixSets {
+		if db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(cfg.Name)) != nil {
+			continue
+		}
+		if _, err = db.CreatePolicyPrefixSet(cfg); err != nil {
+			return err
+		}
+		undoCfg := cfg
+		undoList = append(undoList, func() { db.DeletePolicyPrefixSet(undoCfg) })
+	}
+	for _, cfg := range doc.Conditions {
+		if db.PolicyConditionsDB.Get(patriciaDB.Prefix(cfg.Name)) != nil {
+			continue
+		}
+		if err = db.ValidateConditionConfigCreate(cfg); err != nil {
+			return err
+		}
+		if _, err = db.CreatePolicyCondition(cfg); err != nil {
+			return err
+		}
+		undoCfg := cfg
+		undoList = append(undoList, func() { db.DeletePolicyCondition(undoCfg) })
+	}
+	for _, cfg := range doc.Actions {
+		if db.PolicyActionsDB.Get(patriciaDB.Prefix(cfg.Name)) != nil {
+			continue
+		}
+		if _, err = db.CreatePolicyAction(cfg); err != nil {
+			return err
+		}
+		undoCfg := cfg
+		undoList = append(undoList, func() { db.DeletePolicyAction(undoCfg) })
+	}
+	for _, cfg := range doc.Statements {
+		if db.PolicyStmtDB.Get(patriciaDB.Prefix(cfg.Name)) != nil {
+			continue
+		}
+		if err = db.ValidatePolicyStatementCreate(cfg); err != nil {
+			return err
+		}
+		if err = db.CreatePolicyStatement(cfg); err != nil {
+			return err
+		}
+		undoCfg := cfg
+		undoList = append(undoList, func() { db.DeletePolicyStatement(undoCfg) })
+	}
+	for _, cfg := range doc.Definitions {
+		if db.PolicyDB.Get(patriciaDB.Prefix(cfg.Name)) != nil {
+			continue
+		}
+		if err = db.ValidatePolicyDefinitionCreate(cfg); err != nil {
+			return err
+		}
+		if err = db.CreatePolicyDefinition(cfg); err != nil {
+			return err
+		}
+		undoCfg := cfg
+		undoList = append(undoList, func() { db.DeletePolicyDefinition(undoCfg) })
+	}
+	//all references were resolved during validation, applying cannot fail from here on
+	for _, cfg := range doc.ApplyPolicies {
+		policy := db.PolicyDB.Get(patriciaDB.Prefix(cfg.ApplyPolicy)).(Policy)
+		action := db.PolicyActionsDB.Get(patriciaDB.Prefix(cfg.Action)).(PolicyAction)
+		if HasActionInfo(db.ApplyPolicyMap[policy.Name], action) {
+			continue
+		}
+		db.UpdateApplyPolicy(ApplyPolicyInfo{ApplyPolicy: policy, Action: action, Conditions: cfg.Conditions}, true)
+	}
+	return err
+}
+
+func checkDocumentName(kind string, name string, names map[string]bool) error {
+	if len(name) == 0 {
+		return errors.New(fmt.Sprintln("Empty name for ", kind))
+	}
+	if names[name] {
+		return errors.New(fmt.Sprintln("Duplicate ", kind, " name ", name, " in policy document"))
+	}
+	names[name] = true
+	return nil
+}
+
+//compares two configs by their document encoding, so nil and empty lists are the same
+func samePolicyConfig(existing interface{}, cfg interface{}) bool {
+	switch c := cfg.(type) {
+	case PolicyStmtConfig:
+		c.AdminState = ""
+		cfg = c
+	case PolicyDefinitionConfig:
+		c.PolicyDefinitionStatements = sortedStmtPrecedence(c.PolicyDefinitionStatements)
+		c.Import, c.Export, c.Global = false, false, false
+		cfg = c
+	}
+	existingData, err := json.Marshal(existing)
+	if err != nil {
+		return false
+	}
+	cfgData, err := json.Marshal(cfg)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(existingData, cfgData)
+}
+
+func sortedStmtPrecedence(list []PolicyDefinitionStmtPrecedence) []PolicyDefinitionStmtPrecedence {
+	sorted := make([]PolicyDefinitionStmtPrecedence, len(list))
+	copy(sorted, list)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Precedence < sorted[j].Precedence })
+	return sorted
+}
+
+func prefixSetConfig(prefixSet PolicyPrefixSet) PolicyPrefixSetConfig {
+	return PolicyPrefixSetConfig{Name: prefixSet.Name, PrefixList: prefixSet.PrefixList}
+}
+
+func conditionConfig(condition PolicyCondition) (cfg PolicyConditionConfig) {
+	cfg.Name = condition.Name
+	switch condition.ConditionType {
+	case policyCommonDefs.PolicyConditionTypeDstIpPrefixMatch:
+		cfg.ConditionType = "MatchDstIpPrefix"
+		conditionInfo := condition.ConditionInfo.(MatchPrefixConditionInfo)
+		if conditionInfo.UsePrefixSet {
+			cfg.MatchDstIpPrefixConditionInfo.PrefixSet = conditionInfo.PrefixSet
+		} else {
+			cfg.MatchDstIpPrefixConditionInfo.Prefix = conditionInfo.Prefix
+		}
+	case policyCommonDefs.PolicyConditionTypeProtocolMatch:
+		cfg.ConditionType = "MatchProtocol"
+		cfg.MatchProtocolConditionInfo = condition.ConditionInfo.(string)
+	case policyCommonDefs.PolicyConditionTypeNeighborMatch:
+		cfg.ConditionType = "MatchNeighbor"
+		cfg.MatchNeighborConditionInfo = condition.ConditionInfo.(string)
+	}
+	return cfg
+}
+
+func actionConfig(action PolicyAction) (cfg PolicyActionConfig) {
+	cfg.Name = action.Name
+	switch action.ActionType {
+	case policyCommonDefs.PolicyActionTypeRouteDisposition, policyCommonDefs.PolicyActionTypeRIBIn, policyCommonDefs.PolicyActionTypeRIBOut:
+		cfg.ActionType = map[int]string{policyCommonDefs.PolicyActionTypeRouteDisposition: "RouteDisposition",
+			policyCommonDefs.PolicyActionTypeRIBIn: "RIBIn", policyCommonDefs.PolicyActionTypeRIBOut: "RIBOut"}[action.ActionType]
+		if action.ActionInfo.(string) == "permit" {
+			cfg.Accept = true
+		} else {
+			cfg.Reject = true
+		}
+	case policyCommonDefs.PolicyActionTypeRouteRedistribute:
+		cfg.ActionType = "Redistribution"
+		redistributeActionInfo := action.ActionInfo.(RedistributeActionInfo)
+		if redistributeActionInfo.Redistribute {
+			cfg.RedistributeAction = "Allow"
+		} else {
+			cfg.RedistributeAction = "Block"
+		}
+		cfg.RedistributeTargetProtocol = redistributeActionInfo.RedistributeTargetProtocol
+	case policyCommonDefs.PoilcyActionTypeSetAdminDistance:
+		cfg.ActionType = "SetAdminDistance"
+		cfg.SetAdminDistanceValue = action.ActionInfo.(int)
+	case policyCommonDefs.PolicyActionTypeNetworkStatementAdvertise:
+		cfg.ActionType = "NetworkStatementAdvertise"
+		cfg.NetworkStatementTargetProtocol = action.ActionInfo.(string)
+	case policyCommonDefs.PolicyActionTypeAggregate:
+		cfg.ActionType = "Aggregate"
+		aggregateActionInfo := action.ActionInfo.(PolicyAggregateActionInfo)
+		cfg.GenerateASSet = aggregateActionInfo.GenerateASSet
+		cfg.SendSummaryOnly = aggregateActionInfo.SendSummaryOnly
+	}
+	return cfg
+}
+
+func stmtConfig(stmt PolicyStmt) PolicyStmtConfig {
+	return PolicyStmtConfig{Name: stmt.Name, MatchConditions: stmt.MatchConditions, Conditions: stmt.Conditions, Actions: stmt.Actions}
+}
+
+func definitionConfig(policy Policy) (cfg PolicyDefinitionConfig) {
+	cfg.Name = policy.Name
+	cfg.Precedence = policy.Precedence
+	cfg.MatchType = policy.MatchType
+	cfg.PolicyType = policy.PolicyType
+	cfg.Import = policy.ImportPolicy
+	cfg.Export = policy.ExportPolicy
+	cfg.Global = policy.GlobalPolicy
+	for precedence, stmt := range policy.PolicyStmtPrecedenceMap {
+		cfg.PolicyDefinitionStatements = append(cfg.PolicyDefinitionStatements, PolicyDefinitionStmtPrecedence{Precedence: precedence, Statement: stmt})
+	}
+	cfg.PolicyDefinitionStatements = sortedStmtPrecedence(cfg.PolicyDefinitionStatements)
+	return cfg
+}
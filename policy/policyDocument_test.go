@@ -0,0 +1,202 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package policy
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"utils/logging"
+)
+
+//adds a prefix set and a condition referring to it on top of the index test policy
+func buildDocumentTestPolicyEngineDB(t *testing.T) *PolicyEngineDB {
+	db := buildIndexTestPolicyEngineDB(t, 6, "all")
+	prefixSetCfg := PolicyPrefixSetConfig{Name: "set1", PrefixList: []PolicyPrefix{{IpPrefix: "30.1.0.0/16", MasklengthRange: "16-24"}}}
+	if _, err := db.CreatePolicyPrefixSet(prefixSetCfg); err != nil {
+		t.Fatal(err)
+	}
+	conditionCfg := PolicyConditionConfig{Name: "setCondition", ConditionType: "MatchDstIpPrefix"}
+	conditionCfg.MatchDstIpPrefixConditionInfo.PrefixSet = "set1"
+	if _, err := db.CreatePolicyCondition(conditionCfg); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPolicyDocumentRoundTrip(t *testing.T) {
+	db := buildDocumentTestPolicyEngineDB(t)
+	for _, format := range []string{PolicyDocumentFormatJSON, PolicyDocumentFormatYAML} {
+		data, err := db.ExportPolicyDocumentData(format)
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		importDB := NewPolicyEngineDB(&logging.Writer{})
+		if err = importDB.ImportPolicyDocument(data, format); err != nil {
+			t.Fatal(format, err)
+		}
+		reexported, err := importDB.ExportPolicyDocumentData(format)
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		if !bytes.Equal(data, reexported) {
+			t.Errorf("%s: re-exported document differs\n%s\n%s", format, data, reexported)
+		}
+		//importing again finds identical objects and leaves the db alone
+		if err = importDB.ImportPolicyDocument(data, format); err != nil {
+			t.Error(format, "re-import:", err)
+		}
+		if !reflect.DeepEqual(importDB.ExportPolicyDocument(), db.ExportPolicyDocument()) {
+			t.Error(format, "re-import changed the db")
+		}
+	}
+}
+
+func TestPolicyDocumentAppliedPolicyMatches(t *testing.T) {
+	db := buildDocumentTestPolicyEngineDB(t)
+	data, err := db.ExportPolicyDocumentData(PolicyDocumentFormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	importDB := NewPolicyEngineDB(&logging.Writer{})
+	if err = importDB.ImportPolicyDocument(data, PolicyDocumentFormatYAML); err != nil {
+		t.Fatal(err)
+	}
+	for _, entity := range indexTestEntities(6) {
+		hits := filterStmtHits(db, entity)
+		importedHits := filterStmtHits(importDB, entity)
+		if !reflect.DeepEqual(hits, importedHits) {
+			t.Errorf("entity %s %s: hits %v, imported hits %v", entity.DestNetIp, entity.RouteProtocol, hits, importedHits)
+		}
+	}
+}
+
+func TestPolicyDocumentFormatFromFileName(t *testing.T) {
+	for fileName, format := range map[string]string{"policy.json": PolicyDocumentFormatJSON, "policy.YAML": PolicyDocumentFormatYAML, "policy.yml": PolicyDocumentFormatYAML} {
+		if got, err := PolicyDocumentFormatFromFileName(fileName); err != nil || got != format {
+			t.Errorf("%s: got %q, %v, want %q", fileName, got, err, format)
+		}
+	}
+	if _, err := PolicyDocumentFormatFromFileName("policy.conf"); err == nil {
+		t.Error("expected an error for an unknown extension")
+	}
+}
+
+func TestPolicyDocumentParseStrict(t *testing.T) {
+	if _, err := ParsePolicyDocument([]byte(`{"Conditions": [{"Name": "c1", "Bogus": 1}]}`), PolicyDocumentFormatJSON); err == nil {
+		t.Error("json: expected an error for an unknown field")
+	}
+	if _, err := ParsePolicyDocument([]byte("Conditions:\n- Name: c1\n  Bogus: 1\n"), PolicyDocumentFormatYAML); err == nil {
+		t.Error("yaml: expected an error for an unknown field")
+	}
+	if _, err := ParsePolicyDocument([]byte("{}"), "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestPolicyDocumentValidationFailures(t *testing.T) {
+	protoCondition := PolicyConditionConfig{Name: "c1", ConditionType: "MatchProtocol", MatchProtocolConditionInfo: "BGP"}
+	stmt := PolicyStmtConfig{Name: "s1", MatchConditions: "all", Conditions: []string{"c1"}, Actions: []string{"permit"}}
+	definition := PolicyDefinitionConfig{Name: "p1", Precedence: 1, MatchType: "all", PolicyType: "ALL",
+		PolicyDefinitionStatements: []PolicyDefinitionStmtPrecedence{{Precedence: 1, Statement: "s1"}}}
+	action := PolicyActionConfig{Name: "a1", ActionType: "RouteDisposition", Accept: true}
+	tests := []struct {
+		name string
+		doc  PolicyDocument
+	}{
+		{"duplicate condition", PolicyDocument{Conditions: []PolicyConditionConfig{protoCondition, protoCondition}}},
+		{"empty name", PolicyDocument{Actions: []PolicyActionConfig{{ActionType: "RouteDisposition", Accept: true}}}},
+		{"unknown action type", PolicyDocument{Actions: []PolicyActionConfig{{Name: "a1", ActionType: "Bogus"}}}},
+		{"missing condition", PolicyDocument{Statements: []PolicyStmtConfig{stmt}}},
+		{"invalid match conditions", PolicyDocument{Conditions: []PolicyConditionConfig{protoCondition},
+			Statements: []PolicyStmtConfig{{Name: "s1", MatchConditions: "some", Conditions: []string{"c1"}}}}},
+		{"invalid statement action", PolicyDocument{Conditions: []PolicyConditionConfig{protoCondition},
+			Statements: []PolicyStmtConfig{{Name: "s1", MatchConditions: "all", Conditions: []string{"c1"}, Actions: []string{"accept"}}}}},
+		{"missing statement", PolicyDocument{Definitions: []PolicyDefinitionConfig{definition}}},
+		{"duplicate precedence", PolicyDocument{Conditions: []PolicyConditionConfig{protoCondition}, Statements: []PolicyStmtConfig{stmt},
+			Definitions: []PolicyDefinitionConfig{{Name: "p1", MatchType: "all", PolicyType: "ALL",
+				PolicyDefinitionStatements: []PolicyDefinitionStmtPrecedence{{Precedence: 1, Statement: "s1"}, {Precedence: 1, Statement: "s1"}}}}}},
+		{"missing applied policy", PolicyDocument{Actions: []PolicyActionConfig{action}, ApplyPolicies: []PolicyApplyConfig{{ApplyPolicy: "p1", Action: "a1"}}}},
+		{"missing apply action", PolicyDocument{Conditions: []PolicyConditionConfig{protoCondition}, Statements: []PolicyStmtConfig{stmt},
+			Definitions: []PolicyDefinitionConfig{definition}, ApplyPolicies: []PolicyApplyConfig{{ApplyPolicy: "p1", Action: "a1"}}}},
+	}
+	for _, test := range tests {
+		db := NewPolicyEngineDB(&logging.Writer{})
+		if err := db.ApplyPolicyDocument(test.doc); err == nil {
+			t.Errorf("%s: expected a validation error", test.name)
+		}
+		if doc := db.ExportPolicyDocument(); !reflect.DeepEqual(doc, PolicyDocument{}) {
+			t.Errorf("%s: failed validation created objects %+v", test.name, doc)
+		}
+	}
+}
+
+func TestPolicyDocumentConflictWithExisting(t *testing.T) {
+	db := buildDocumentTestPolicyEngineDB(t)
+	before := db.ExportPolicyDocument()
+	doc := PolicyDocument{Conditions: []PolicyConditionConfig{{Name: "proto0", ConditionType: "MatchProtocol", MatchProtocolConditionInfo: "OSPF"}}}
+	err := db.ApplyPolicyDocument(doc)
+	if err == nil || !strings.Contains(err.Error(), "conflicts") {
+		t.Errorf("expected a conflict error, got %v", err)
+	}
+	if !reflect.DeepEqual(db.ExportPolicyDocument(), before) {
+		t.Error("conflicting document changed the db")
+	}
+}
+
+func TestPolicyDocumentRollbackOnFailedApply(t *testing.T) {
+	db := buildDocumentTestPolicyEngineDB(t)
+	before := db.ExportPolicyDocument()
+	//the policy type is only checked when the definition is created, after the
+	//prefix set, conditions, action and statement of the document already exist
+	doc := PolicyDocument{
+		PrefixSets: []PolicyPrefixSetConfig{{Name: "set2", PrefixList: []PolicyPrefix{{IpPrefix: "40.1.0.0/16", MasklengthRange: "exact"}}}},
+		Conditions: []PolicyConditionConfig{{Name: "c1", ConditionType: "MatchProtocol", MatchProtocolConditionInfo: "BGP"}},
+		Actions:    []PolicyActionConfig{{Name: "a1", ActionType: "RouteDisposition", Reject: true}},
+		Statements: []PolicyStmtConfig{{Name: "s1", MatchConditions: "all", Conditions: []string{"c1", "proto0"}, Actions: []string{"deny"}}},
+		Definitions: []PolicyDefinitionConfig{{Name: "p1", Precedence: 2, MatchType: "all", PolicyType: "BOGUS",
+			PolicyDefinitionStatements: []PolicyDefinitionStmtPrecedence{{Precedence: 1, Statement: "s1"}}}},
+		ApplyPolicies: []PolicyApplyConfig{{ApplyPolicy: "p1", Action: "a1"}},
+	}
+	if err := db.ApplyPolicyDocument(doc); err == nil {
+		t.Fatal("expected the apply to fail")
+	}
+	if after := db.ExportPolicyDocument(); !reflect.DeepEqual(after, before) {
+		t.Errorf("failed apply was not rolled back\nbefore %+v\nafter  %+v", before, after)
+	}
+	for _, entity := range indexTestEntities(6) {
+		if hits := filterStmtHits(db, entity); len(hits) != 0 && hits[0] == "s1" {
+			t.Errorf("entity %s %s hit rolled back statement s1", entity.DestNetIp, entity.RouteProtocol)
+		}
+	}
+	//the same document with a valid policy type applies cleanly afterwards
+	doc.Definitions[0].PolicyType = "ALL"
+	if err := db.ApplyPolicyDocument(doc); err != nil {
+		t.Fatal(err)
+	}
+	if db.PolicyDB.Get([]byte("p1")) == nil || db.PolicyPrefixSetDB.Get([]byte("set2")) == nil {
+		t.Error("document objects missing after a successful apply")
+	}
+}
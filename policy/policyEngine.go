@@ -27,8 +27,6 @@ package policy
 import (
 	//"reflect"
 	"sort"
-	"strconv"
-	"strings"
 	"utils/netUtils"
 	"utils/patriciaDB"
 	"utils/policy/policyCommonDefs"
@@ -36,7 +34,6 @@ import (
 	"net"
 	//	"asicdServices"
 	//	"asicd/asicdConstDefs"
-	"bytes"
 	//  "database/sql"
 )
 
@@ -55,6 +52,12 @@ func (db *PolicyEngineDB) PolicyEngineCheckActionsForEntity(entity PolicyEngineF
 	var policyStmtList []string
 	switch policyConditionType {
 	case policyCommonDefs.PolicyConditionTypeDstIpPrefixMatch:
+		if db.UsePolicyIndex {
+			index, conditionSet := db.policyIndexLookup(entity)
+			policyStmtList = index.prefixCandidateStmts(conditionSet)
+		} else {
+			policyStmtList = db.prefixConditionStmts()
+		}
 		break
 	case policyCommonDefs.PolicyConditionTypeProtocolMatch:
 		policyStmtList = db.ProtocolPolicyListDB[entity.RouteProtocol]
@@ -72,14 +75,14 @@ func (db *PolicyEngineDB) PolicyEngineCheckActionsForEntity(entity PolicyEngineF
 		policyList := db.PolicyStmtPolicyMapDB[policyStmtList[i]]
 		if policyList == nil || len(policyList) == 0 {
 			db.Logger.Info("No policies configured for this entity")
-			return nil
+			continue
 		}
 		for j := 0; j < len(policyList); j++ {
 			db.Logger.Info("Found policy ", policyList[j], "for this statement")
 			policyStmtInfo := db.PolicyStmtDB.Get(patriciaDB.Prefix(policyStmtList[i]))
 			if policyStmtInfo == nil {
 				db.Logger.Info("Did not find this stmt in the DB")
-				return nil
+				continue
 			}
 			policyStmt := policyStmtInfo.(PolicyStmt)
 			if db.ConditionCheckValid(entity, policyStmt.Conditions, policyStmt) {
@@ -135,6 +138,8 @@ func (db *PolicyEngineDB) PolicyEngineUndoPolicyForEntity(entity PolicyEngineFil
 	}
 	for stmt, conditionsAndActionsList := range policyStmtMap.PolicyStmtMap {
 		db.Logger.Info("Applied policyStmtName ", stmt)
+		//the entity stops contributing to aggregates even if the statement is gone by now
+		db.removeAggregateContributor(entity.DestNetIp, stmt, params)
 		policyStmt := db.PolicyStmtDB.Get(patriciaDB.Prefix(stmt))
 		if policyStmt == nil {
 			db.Logger.Info("Invalid policyStmt")
@@ -163,12 +168,18 @@ func (db *PolicyEngineDB) PolicyEngineImplementActions(entity PolicyEngineFilter
 			if db.UndoActionfuncMap[action.ActionType] != nil {
 				db.UndoActionfuncMap[action.ActionType](action.ActionInfo, conditionInfoList, params, policyStmt)
 			}
+			if action.ActionType == policyCommonDefs.PolicyActionTypeAggregate {
+				db.removeAggregateContributor(entity.DestNetIp, policyStmt.Name, params)
+			}
 			addActionToList = true
 		} else { //if entity.CreatePath == true or neither create/delete is valid - in case this function is called a a part of policy create{
 			db.Logger.Info("action to be applied", action.ActionType)
 			if db.ActionfuncMap[action.ActionType] != nil {
 				db.ActionfuncMap[action.ActionType](action.ActionInfo, conditionInfoList, params)
 			}
+			if action.ActionType == policyCommonDefs.PolicyActionTypeAggregate {
+				db.addAggregateContributor(entity.DestNetIp, policyStmt.Name, action.ActionInfo.(PolicyAggregateActionInfo), conditionInfoList, params)
+			}
 			addActionToList = true
 		}
 	default:
@@ -227,45 +238,40 @@ func (db *PolicyEngineDB) FindPrefixMatch(ipAddr string, ipPrefix patriciaDB.Pre
 	}
 	return match
 }*/
+//the route matches if it is within the condition prefix of the same address family and,
+//for a masklength range condition, its masklength is within the range
 func (db *PolicyEngineDB) FindPrefixMatch(ipAddr string, ipPrefix patriciaDB.Prefix, condition PolicyCondition) (match bool) {
-	db.Logger.Info("ipAddr : ", ipAddr, " ipPrefix: ", ipPrefix, " condition.IpPrefix: ", condition.ConditionInfo.(MatchPrefixConditionInfo).IpPrefix, " conditionInfo,MaskLengthRange: ", condition.ConditionInfo.(MatchPrefixConditionInfo).Prefix.IpPrefix)
 	conditionInfo := condition.ConditionInfo.(MatchPrefixConditionInfo)
-	if conditionInfo.LowRange == -1 && conditionInfo.HighRange == -1 {
-		_, ipNet, err := net.ParseCIDR(condition.ConditionInfo.(MatchPrefixConditionInfo).Prefix.IpPrefix)
-		if err != nil {
-			return false
-		}
-		if bytes.Equal(ipPrefix, conditionInfo.IpPrefix) {
-			db.Logger.Info(" Matched the prefix")
-			return true
-		}
-		networkMask := ipNet.Mask
-		vdestMask := net.IPv4Mask(networkMask[0], networkMask[1], networkMask[2], networkMask[3])
-		destIp := (net.IP(ipPrefix)).Mask(vdestMask)
-		db.Logger.Info("networkMask: ", networkMask, " vdestMask: ", vdestMask, " destIp: ", destIp, "Looking for exact match condition for prefix ", conditionInfo.IpPrefix, " and ", destIp)
-		if bytes.Equal(destIp, conditionInfo.IpPrefix) {
-			db.Logger.Info(" Matched the prefix")
-			return true
-		} else {
-			db.Logger.Info(" Did not match the exact prefix")
-			return false
-		}
+	db.Logger.Info("ipAddr : ", ipAddr, " ipPrefix: ", ipPrefix, " condition.IpPrefix: ", conditionInfo.IpPrefix, " conditionInfo.MaskLengthRange: ", conditionInfo.Prefix.MasklengthRange)
+	_, routeNet, err := net.ParseCIDR(ipAddr)
+	if err != nil {
+		db.Logger.Err("Invalid route prefix ", ipAddr)
+		return false
 	}
-	tempSlice := strings.Split(ipAddr, "/")
-	maskLen, err := strconv.Atoi(tempSlice[1])
+	_, conditionNet, err := net.ParseCIDR(conditionInfo.Prefix.IpPrefix)
 	if err != nil {
-		db.Logger.Err("err getting maskLen")
 		return false
 	}
-	db.Logger.Info("Mask len = ", maskLen)
+	if GetPrefixAddressFamily(routeNet) != GetPrefixAddressFamily(conditionNet) {
+		db.Logger.Info("Address family of the route ", ipAddr, " does not match the condition prefix ", conditionInfo.Prefix.IpPrefix)
+		return false
+	}
+	maskLen, _ := routeNet.Mask.Size()
+	conditionMaskLen, _ := conditionNet.Mask.Size()
+	if maskLen < conditionMaskLen || !conditionNet.Contains(routeNet.IP) {
+		db.Logger.Info(" Route ", ipAddr, " not within the condition prefix ", conditionInfo.Prefix.IpPrefix)
+		return false
+	}
+	if conditionInfo.LowRange == -1 && conditionInfo.HighRange == -1 {
+		db.Logger.Info(" Matched the prefix")
+		return true
+	}
 	if maskLen < conditionInfo.LowRange || maskLen > conditionInfo.HighRange {
 		db.Logger.Info("Mask range of the route ", maskLen, " not within the required mask range:", conditionInfo.LowRange, "-", conditionInfo.HighRange)
 		return false
-	} else {
-		db.Logger.Info("Mask range of the route ", maskLen, " within the required mask range:", conditionInfo.LowRange, "-", conditionInfo.HighRange)
-		return true
 	}
-	return match
+	db.Logger.Info("Mask range of the route ", maskLen, " within the required mask range:", conditionInfo.LowRange, "-", conditionInfo.HighRange)
+	return true
 }
 func (db *PolicyEngineDB) DstIpPrefixMatchConditionfunc(entity PolicyEngineFilterEntityParams, condition PolicyCondition) (match bool) {
 	db.Logger.Info("dstIpPrefixMatchConditionfunc")
@@ -274,7 +280,25 @@ func (db *PolicyEngineDB) DstIpPrefixMatchConditionfunc(entity PolicyEngineFilte
 		db.Logger.Info("Invalid ipPrefix for the route ", entity.DestNetIp)
 		return false
 	}
-	match = db.FindPrefixMatch(entity.DestNetIp, ipPrefix, condition)
+	conditionInfo := condition.ConditionInfo.(MatchPrefixConditionInfo)
+	if conditionInfo.UsePrefixSet {
+		item := db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(conditionInfo.PrefixSet))
+		if item == nil {
+			db.Logger.Info("Prefix set ", conditionInfo.PrefixSet, " not found")
+			return false
+		}
+		//match if any of the prefixes in the set match
+		for _, prefixInfo := range item.(PolicyPrefixSet).PrefixInfoList {
+			setCondition := condition
+			setCondition.ConditionInfo = prefixInfo
+			if db.FindPrefixMatch(entity.DestNetIp, ipPrefix, setCondition) {
+				match = true
+				break
+			}
+		}
+	} else {
+		match = db.FindPrefixMatch(entity.DestNetIp, ipPrefix, condition)
+	}
 	if match {
 		db.Logger.Info("Found a match for this prefix")
 	}
@@ -353,7 +377,7 @@ func (db *PolicyEngineDB) PolicyEngineMatchConditions(entity PolicyEngineFilterE
 	if matchConditions == "any" && anyConditionsMatch == true {
 		return true, conditionsList
 	}
-	return match, conditionsList
+	return false, conditionsList
 }
 func (db *PolicyEngineDB) PolicyEngineApplyPolicyStmt(entity *PolicyEngineFilterEntityParams, info ApplyPolicyInfo,
 	policyStmt PolicyStmt, policyPath int, params interface{}, hit *bool, deleted *bool) {
@@ -362,6 +386,14 @@ func (db *PolicyEngineDB) PolicyEngineApplyPolicyStmt(entity *PolicyEngineFilter
 	var conditionList []PolicyCondition
 	conditionInfoList := make([]interface{}, 0)
 	var match bool
+	matchedConditions := make([]PolicyCondition, 0)
+	stmtMatched := false
+	actionsApplied := 0
+	defer func() {
+		if stmtMatched {
+			db.countPolicyStmtMatch(policy.Name, policyStmt.Name, matchedConditions, actionsApplied)
+		}
+	}()
 	if policyStmt.Conditions == nil && info.Conditions == nil {
 		db.Logger.Info("No policy conditions")
 		*hit = true
@@ -377,6 +409,7 @@ func (db *PolicyEngineDB) PolicyEngineApplyPolicyStmt(entity *PolicyEngineFilter
 		for j := 0; j < len(conditionList); j++ {
 			conditionInfoList = append(conditionInfoList, conditionList[j].ConditionInfo)
 		}
+		matchedConditions = append(matchedConditions, conditionList...)
 		match, conditionList = db.PolicyEngineMatchConditions(*entity, info.Conditions, "all")
 		db.Logger.Info("match = ", match)
 		*hit = match
@@ -387,8 +420,11 @@ func (db *PolicyEngineDB) PolicyEngineApplyPolicyStmt(entity *PolicyEngineFilter
 		for j := 0; j < len(conditionList); j++ {
 			conditionInfoList = append(conditionInfoList, conditionList[j].ConditionInfo)
 		}
+		matchedConditions = append(matchedConditions, conditionList...)
 	}
+	stmtMatched = true
 	actionList := db.PolicyEngineImplementActions(*entity, info.Action, conditionInfoList, params, policyStmt)
+	actionsApplied = len(actionList)
 	if db.ActionListHasAction(actionList, policyCommonDefs.PolicyActionTypeRouteDisposition, "Reject") {
 		db.Logger.Info("Reject action was applied for this entity")
 		*deleted = true
@@ -409,30 +445,51 @@ func (db *PolicyEngineDB) PolicyEngineApplyPolicy(entity *PolicyEngineFilterEnti
 	policy := info.ApplyPolicy
 	var policyStmtKeys []int
 	deleted := false
-	for k := range policy.PolicyStmtPrecedenceMap {
-		db.Logger.Info("key k = ", k)
-		policyStmtKeys = append(policyStmtKeys, k)
+	policyMatched := false
+	defer func() {
+		db.updatePolicyCounters(db.PolicyStatsDB, policy.Name, true, policyMatched, 0)
+	}()
+	var policyStmtList []string
+	lastSkipped := false
+	if db.UsePolicyIndex {
+		//only the stmts which can match are evaluated, the others would be misses
+		index, conditionSet := db.policyIndexLookup(*entity)
+		policyStmtList, lastSkipped = index.candidateStmts(policy, index.stmtHitCount(conditionSet))
+	} else {
+		for k := range policy.PolicyStmtPrecedenceMap {
+			db.Logger.Info("key k = ", k)
+			policyStmtKeys = append(policyStmtKeys, k)
+		}
+		sort.Ints(policyStmtKeys)
+		for i := 0; i < len(policyStmtKeys); i++ {
+			policyStmtList = append(policyStmtList, policy.PolicyStmtPrecedenceMap[policyStmtKeys[i]])
+		}
 	}
-	sort.Ints(policyStmtKeys)
-	for i := 0; i < len(policyStmtKeys); i++ {
-		db.Logger.Info("Key: ", policyStmtKeys[i], " policyStmtName ", policy.PolicyStmtPrecedenceMap[policyStmtKeys[i]])
-		policyStmt := db.PolicyStmtDB.Get((patriciaDB.Prefix(policy.PolicyStmtPrecedenceMap[policyStmtKeys[i]])))
+	for i := 0; i < len(policyStmtList); i++ {
+		db.Logger.Info("policyStmtName ", policyStmtList[i])
+		policyStmt := db.PolicyStmtDB.Get(patriciaDB.Prefix(policyStmtList[i]))
 		if policyStmt == nil {
 			db.Logger.Info("Invalid policyStmt")
 			continue
 		}
 		db.PolicyEngineApplyPolicyStmt(entity, info, policyStmt.(PolicyStmt), policyPath, params, hit, &deleted)
+		if *hit == true {
+			policyMatched = true
+		}
 		if deleted == true {
-			db.Logger.Info("Entity was deleted as a part of the policyStmt ", policy.PolicyStmtPrecedenceMap[policyStmtKeys[i]])
-			break
+			db.Logger.Info("Entity was deleted as a part of the policyStmt ", policyStmtList[i])
+			return
 		}
 		if *hit == true {
 			if policy.MatchType == "any" {
 				db.Logger.Info("Match type for policy ", policy.Name, " is any and the policy stmt ", (policyStmt.(PolicyStmt)).Name, " is a hit, no more policy statements will be executed")
-				break
+				return
 			}
 		}
 	}
+	if lastSkipped {
+		*hit = false
+	}
 }
 func (db *PolicyEngineDB) PolicyEngineApplyForEntity(entity PolicyEngineFilterEntityParams, policyData interface{}, params interface{}) {
 	db.Logger.Info("policyEngineApplyForEntity")
@@ -0,0 +1,326 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package policy
+
+import (
+	"reflect"
+	"testing"
+	"utils/logging"
+)
+
+func TestDstIpPrefixMatchAddressFamily(t *testing.T) {
+	db := NewPolicyEngineDB(&logging.Writer{})
+	conditions := map[string]PolicyPrefix{
+		"v6exact": PolicyPrefix{IpPrefix: "2001:db8::/32", MasklengthRange: "exact"},
+		"v6range": PolicyPrefix{IpPrefix: "2001:db8::/32", MasklengthRange: "48-64"},
+		"v4exact": PolicyPrefix{IpPrefix: "32.1.13.184/32", MasklengthRange: "exact"}, //same bytes as 2001:db8::/32
+		"v4range": PolicyPrefix{IpPrefix: "10.0.0.0/8", MasklengthRange: "16-24"},
+	}
+	for name, prefix := range conditions {
+		cfg := PolicyConditionConfig{Name: name, ConditionType: "MatchDstIpPrefix"}
+		cfg.MatchDstIpPrefixConditionInfo.Prefix = prefix
+		if _, err := db.CreatePolicyCondition(cfg); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+	tests := map[string][]string{
+		"2001:db8::/32":       []string{"v6exact"},
+		"2001:db8:1::/48":     []string{"v6exact", "v6range"},
+		"2001:db8:1:2::/64":   []string{"v6exact", "v6range"},
+		"2001:db8:1:2::/96":   []string{"v6exact"},
+		"2001:db9::/48":       []string{},
+		"32.1.13.184/32":      []string{"v4exact"},
+		"10.1.0.0/16":         []string{"v4range"},
+		"10.1.2.0/24":         []string{"v4range"},
+		"10.1.2.128/25":       []string{},
+		"11.1.0.0/16":         []string{},
+		"::ffff:10.1.0.0/112": []string{},
+	}
+	for route, expected := range tests {
+		entity := PolicyEngineFilterEntityParams{DestNetIp: route}
+		_, conditionSet := db.policyIndexLookup(entity)
+		for name := range conditions {
+			condition := db.PolicyConditionsDB.Get([]byte(name)).(PolicyCondition)
+			match := db.DstIpPrefixMatchConditionfunc(entity, condition)
+			want := false
+			for _, e := range expected {
+				want = want || e == name
+			}
+			if match != want {
+				t.Errorf("route %s condition %s: match %v, expected %v", route, name, match, want)
+			}
+			if conditionSet[name] != want {
+				t.Errorf("route %s condition %s: index candidate %v, expected %v", route, name, conditionSet[name], want)
+			}
+		}
+	}
+}
+
+func TestMatchPrefixMasklengthRangeValidation(t *testing.T) {
+	db := NewPolicyEngineDB(&logging.Writer{})
+	valid := []PolicyPrefix{
+		PolicyPrefix{IpPrefix: "10.0.0.0/8", MasklengthRange: "8-32"},
+		PolicyPrefix{IpPrefix: "2001:db8::/32", MasklengthRange: "32-128"},
+	}
+	invalid := []PolicyPrefix{
+		PolicyPrefix{IpPrefix: "10.0.0.0/8", MasklengthRange: "8-33"},
+		PolicyPrefix{IpPrefix: "10.0.0.0/8", MasklengthRange: "24-16"},
+		PolicyPrefix{IpPrefix: "2001:db8::/32", MasklengthRange: "64-129"},
+		PolicyPrefix{IpPrefix: "2001:db8::/129", MasklengthRange: "exact"},
+	}
+	for _, prefix := range valid {
+		if _, err := db.GetMatchPrefixConditionInfo(prefix); err != nil {
+			t.Errorf("%v rejected: %v", prefix, err)
+		}
+	}
+	for _, prefix := range invalid {
+		if _, err := db.GetMatchPrefixConditionInfo(prefix); err == nil {
+			t.Errorf("%v accepted", prefix)
+		}
+	}
+}
+
+func TestPolicyAggregateContributors(t *testing.T) {
+	db := NewPolicyEngineDB(&logging.Writer{})
+	var created, withdrawn []string
+	suppressed := make(map[string]bool)
+	db.SetAggregateCreateFunc(func(aggregate PolicyAggregate, params interface{}) {
+		created = append(created, aggregate.Prefix)
+	})
+	db.SetAggregateWithdrawFunc(func(aggregate PolicyAggregate, params interface{}) {
+		withdrawn = append(withdrawn, aggregate.Prefix)
+	})
+	db.SetAggregateSuppressFunc(func(route string, aggregate PolicyAggregate, suppress bool, params interface{}) {
+		suppressed[route] = suppress
+	})
+	prefixInfo, err := db.GetMatchPrefixConditionInfo(PolicyPrefix{IpPrefix: "10.0.0.0/8", MasklengthRange: "8-32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditionInfoList := []interface{}{prefixInfo}
+	actionInfo := PolicyAggregateActionInfo{SendSummaryOnly: true}
+	db.addAggregateContributor("10.1.0.0/16", "s1", actionInfo, conditionInfoList, nil)
+	db.addAggregateContributor("10.2.0.0/16", "s1", actionInfo, conditionInfoList, nil)
+	db.addAggregateContributor("10.0.0.0/8", "s1", actionInfo, conditionInfoList, nil) //not a more specific
+	if len(created) != 1 || created[0] != "10.0.0.0/8" {
+		t.Fatalf("created %v, expected [10.0.0.0/8]", created)
+	}
+	aggregate, found := db.GetAggregate("10.0.0.0/8")
+	if !found || len(aggregate.Contributors) != 2 {
+		t.Fatalf("aggregate %+v found %v, expected 2 contributors", aggregate, found)
+	}
+	if !suppressed["10.1.0.0/16"] || !db.IsRouteSuppressed("10.2.0.0/16") || db.IsRouteSuppressed("10.0.0.0/8") {
+		t.Errorf("unexpected suppression state %v", suppressed)
+	}
+	db.removeAggregateContributor("10.1.0.0/16", "s1", nil)
+	if len(withdrawn) != 0 || suppressed["10.1.0.0/16"] {
+		t.Errorf("withdrawn %v suppressed %v after removing the first contributor", withdrawn, suppressed)
+	}
+	db.removeAggregateContributor("10.2.0.0/16", "s1", nil)
+	if len(withdrawn) != 1 || withdrawn[0] != "10.0.0.0/8" || len(db.GetAllAggregates()) != 0 {
+		t.Errorf("withdrawn %v, expected [10.0.0.0/8]", withdrawn)
+	}
+}
+
+func TestPolicyAggregateConflictingAction(t *testing.T) {
+	db := NewPolicyEngineDB(&logging.Writer{})
+	prefixInfo, err := db.GetMatchPrefixConditionInfo(PolicyPrefix{IpPrefix: "10.0.0.0/8", MasklengthRange: "8-32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditionInfoList := []interface{}{prefixInfo}
+	db.addAggregateContributor("10.1.0.0/16", "s1", PolicyAggregateActionInfo{SendSummaryOnly: true}, conditionInfoList, nil)
+	db.addAggregateContributor("10.2.0.0/16", "s2", PolicyAggregateActionInfo{SendSummaryOnly: false}, conditionInfoList, nil)
+	db.addAggregateContributor("10.3.0.0/16", "s3", PolicyAggregateActionInfo{SendSummaryOnly: true}, conditionInfoList, nil)
+	aggregate, _ := db.GetAggregate("10.0.0.0/8")
+	if !aggregate.SendSummaryOnly || !reflect.DeepEqual(aggregate.Contributors, []string{"10.1.0.0/16", "10.3.0.0/16"}) {
+		t.Fatalf("aggregate %+v, expected the contributors of the summary only stmts", aggregate)
+	}
+	if db.IsRouteSuppressed("10.2.0.0/16") {
+		t.Error("route of the conflicting stmt suppressed")
+	}
+}
+
+func TestPolicyUndoRemovesAggregateContributorOfDeletedStmt(t *testing.T) {
+	db := NewPolicyEngineDB(&logging.Writer{})
+	var withdrawn []string
+	db.SetAggregateWithdrawFunc(func(aggregate PolicyAggregate, params interface{}) {
+		withdrawn = append(withdrawn, aggregate.Prefix)
+	})
+	prefixInfo, err := db.GetMatchPrefixConditionInfo(PolicyPrefix{IpPrefix: "10.0.0.0/8", MasklengthRange: "8-32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.addAggregateContributor("10.1.0.0/16", "deletedStmt", PolicyAggregateActionInfo{}, []interface{}{prefixInfo}, nil)
+	db.SetGetPolicyEntityMapIndexFunc(func(entity PolicyEngineFilterEntityParams, policy string) PolicyEntityMapIndex {
+		return entity.DestNetIp + policy
+	})
+	entity := PolicyEngineFilterEntityParams{DestNetIp: "10.1.0.0/16", DeletePath: true}
+	db.PolicyEntityMap["10.1.0.0/16policy1"] = PolicyStmtMap{PolicyStmtMap: map[string]ConditionsAndActionsList{"deletedStmt": {}}}
+	db.PolicyEngineUndoPolicyForEntity(entity, Policy{Name: "policy1"}, nil)
+	if len(withdrawn) != 1 || withdrawn[0] != "10.0.0.0/8" || len(db.GetAllAggregates()) != 0 {
+		t.Errorf("withdrawn %v, expected [10.0.0.0/8]", withdrawn)
+	}
+}
+
+func TestPolicySubscriptionNotifications(t *testing.T) {
+	db := NewPolicyEngineDB(&logging.Writer{})
+	cfg := PolicyConditionConfig{Name: "c1", ConditionType: "MatchDstIpPrefix"}
+	cfg.MatchDstIpPrefixConditionInfo.Prefix = PolicyPrefix{IpPrefix: "10.0.0.0/8", MasklengthRange: "exact"}
+	if _, err := db.CreatePolicyCondition(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var notifications []PolicyNotification
+	id := db.Subscribe(PolicySubscriberFunc(func(notification PolicyNotification) {
+		notifications = append(notifications, notification)
+	}), PolicyObjectTypeCondition|PolicyObjectTypeStmt, true)
+	if len(notifications) != 1 || notifications[0].Op != PolicyNotifyCreate || notifications[0].Name != "c1" {
+		t.Fatalf("replay notifications %+v, expected a create for c1", notifications)
+	}
+	notifications = nil
+	if err := db.CreatePolicyStatement(PolicyStmtConfig{Name: "s1", MatchConditions: "all", Conditions: []string{"c1"}, Actions: []string{"permit"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreatePolicyDefinition(PolicyDefinitionConfig{Name: "p1", Precedence: 1, MatchType: "all",
+		PolicyDefinitionStatements: []PolicyDefinitionStmtPrecedence{{Precedence: 1, Statement: "s1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		objectType int
+		op         int
+		name       string
+	}{
+		{PolicyObjectTypeCondition, PolicyNotifyUpdate, "c1"},
+		{PolicyObjectTypeStmt, PolicyNotifyCreate, "s1"},
+		{PolicyObjectTypeStmt, PolicyNotifyUpdate, "s1"},
+	}
+	if len(notifications) != len(expected) {
+		t.Fatalf("got %d notifications %+v, expected %d", len(notifications), notifications, len(expected))
+	}
+	for i, e := range expected {
+		n := notifications[i]
+		if n.ObjectType != e.objectType || n.Op != e.op || n.Name != e.name {
+			t.Errorf("notification %d: %+v, expected %+v", i, n, e)
+		}
+	}
+	oldStmt, newStmt := notifications[2].OldValue.(PolicyStmt), notifications[2].NewValue.(PolicyStmt)
+	if len(oldStmt.PolicyList) != 0 || len(newStmt.PolicyList) != 1 || newStmt.PolicyList[0] != "p1" {
+		t.Errorf("stmt update old %v new %v", oldStmt.PolicyList, newStmt.PolicyList)
+	}
+	if !db.Unsubscribe(id) || db.Unsubscribe(id) {
+		t.Error("unexpected Unsubscribe result")
+	}
+	notifications = nil
+	db.DeletePolicyDefinition(PolicyDefinitionConfig{Name: "p1"})
+	if len(notifications) != 0 {
+		t.Errorf("notifications %+v after Unsubscribe", notifications)
+	}
+}
+
+func TestPolicyDependencyGraph(t *testing.T) {
+	db := NewPolicyEngineDB(&logging.Writer{})
+	if _, err := db.CreatePolicyPrefixSet(PolicyPrefixSetConfig{Name: "ps1",
+		PrefixList: []PolicyPrefix{{IpPrefix: "10.0.0.0/8", MasklengthRange: "8-24"}}}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := PolicyConditionConfig{Name: "c1", ConditionType: "MatchDstIpPrefix"}
+	cfg.MatchDstIpPrefixConditionInfo.PrefixSet = "ps1"
+	if _, err := db.CreatePolicyCondition(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"s1", "s2"} {
+		if err := db.CreatePolicyStatement(PolicyStmtConfig{Name: name, MatchConditions: "all", Conditions: []string{"c1"}, Actions: []string{"permit"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CreatePolicyDefinition(PolicyDefinitionConfig{Name: "p1", Precedence: 1, MatchType: "all",
+		PolicyDefinitionStatements: []PolicyDefinitionStmtPrecedence{{Precedence: 1, Statement: "s1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	dependants := db.GetPolicyObjectDependants(PolicyObjectTypeCondition, "c1")
+	if len(dependants) != 2 || dependants[0].Name != "s1" || dependants[1].Name != "s2" {
+		t.Errorf("dependants of c1 %v, expected s1 and s2", dependants)
+	}
+	uses := db.GetPolicyObjectDependencies(PolicyObjectTypeCondition, "c1")
+	if len(uses) != 1 || uses[0] != (PolicyObjectRef{PolicyObjectTypePrefixSet, "ps1"}) {
+		t.Errorf("c1 uses %v, expected prefix set ps1", uses)
+	}
+	_, err := db.DeletePolicyCondition(PolicyConditionConfig{Name: "c1"})
+	inUse, ok := err.(*PolicyObjectInUseError)
+	if !ok || len(inUse.Dependants) != 2 {
+		t.Fatalf("delete of c1 returned %v, expected an in use error", err)
+	}
+	if err.Error() != "condition c1 is in use by policy statement s1, policy statement s2" {
+		t.Errorf("unexpected error text %q", err.Error())
+	}
+	if err := db.ValidatePolicyStatementDelete(PolicyStmtConfig{Name: "s1"}); err == nil {
+		t.Error("s1 used by p1 but can be deleted")
+	}
+	if err := db.DeletePolicyStatement(PolicyStmtConfig{Name: "s2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeletePolicyDefinition(PolicyDefinitionConfig{Name: "p1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreatePolicyDefinition(PolicyDefinitionConfig{Name: "p2", Precedence: 2, MatchType: "all",
+		PolicyDefinitionStatements: []PolicyDefinitionStmtPrecedence{{Precedence: 1, Statement: "s1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	p2 := db.PolicyDB.Get([]byte("p2")).(Policy)
+	p2.ExportPolicy = true
+	db.PolicyDB.Set([]byte("p2"), p2)
+	db.UpdateApplyPolicy(ApplyPolicyInfo{ApplyPolicy: p2}, false)
+	if err := db.ValidatePolicyStatementDelete(PolicyStmtConfig{Name: "s1"}); err == nil {
+		t.Error("s1 used by p2 but can be deleted")
+	}
+	var reversed []string
+	db.SetTraverseAndReversePolicyFunc(func(policyItem interface{}) {
+		reversed = append(reversed, policyItem.(Policy).Name)
+	})
+	//applied policies are reversed and deleted along with their bindings
+	if err := db.DeletePolicyDefinition(PolicyDefinitionConfig{Name: "p2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(reversed) != 1 || reversed[0] != "p2" {
+		t.Errorf("reversed %v on delete of p2, expected p2", reversed)
+	}
+	if db.PolicyDB.Get([]byte("p2")) != nil || len(db.ApplyPolicyMap["p2"]) != 0 {
+		t.Error("p2 or its binding not deleted")
+	}
+	if err := db.DeletePolicyStatement(PolicyStmtConfig{Name: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ValidatePolicyPrefixSetDelete(PolicyPrefixSetConfig{Name: "ps1"}); err == nil {
+		t.Error("ps1 used by c1 but can be deleted")
+	}
+	if _, err := db.DeletePolicyCondition(PolicyConditionConfig{Name: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DeletePolicyPrefixSet(PolicyPrefixSetConfig{Name: "ps1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.PolicyObjectUsesDB) != 0 || len(db.PolicyObjectUsedByDB) != 0 {
+		t.Errorf("graph not empty after deleting all objects: %v %v", db.PolicyObjectUsesDB, db.PolicyObjectUsedByDB)
+	}
+}
@@ -0,0 +1,373 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// policyIndex.go
+package policy
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"utils/patriciaDB"
+	"utils/policy/policyCommonDefs"
+)
+
+//The policy index narrows down the statements an entity has to be evaluated against.
+//It only ever returns a superset of the statements that can match, every candidate
+//is still checked with the condition check functions. Conditions of a type without
+//a check function are ignored by the engine, so they do not constrain the candidates either.
+
+type policyIndexTrieEntry struct {
+	condition string
+	lowRange  int
+	highRange int
+}
+
+type policyIndexTrieNode struct {
+	children [2]*policyIndexTrieNode
+	entries  []policyIndexTrieEntry
+}
+
+//binary trie over the prefix bits of the destination ip prefix conditions
+func (node *policyIndexTrieNode) insert(prefix []byte, prefixLen int, entry policyIndexTrieEntry) {
+	for bit := 0; bit < prefixLen && bit < len(prefix)*8; bit++ {
+		b := (prefix[bit/8] >> uint(7-bit%8)) & 1
+		if node.children[b] == nil {
+			node.children[b] = &policyIndexTrieNode{}
+		}
+		node = node.children[b]
+	}
+	node.entries = append(node.entries, entry)
+}
+
+//collects the conditions of all the prefixes covering the route prefix whose masklength range fits
+func (node *policyIndexTrieNode) lookup(prefix []byte, prefixLen int, conditionSet map[string]bool) {
+	for bit := 0; node != nil; bit++ {
+		for _, entry := range node.entries {
+			if entry.lowRange == -1 && entry.highRange == -1 || prefixLen >= entry.lowRange && prefixLen <= entry.highRange {
+				conditionSet[entry.condition] = true
+			}
+		}
+		if bit >= prefixLen || bit >= len(prefix)*8 {
+			break
+		}
+		node = node.children[(prefix[bit/8]>>uint(7-bit%8))&1]
+	}
+}
+
+type policyIndexStmtInfo struct {
+	matchConditions string
+	conditionCount  int  //number of conditions of the stmt which constrain the candidates
+	hasConditions   bool //stmts without conditions are evaluated for every entity
+}
+
+type policyIndexPolicyStmt struct {
+	precedence int
+	name       string
+}
+
+//stmts of a policy in precedence order
+type policyIndexPolicyInfo struct {
+	stmtMap   map[int]string //precedence map of the policy the info was built from
+	stmts     []policyIndexPolicyStmt
+	always    []int            //positions of the stmts the index cannot rule out
+	positions map[string][]int //positions of every stmt
+}
+
+type policyIndex struct {
+	prefixTries        map[int]*policyIndexTrieNode //prefix conditions per address family
+	protocolConditions map[string][]string          //protocol conditions by protocol
+	neighborConditions map[string][]string          //neighbor conditions by neighbor
+	otherConditions    []string                     //conditions that cannot be indexed, always candidates
+	prefixConditions   map[string]bool              //destination ip prefix conditions
+	conditionStmts     map[string][]string          //stmts using every constraining condition
+	stmts              map[string]policyIndexStmtInfo
+	checkTypes         map[int]bool //condition types with a check function when the index was built
+	policyLock         sync.Mutex
+	policies           map[string]*policyIndexPolicyInfo //built on the first lookup of every policy
+}
+
+func (db *PolicyEngineDB) SetPolicyIndexEnable(enable bool) {
+	db.IndexLock.Lock()
+	db.UsePolicyIndex = enable
+	db.policyIndex = nil
+	db.IndexLock.Unlock()
+}
+
+//called whenever conditions, statements or prefix sets change, the index is rebuilt on the next lookup
+func (db *PolicyEngineDB) invalidatePolicyIndex() {
+	db.IndexLock.Lock()
+	db.policyIndex = nil
+	db.IndexLock.Unlock()
+}
+
+func (db *PolicyEngineDB) policyIndexValid() bool {
+	if db.policyIndex == nil || len(db.policyIndex.checkTypes) != len(db.ConditionCheckfuncMap) {
+		return false
+	}
+	for conditionType, checkfunc := range db.ConditionCheckfuncMap {
+		if db.policyIndex.checkTypes[conditionType] != (checkfunc != nil) {
+			return false
+		}
+	}
+	return true
+}
+
+func (db *PolicyEngineDB) addPrefixToPolicyIndex(index *policyIndex, prefixInfo MatchPrefixConditionInfo, condition string) {
+	_, ipNet, err := net.ParseCIDR(prefixInfo.Prefix.IpPrefix)
+	if err != nil {
+		return
+	}
+	prefixLen, _ := ipNet.Mask.Size()
+	entry := policyIndexTrieEntry{condition: condition, lowRange: prefixInfo.LowRange, highRange: prefixInfo.HighRange}
+	index.prefixTries[GetPrefixAddressFamily(ipNet)].insert(ipNet.IP, prefixLen, entry)
+}
+
+func (db *PolicyEngineDB) buildPolicyIndex() *policyIndex {
+	db.Logger.Info("buildPolicyIndex")
+	index := &policyIndex{
+		prefixTries: map[int]*policyIndexTrieNode{
+			policyCommonDefs.PolicyAddressFamilyIPv4: &policyIndexTrieNode{},
+			policyCommonDefs.PolicyAddressFamilyIPv6: &policyIndexTrieNode{},
+		},
+		protocolConditions: make(map[string][]string),
+		neighborConditions: make(map[string][]string),
+		otherConditions:    make([]string, 0),
+		prefixConditions:   make(map[string]bool),
+		conditionStmts:     make(map[string][]string),
+		stmts:              make(map[string]policyIndexStmtInfo),
+		checkTypes:         make(map[int]bool),
+		policies:           make(map[string]*policyIndexPolicyInfo),
+	}
+	for conditionType, checkfunc := range db.ConditionCheckfuncMap {
+		index.checkTypes[conditionType] = checkfunc != nil
+	}
+	constraining := make(map[string]bool)
+	db.PolicyConditionsDB.Visit(func(prefix patriciaDB.Prefix, item patriciaDB.Item) error {
+		condition := item.(PolicyCondition)
+		if !index.checkTypes[condition.ConditionType] {
+			return nil
+		}
+		constraining[condition.Name] = true
+		switch condition.ConditionType {
+		case policyCommonDefs.PolicyConditionTypeDstIpPrefixMatch:
+			index.prefixConditions[condition.Name] = true
+			conditionInfo := condition.ConditionInfo.(MatchPrefixConditionInfo)
+			if !conditionInfo.UsePrefixSet {
+				db.addPrefixToPolicyIndex(index, conditionInfo, condition.Name)
+				break
+			}
+			prefixSet := db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(conditionInfo.PrefixSet))
+			if prefixSet == nil {
+				break
+			}
+			for _, prefixInfo := range prefixSet.(PolicyPrefixSet).PrefixInfoList {
+				db.addPrefixToPolicyIndex(index, prefixInfo, condition.Name)
+			}
+		case policyCommonDefs.PolicyConditionTypeProtocolMatch:
+			protocol := condition.ConditionInfo.(string)
+			index.protocolConditions[protocol] = append(index.protocolConditions[protocol], condition.Name)
+		case policyCommonDefs.PolicyConditionTypeNeighborMatch:
+			neighbor := condition.ConditionInfo.(string)
+			index.neighborConditions[neighbor] = append(index.neighborConditions[neighbor], condition.Name)
+		default:
+			index.otherConditions = append(index.otherConditions, condition.Name)
+		}
+		return nil
+	})
+	db.PolicyStmtDB.Visit(func(prefix patriciaDB.Prefix, item patriciaDB.Item) error {
+		stmt := item.(PolicyStmt)
+		stmtInfo := policyIndexStmtInfo{matchConditions: stmt.MatchConditions, hasConditions: stmt.Conditions != nil}
+		for _, condition := range stmt.Conditions {
+			if constraining[condition] {
+				stmtInfo.conditionCount++
+				index.conditionStmts[condition] = append(index.conditionStmts[condition], stmt.Name)
+			}
+		}
+		index.stmts[stmt.Name] = stmtInfo
+		return nil
+	})
+	return index
+}
+
+//returns the conditions which can match the entity
+func (db *PolicyEngineDB) policyIndexLookup(entity PolicyEngineFilterEntityParams) (index *policyIndex, conditionSet map[string]bool) {
+	db.IndexLock.Lock()
+	if !db.policyIndexValid() {
+		db.policyIndex = db.buildPolicyIndex()
+	}
+	index = db.policyIndex
+	db.IndexLock.Unlock()
+	conditionSet = make(map[string]bool)
+	if _, ipNet, err := net.ParseCIDR(entity.DestNetIp); err == nil {
+		prefixLen, _ := ipNet.Mask.Size()
+		index.prefixTries[GetPrefixAddressFamily(ipNet)].lookup(ipNet.IP, prefixLen, conditionSet)
+	}
+	for _, condition := range index.protocolConditions[entity.RouteProtocol] {
+		conditionSet[condition] = true
+	}
+	for _, condition := range index.neighborConditions[entity.NextHopIp] {
+		conditionSet[condition] = true
+	}
+	for _, condition := range index.otherConditions {
+		conditionSet[condition] = true
+	}
+	return index, conditionSet
+}
+
+//number of candidate conditions used by every stmt
+func (index *policyIndex) stmtHitCount(conditionSet map[string]bool) (hitCount map[string]int) {
+	hitCount = make(map[string]int)
+	for condition := range conditionSet {
+		for _, stmt := range index.conditionStmts[condition] {
+			hitCount[stmt]++
+		}
+	}
+	return hitCount
+}
+
+//whether the stmt can possibly match given its match type
+func (index *policyIndex) isCandidate(stmt string, hitCount map[string]int) bool {
+	stmtInfo, ok := index.stmts[stmt]
+	if !ok {
+		return true
+	}
+	if stmtInfo.matchConditions == "all" {
+		return hitCount[stmt] == stmtInfo.conditionCount
+	}
+	if stmtInfo.matchConditions == "any" {
+		return hitCount[stmt] > 0
+	}
+	return true
+}
+
+//whether the stmt has to be evaluated whatever the entity
+func (index *policyIndex) isAlwaysCandidate(stmt string) bool {
+	stmtInfo := index.stmts[stmt]
+	if !stmtInfo.hasConditions {
+		return true
+	}
+	if stmtInfo.matchConditions == "all" {
+		return stmtInfo.conditionCount == 0
+	}
+	return stmtInfo.matchConditions != "any"
+}
+
+//the info is kept as long as the policy keeps its precedence map, a policy created again
+//under the same name gets a new one
+func (index *policyIndex) policyInfo(policy Policy) *policyIndexPolicyInfo {
+	index.policyLock.Lock()
+	defer index.policyLock.Unlock()
+	info := index.policies[policy.Name]
+	if info != nil && reflect.ValueOf(info.stmtMap).Pointer() == reflect.ValueOf(policy.PolicyStmtPrecedenceMap).Pointer() {
+		return info
+	}
+	info = &policyIndexPolicyInfo{stmtMap: policy.PolicyStmtPrecedenceMap, positions: make(map[string][]int)}
+	precedences := make([]int, 0, len(policy.PolicyStmtPrecedenceMap))
+	for precedence := range policy.PolicyStmtPrecedenceMap {
+		precedences = append(precedences, precedence)
+	}
+	sort.Ints(precedences)
+	for _, precedence := range precedences {
+		stmt := policy.PolicyStmtPrecedenceMap[precedence]
+		//stmts not in the DB are skipped by the evaluation
+		if _, ok := index.stmts[stmt]; !ok {
+			continue
+		}
+		if index.isAlwaysCandidate(stmt) {
+			info.always = append(info.always, len(info.stmts))
+		}
+		info.positions[stmt] = append(info.positions[stmt], len(info.stmts))
+		info.stmts = append(info.stmts, policyIndexPolicyStmt{precedence: precedence, name: stmt})
+	}
+	index.policies[policy.Name] = info
+	return info
+}
+
+//stmts of the policy which can match, in precedence order. lastSkipped is set when the last stmt
+//of the policy is ruled out, evaluating it would have ended the policy on a miss
+func (index *policyIndex) candidateStmts(policy Policy, hitCount map[string]int) (stmtList []string, lastSkipped bool) {
+	info := index.policyInfo(policy)
+	candidates := make(map[int]bool, len(info.always))
+	for _, pos := range info.always {
+		candidates[pos] = true
+	}
+	for stmt := range hitCount {
+		if !index.isCandidate(stmt, hitCount) {
+			continue
+		}
+		for _, pos := range info.positions[stmt] {
+			candidates[pos] = true
+		}
+	}
+	positions := make([]int, 0, len(candidates))
+	for pos := range candidates {
+		positions = append(positions, pos)
+	}
+	sort.Ints(positions)
+	stmtList = make([]string, 0, len(positions))
+	for _, pos := range positions {
+		stmtList = append(stmtList, info.stmts[pos].name)
+	}
+	lastSkipped = len(info.stmts) > 0 && !candidates[len(info.stmts)-1]
+	return stmtList, lastSkipped
+}
+
+//stmts using a destination ip prefix condition which can match the entity
+func (index *policyIndex) prefixCandidateStmts(conditionSet map[string]bool) (stmtList []string) {
+	stmtSet := make(map[string]bool)
+	for condition := range conditionSet {
+		if !index.prefixConditions[condition] {
+			continue
+		}
+		for _, stmt := range index.conditionStmts[condition] {
+			stmtSet[stmt] = true
+		}
+	}
+	stmtList = make([]string, 0)
+	for stmt := range stmtSet {
+		stmtList = append(stmtList, stmt)
+	}
+	sort.Strings(stmtList)
+	return stmtList
+}
+
+//stmts using a destination ip prefix condition, what prefixCandidateStmts narrows down
+func (db *PolicyEngineDB) prefixConditionStmts() (stmtList []string) {
+	stmtList = make([]string, 0)
+	db.PolicyStmtDB.Visit(func(prefix patriciaDB.Prefix, item patriciaDB.Item) error {
+		stmt := item.(PolicyStmt)
+		for _, conditionName := range stmt.Conditions {
+			conditionItem := db.PolicyConditionsDB.Get(patriciaDB.Prefix(conditionName))
+			if conditionItem != nil && conditionItem.(PolicyCondition).ConditionType == policyCommonDefs.PolicyConditionTypeDstIpPrefixMatch &&
+				db.ConditionCheckfuncMap[policyCommonDefs.PolicyConditionTypeDstIpPrefixMatch] != nil {
+				stmtList = append(stmtList, stmt.Name)
+				break
+			}
+		}
+		return nil
+	})
+	sort.Strings(stmtList)
+	return stmtList
+}
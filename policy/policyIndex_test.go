@@ -0,0 +1,192 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"utils/logging"
+	"utils/policy/policyCommonDefs"
+)
+
+//builds a db with one import policy of numStmts statements, every statement
+//matching on a /24 prefix, a masklength range or a protocol
+func buildIndexTestPolicyEngineDB(t testing.TB, numStmts int, matchType string) *PolicyEngineDB {
+	db := NewPolicyEngineDB(&logging.Writer{})
+	protocols := []string{"STATIC", "CONNECTED", "BGP", "OSPF"}
+	defCfg := PolicyDefinitionConfig{Name: "policy1", Precedence: 1, MatchType: matchType, PolicyType: "ALL"}
+	for i := 0; i < numStmts; i++ {
+		prefixCfg := PolicyConditionConfig{Name: "prefix" + strconv.Itoa(i), ConditionType: "MatchDstIpPrefix"}
+		prefixCfg.MatchDstIpPrefixConditionInfo.Prefix.IpPrefix = fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)
+		prefixCfg.MatchDstIpPrefixConditionInfo.Prefix.MasklengthRange = "exact"
+		if i%10 == 9 {
+			prefixCfg.MatchDstIpPrefixConditionInfo.Prefix.MasklengthRange = strconv.Itoa(16+i%8) + "-" + strconv.Itoa(24+i%8)
+		}
+		protoCfg := PolicyConditionConfig{Name: "proto" + strconv.Itoa(i), ConditionType: "MatchProtocol", MatchProtocolConditionInfo: protocols[i%len(protocols)]}
+		for _, cfg := range []PolicyConditionConfig{prefixCfg, protoCfg} {
+			if _, err := db.CreatePolicyCondition(cfg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stmtCfg := PolicyStmtConfig{Name: "stmt" + strconv.Itoa(i), MatchConditions: "all", Actions: []string{"permit"}}
+		switch i % 3 {
+		case 0:
+			stmtCfg.Conditions = []string{prefixCfg.Name, protoCfg.Name}
+		case 1:
+			stmtCfg.Conditions = []string{prefixCfg.Name, protoCfg.Name}
+			stmtCfg.MatchConditions = "any"
+		case 2:
+			stmtCfg.Conditions = []string{protoCfg.Name}
+		}
+		if err := db.CreatePolicyStatement(stmtCfg); err != nil {
+			t.Fatal(err)
+		}
+		defCfg.PolicyDefinitionStatements = append(defCfg.PolicyDefinitionStatements, PolicyDefinitionStmtPrecedence{Precedence: i, Statement: stmtCfg.Name})
+	}
+	if err := db.CreatePolicyDefinition(defCfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreatePolicyAction(PolicyActionConfig{Name: "accept", ActionType: "RouteDisposition", Accept: true}); err != nil {
+		t.Fatal(err)
+	}
+	policy := db.PolicyDB.Get([]byte("policy1")).(Policy)
+	action := db.PolicyActionsDB.Get([]byte("accept")).(PolicyAction)
+	db.UpdateApplyPolicy(ApplyPolicyInfo{ApplyPolicy: policy, Action: action}, false)
+	return db
+}
+
+func indexTestEntities(numStmts int) (entities []PolicyEngineFilterEntityParams) {
+	protocols := []string{"STATIC", "CONNECTED", "BGP", "OSPF", "IBGP"}
+	for i := 0; i < numStmts+20; i += 7 {
+		for _, dest := range []string{"10.%d.%d.0/24", "10.%d.%d.128/25", "10.%d.0.0/16", "10.%d.%d.0/23", "20.%d.%d.0/24"} {
+			entities = append(entities, PolicyEngineFilterEntityParams{
+				DestNetIp:     fmt.Sprintf(dest, i/256, i%256),
+				RouteProtocol: protocols[i%len(protocols)],
+				CreatePath:    true,
+			})
+		}
+	}
+	return entities
+}
+
+func filterStmtHits(db *PolicyEngineDB, entity PolicyEngineFilterEntityParams) (hits []string) {
+	hits = make([]string, 0)
+	db.UpdateEntityDB = func(details PolicyDetails, params interface{}) {
+		hits = append(hits, details.PolicyStmt)
+	}
+	db.PolicyEngineFilter(entity, policyCommonDefs.PolicyPath_Import, nil)
+	return hits
+}
+
+func TestPolicyIndexMatchesLinearEvaluation(t *testing.T) {
+	for _, matchType := range []string{"all", "any"} {
+		db := buildIndexTestPolicyEngineDB(t, 300, matchType)
+		for _, entity := range indexTestEntities(300) {
+			db.SetPolicyIndexEnable(false)
+			linearHits := filterStmtHits(db, entity)
+			db.SetPolicyIndexEnable(true)
+			indexedHits := filterStmtHits(db, entity)
+			if !reflect.DeepEqual(linearHits, indexedHits) {
+				t.Errorf("matchType %s entity %s %s: linear hits %v, indexed hits %v", matchType, entity.DestNetIp, entity.RouteProtocol, linearHits, indexedHits)
+			}
+		}
+	}
+}
+
+func TestPolicyIndexInvalidation(t *testing.T) {
+	db := buildIndexTestPolicyEngineDB(t, 10, "all")
+	entity := PolicyEngineFilterEntityParams{DestNetIp: "30.1.1.0/24", RouteProtocol: "RIP", CreatePath: true}
+	if hits := filterStmtHits(db, entity); len(hits) != 0 {
+		t.Fatalf("unexpected hits %v", hits)
+	}
+	cfg := PolicyConditionConfig{Name: "rip", ConditionType: "MatchProtocol", MatchProtocolConditionInfo: "RIP"}
+	if _, err := db.CreatePolicyCondition(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreatePolicyStatement(PolicyStmtConfig{Name: "ripStmt", MatchConditions: "all", Conditions: []string{"rip"}, Actions: []string{"permit"}}); err != nil {
+		t.Fatal(err)
+	}
+	index, conditionSet := db.policyIndexLookup(entity)
+	if !conditionSet["rip"] || !index.isCandidate("ripStmt", index.stmtHitCount(conditionSet)) {
+		t.Fatal("new condition not in the index")
+	}
+}
+
+func TestPolicyIndexCheckActionsForPrefix(t *testing.T) {
+	db := buildIndexTestPolicyEngineDB(t, 10, "all")
+	for _, useIndex := range []bool{true, false} {
+		db.SetPolicyIndexEnable(useIndex)
+		//stmt0 matches 10.0.0.0/24 from STATIC routes
+		entity := PolicyEngineFilterEntityParams{DestNetIp: "10.0.0.0/24", RouteProtocol: "STATIC"}
+		actionList := db.PolicyEngineCheckActionsForEntity(entity, policyCommonDefs.PolicyConditionTypeDstIpPrefixMatch)
+		if !reflect.DeepEqual(actionList, []string{"permit"}) {
+			t.Fatalf("index %v: expected permit for %s, got %v", useIndex, entity.DestNetIp, actionList)
+		}
+		entity.RouteProtocol = "BGP"
+		if actionList = db.PolicyEngineCheckActionsForEntity(entity, policyCommonDefs.PolicyConditionTypeDstIpPrefixMatch); len(actionList) != 0 {
+			t.Fatalf("index %v: expected no actions for %s from %s, got %v", useIndex, entity.DestNetIp, entity.RouteProtocol, actionList)
+		}
+	}
+}
+
+func TestPolicyIndexApplyPolicyCandidates(t *testing.T) {
+	for _, matchType := range []string{"all", "any"} {
+		db := buildIndexTestPolicyEngineDB(t, 300, matchType)
+		info := db.ApplyPolicyMap["policy1"][0]
+		if !db.UsePolicyIndex {
+			t.Fatal("expected the index to be on by default")
+		}
+		for _, entity := range indexTestEntities(300) {
+			var linearHit, indexedHit bool
+			db.SetPolicyIndexEnable(false)
+			db.PolicyEngineApplyPolicy(&entity, info, policyCommonDefs.PolicyPath_Import, nil, &linearHit)
+			db.SetPolicyIndexEnable(true)
+			db.PolicyEngineApplyPolicy(&entity, info, policyCommonDefs.PolicyPath_Import, nil, &indexedHit)
+			if linearHit != indexedHit {
+				t.Errorf("matchType %s entity %s %s: linear hit %v, indexed hit %v", matchType, entity.DestNetIp, entity.RouteProtocol, linearHit, indexedHit)
+			}
+			index, conditionSet := db.policyIndexLookup(entity)
+			if stmtList, _ := index.candidateStmts(info.ApplyPolicy, index.stmtHitCount(conditionSet)); len(stmtList) > 150 {
+				t.Errorf("matchType %s entity %s %s: %d candidate stmts out of 300", matchType, entity.DestNetIp, entity.RouteProtocol, len(stmtList))
+			}
+		}
+	}
+}
+
+func benchmarkPolicyEngineFilter(b *testing.B, numStmts int, useIndex bool) {
+	db := buildIndexTestPolicyEngineDB(b, numStmts, "all")
+	db.SetPolicyIndexEnable(useIndex)
+	entities := indexTestEntities(numStmts)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.PolicyEngineFilter(entities[i%len(entities)], policyCommonDefs.PolicyPath_Import, nil)
+	}
+}
+
+func BenchmarkPolicyEngineFilterLinear100(b *testing.B)   { benchmarkPolicyEngineFilter(b, 100, false) }
+func BenchmarkPolicyEngineFilterIndexed100(b *testing.B)  { benchmarkPolicyEngineFilter(b, 100, true) }
+func BenchmarkPolicyEngineFilterLinear1000(b *testing.B)  { benchmarkPolicyEngineFilter(b, 1000, false) }
+func BenchmarkPolicyEngineFilterIndexed1000(b *testing.B) { benchmarkPolicyEngineFilter(b, 1000, true) }
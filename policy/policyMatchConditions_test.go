@@ -0,0 +1,59 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package policy
+
+import (
+	"testing"
+	"utils/logging"
+)
+
+//the result of the last condition used to decide an "all" statement when an earlier one failed
+func TestPolicyEngineMatchConditions(t *testing.T) {
+	db := NewPolicyEngineDB(&logging.Writer{})
+	for _, protocol := range []string{"STATIC", "BGP"} {
+		cfg := PolicyConditionConfig{Name: "match" + protocol, ConditionType: "MatchProtocol", MatchProtocolConditionInfo: protocol}
+		if _, err := db.CreatePolicyCondition(cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entity := PolicyEngineFilterEntityParams{DestNetIp: "10.1.1.0/24", RouteProtocol: "BGP"}
+	tests := []struct {
+		conditions      []string
+		matchConditions string
+		match           bool
+	}{
+		{[]string{"matchSTATIC", "matchBGP"}, "all", false},
+		{[]string{"matchBGP", "matchSTATIC"}, "all", false},
+		{[]string{"matchBGP"}, "all", true},
+		{[]string{"matchSTATIC", "matchBGP"}, "any", true},
+		{[]string{"matchBGP", "matchSTATIC"}, "any", true},
+		{[]string{"matchSTATIC"}, "any", false},
+	}
+	for _, test := range tests {
+		match, _ := db.PolicyEngineMatchConditions(entity, test.conditions, test.matchConditions)
+		if match != test.match {
+			t.Error(test.matchConditions, "of", test.conditions, "expected", test.match, "got", match)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// policyNotify.go
+package policy
+
+import (
+	"sort"
+	"utils/patriciaDB"
+)
+
+//policy object kinds, or-ed together to subscribe to several kinds
+const (
+	PolicyObjectTypePrefixSet = 1 << iota
+	PolicyObjectTypeCondition
+	PolicyObjectTypeAction
+	PolicyObjectTypeStmt
+	PolicyObjectTypeDefinition
+	PolicyObjectTypeApplyPolicy
+	PolicyObjectTypeAll = PolicyObjectTypePrefixSet | PolicyObjectTypeCondition | PolicyObjectTypeAction |
+		PolicyObjectTypeStmt | PolicyObjectTypeDefinition | PolicyObjectTypeApplyPolicy
+)
+
+const (
+	PolicyNotifyCreate = iota
+	PolicyNotifyUpdate
+	PolicyNotifyDelete
+)
+
+//notification sent to the subscribers on a change of a policy object
+//OldValue is nil on create and NewValue is nil on delete. The values are the objects as stored in the DB:
+//PolicyPrefixSet, PolicyCondition, PolicyAction, PolicyStmt, Policy and ApplyPolicyInfo
+type PolicyNotification struct {
+	ObjectType int
+	Op         int
+	Name       string
+	OldValue   interface{}
+	NewValue   interface{}
+}
+
+type PolicySubscriber interface {
+	PolicyNotify(notification PolicyNotification)
+}
+
+//adapter to use an ordinary function as a subscriber
+type PolicySubscriberFunc func(notification PolicyNotification)
+
+func (f PolicySubscriberFunc) PolicyNotify(notification PolicyNotification) {
+	f(notification)
+}
+
+type PolicySubscriptionId int
+
+type policySubscription struct {
+	id          PolicySubscriptionId
+	objectTypes int
+	subscriber  PolicySubscriber
+}
+
+//registers the subscriber for the objectTypes. With replay, the subscriber first gets a create notification
+//for every existing object in dependency order (prefix sets, conditions, actions, stmts, policies, apply bindings).
+//The replay runs under SubscriptionLock after the subscription is registered, so changes made meanwhile are
+//notified after the replay and none is lost. The policy DB itself is not locked while it is replayed: a change
+//made concurrently can be seen by the replay and also be notified, delivery is at least once and subscribers
+//should take a create of a known object as an update. Notifications are delivered synchronously in the config
+//path, so subscribers must not modify the policy DB or (un)subscribe from within PolicyNotify
+func (db *PolicyEngineDB) Subscribe(subscriber PolicySubscriber, objectTypes int, replay bool) PolicySubscriptionId {
+	subscription := &policySubscription{objectTypes: objectTypes, subscriber: subscriber}
+	db.SubscriptionLock.Lock()
+	db.lastSubscriptionId++
+	subscription.id = db.lastSubscriptionId
+	db.subscriptions = append(db.subscriptions, subscription)
+	if replay {
+		db.replayPolicyObjects(subscription)
+	}
+	db.SubscriptionLock.Unlock()
+	db.Logger.Info("Added policy subscription ", subscription.id, " for object types ", objectTypes)
+	return subscription.id
+}
+
+func (db *PolicyEngineDB) Unsubscribe(id PolicySubscriptionId) bool {
+	db.SubscriptionLock.Lock()
+	defer db.SubscriptionLock.Unlock()
+	for i, subscription := range db.subscriptions {
+		if subscription.id == id {
+			db.subscriptions = append(db.subscriptions[:i:i], db.subscriptions[i+1:]...)
+			db.Logger.Info("Removed policy subscription ", id)
+			return true
+		}
+	}
+	return false
+}
+
+func (db *PolicyEngineDB) notifyPolicySubscribers(objectType int, op int, name string, oldValue interface{}, newValue interface{}) {
+	db.SubscriptionLock.RLock()
+	subscriptions := db.subscriptions
+	db.SubscriptionLock.RUnlock()
+	notification := PolicyNotification{ObjectType: objectType, Op: op, Name: name, OldValue: oldValue, NewValue: newValue}
+	for _, subscription := range subscriptions {
+		if subscription.objectTypes&objectType != 0 {
+			subscription.subscriber.PolicyNotify(notification)
+		}
+	}
+}
+
+func (db *PolicyEngineDB) replayPolicyObjects(subscription *policySubscription) {
+	replayDB := func(objectType int, trie *patriciaDB.Trie) {
+		if subscription.objectTypes&objectType == 0 {
+			return
+		}
+		trie.Visit(func(prefix patriciaDB.Prefix, item patriciaDB.Item) error {
+			subscription.subscriber.PolicyNotify(PolicyNotification{ObjectType: objectType, Op: PolicyNotifyCreate,
+				Name: string(prefix), NewValue: item})
+			return nil
+		})
+	}
+	replayDB(PolicyObjectTypePrefixSet, db.PolicyPrefixSetDB)
+	replayDB(PolicyObjectTypeCondition, db.PolicyConditionsDB)
+	replayDB(PolicyObjectTypeAction, db.PolicyActionsDB)
+	replayDB(PolicyObjectTypeStmt, db.PolicyStmtDB)
+	replayDB(PolicyObjectTypeDefinition, db.PolicyDB)
+	if subscription.objectTypes&PolicyObjectTypeApplyPolicy == 0 {
+		return
+	}
+	names := make([]string, 0, len(db.ApplyPolicyMap))
+	for name := range db.ApplyPolicyMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, info := range db.ApplyPolicyMap[name] {
+			subscription.subscriber.PolicyNotify(PolicyNotification{ObjectType: PolicyObjectTypeApplyPolicy, Op: PolicyNotifyCreate,
+				Name: name, NewValue: info})
+		}
+	}
+}
+
+//copy of the string list so that an old value handed to the subscribers is not changed by later in place updates
+func copyNameList(nameList []string) []string {
+	if nameList == nil {
+		return nil
+	}
+	return append([]string(nil), nameList...)
+}
@@ -0,0 +1,146 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// policyPrefixSetApis.go
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"utils/patriciaDB"
+)
+
+type PolicyPrefixSetConfig struct {
+	Name       string         `json:"Name" yaml:"Name"`
+	PrefixList []PolicyPrefix `json:"PrefixList" yaml:"PrefixList"`
+}
+
+type PolicyPrefixSet struct {
+	Name            string
+	PrefixList      []PolicyPrefix
+	PrefixInfoList  []MatchPrefixConditionInfo
+	ConditionList   []string //conditions referring to this prefix set
+	LocalDBSliceIdx int
+}
+
+func (db *PolicyEngineDB) ValidatePolicyPrefixSetCreate(cfg PolicyPrefixSetConfig) (err error) {
+	db.Logger.Info(fmt.Sprintln("ValidatePolicyPrefixSetCreate"))
+	if db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(cfg.Name)) != nil {
+		db.Logger.Err(fmt.Sprintln("Duplicate prefix set name ", cfg.Name))
+		return errors.New("Duplicate policy prefix set definition")
+	}
+	if len(cfg.PrefixList) == 0 {
+		db.Logger.Err(fmt.Sprintln("Empty prefix list for prefix set ", cfg.Name))
+		return errors.New("Empty prefix list")
+	}
+	for i := 0; i < len(cfg.PrefixList); i++ {
+		_, err = db.GetMatchPrefixConditionInfo(cfg.PrefixList[i])
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (db *PolicyEngineDB) CreatePolicyPrefixSet(cfg PolicyPrefixSetConfig) (val bool, err error) {
+	db.Logger.Info(fmt.Sprintln("CreatePolicyPrefixSet"))
+	if db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(cfg.Name)) != nil {
+		db.Logger.Err(fmt.Sprintln("Duplicate prefix set name ", cfg.Name))
+		err = errors.New("Duplicate policy prefix set definition")
+		return false, err
+	}
+	newPrefixSet := PolicyPrefixSet{Name: cfg.Name, LocalDBSliceIdx: len(*db.LocalPolicyPrefixSetDB)}
+	newPrefixSet.PrefixList = make([]PolicyPrefix, 0)
+	newPrefixSet.PrefixInfoList = make([]MatchPrefixConditionInfo, 0)
+	for i := 0; i < len(cfg.PrefixList); i++ {
+		prefixInfo, err := db.GetMatchPrefixConditionInfo(cfg.PrefixList[i])
+		if err != nil {
+			return false, err
+		}
+		prefixInfo.DstIpMatch = true
+		newPrefixSet.PrefixList = append(newPrefixSet.PrefixList, cfg.PrefixList[i])
+		newPrefixSet.PrefixInfoList = append(newPrefixSet.PrefixInfoList, prefixInfo)
+	}
+	if ok := db.PolicyPrefixSetDB.Insert(patriciaDB.Prefix(cfg.Name), newPrefixSet); ok != true {
+		db.Logger.Err(fmt.Sprintln(" return value not ok"))
+		err = errors.New("Error creating prefix set in the DB")
+		return false, err
+	}
+	db.LocalPolicyPrefixSetDB.updateLocalDB(patriciaDB.Prefix(cfg.Name), add)
+	db.invalidatePolicyIndex()
+	db.updatePolicyDependencies(PolicyObjectTypePrefixSet, PolicyNotifyCreate, cfg.Name, newPrefixSet)
+	db.notifyPolicySubscribers(PolicyObjectTypePrefixSet, PolicyNotifyCreate, cfg.Name, nil, newPrefixSet)
+	return true, err
+}
+
+func (db *PolicyEngineDB) ValidatePolicyPrefixSetDelete(cfg PolicyPrefixSetConfig) (err error) {
+	db.Logger.Info(fmt.Sprintln("ValidatePolicyPrefixSetDelete"))
+	item := db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(cfg.Name))
+	if item == nil {
+		db.Logger.Err(fmt.Sprintln("prefix set ", cfg.Name, " not found in the DB"))
+		return errors.New("prefix set not found")
+	}
+	return db.ValidatePolicyObjectDelete(PolicyObjectTypePrefixSet, cfg.Name)
+}
+
+func (db *PolicyEngineDB) DeletePolicyPrefixSet(cfg PolicyPrefixSetConfig) (val bool, err error) {
+	db.Logger.Info(fmt.Sprintln("DeletePolicyPrefixSet"))
+	err = db.ValidatePolicyPrefixSetDelete(cfg)
+	if err != nil {
+		return false, err
+	}
+	item := db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(cfg.Name))
+	deleted := db.PolicyPrefixSetDB.Delete(patriciaDB.Prefix(cfg.Name))
+	if deleted {
+		db.Logger.Info(fmt.Sprintln("Found and deleted prefix set ", cfg.Name))
+		db.LocalPolicyPrefixSetDB.updateLocalDB(patriciaDB.Prefix(cfg.Name), del)
+		db.invalidatePolicyIndex()
+		db.updatePolicyDependencies(PolicyObjectTypePrefixSet, PolicyNotifyDelete, cfg.Name, nil)
+		db.notifyPolicySubscribers(PolicyObjectTypePrefixSet, PolicyNotifyDelete, cfg.Name, item, nil)
+	}
+	return true, err
+}
+
+func (db *PolicyEngineDB) updatePrefixSetConditions(prefixSetName string, conditionName string, op int) (err error) {
+	item := db.PolicyPrefixSetDB.Get(patriciaDB.Prefix(prefixSetName))
+	if item == nil {
+		db.Logger.Err(fmt.Sprintln("prefix set ", prefixSetName, " not defined"))
+		return errors.New("prefix set not defined")
+	}
+	prefixSet := item.(PolicyPrefixSet)
+	conditionList := make([]string, 0)
+	for _, name := range prefixSet.ConditionList {
+		if name != conditionName {
+			conditionList = append(conditionList, name)
+		}
+	}
+	if op == add {
+		conditionList = append(conditionList, conditionName)
+	}
+	oldPrefixSet := prefixSet
+	prefixSet.ConditionList = conditionList
+	db.PolicyPrefixSetDB.Set(patriciaDB.Prefix(prefixSetName), prefixSet)
+	db.updatePolicyDependencies(PolicyObjectTypePrefixSet, PolicyNotifyUpdate, prefixSetName, prefixSet)
+	db.notifyPolicySubscribers(PolicyObjectTypePrefixSet, PolicyNotifyUpdate, prefixSetName, oldPrefixSet, prefixSet)
+	return err
+}
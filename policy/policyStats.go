@@ -0,0 +1,221 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+// policyStats.go
+package policy
+
+import (
+	"errors"
+	"sort"
+	"time"
+	"utils/patriciaDB"
+)
+
+//counters kept for every policy, policy statement and policy condition. Evaluations is only
+//counted for the policies, the statements and conditions ruled out by the condition index are
+//never evaluated, so those count the matches of the statements only
+type PolicyCounters struct {
+	Evaluations    uint64
+	Matches        uint64
+	ActionsApplied uint64
+	LastMatchTime  time.Time
+}
+
+//struct returned to the application for get/getbulk of policy object counters
+type PolicyObjectStats struct {
+	Name string
+	PolicyCounters
+}
+
+type PolicyStatsDB map[string]*PolicyCounters
+
+func (statsDB PolicyStatsDB) counters(name string) *PolicyCounters {
+	counters, ok := statsDB[name]
+	if !ok {
+		counters = &PolicyCounters{}
+		statsDB[name] = counters
+	}
+	return counters
+}
+
+func (db *PolicyEngineDB) updatePolicyCounters(statsDB PolicyStatsDB, name string, evaluated bool, matched bool, actionsApplied int) {
+	db.StatsLock.Lock()
+	defer db.StatsLock.Unlock()
+	counters := statsDB.counters(name)
+	if evaluated {
+		counters.Evaluations++
+	}
+	if matched {
+		counters.Matches++
+		counters.LastMatchTime = time.Now()
+	}
+	counters.ActionsApplied += uint64(actionsApplied)
+}
+
+//counts a matching stmt, its matching conditions and the actions applied under one lock
+func (db *PolicyEngineDB) countPolicyStmtMatch(policy string, stmt string, conditionList []PolicyCondition, actionsApplied int) {
+	now := time.Now()
+	db.StatsLock.Lock()
+	defer db.StatsLock.Unlock()
+	counters := db.PolicyStmtStatsDB.counters(stmt)
+	counters.Matches++
+	counters.LastMatchTime = now
+	counters.ActionsApplied += uint64(actionsApplied)
+	for _, condition := range conditionList {
+		counters = db.PolicyConditionStatsDB.counters(condition.Name)
+		counters.Matches++
+		counters.LastMatchTime = now
+	}
+	db.PolicyStatsDB.counters(policy).ActionsApplied += uint64(actionsApplied)
+}
+
+func (db *PolicyEngineDB) deletePolicyCounters(statsDB PolicyStatsDB, name string) {
+	db.StatsLock.Lock()
+	delete(statsDB, name)
+	db.StatsLock.Unlock()
+}
+
+func (db *PolicyEngineDB) getPolicyObjectStats(objDB *patriciaDB.Trie, statsDB PolicyStatsDB, name string) (stats PolicyObjectStats, err error) {
+	if objDB.Get(patriciaDB.Prefix(name)) == nil {
+		return stats, errors.New("No policy object with this name found")
+	}
+	stats.Name = name
+	db.StatsLock.RLock()
+	if counters, ok := statsDB[name]; ok {
+		stats.PolicyCounters = *counters
+	}
+	db.StatsLock.RUnlock()
+	return stats, err
+}
+
+func (db *PolicyEngineDB) getBulkPolicyObjectStats(localDB *LocalDBSlice, statsDB PolicyStatsDB, fromIndex int, rcount int) (nextIndex int, count int, more bool, statsList []PolicyObjectStats) {
+	if localDB == nil {
+		return 0, 0, false, nil
+	}
+	slice := *localDB
+	if fromIndex < 0 || fromIndex >= len(slice) {
+		return 0, 0, false, nil
+	}
+	statsList = make([]PolicyObjectStats, 0)
+	db.StatsLock.RLock()
+	defer db.StatsLock.RUnlock()
+	var i int
+	for i = fromIndex; i < len(slice) && count < rcount; i++ {
+		if !slice[i].IsValid {
+			continue
+		}
+		stats := PolicyObjectStats{Name: string(slice[i].Prefix)}
+		if counters, ok := statsDB[stats.Name]; ok {
+			stats.PolicyCounters = *counters
+		}
+		statsList = append(statsList, stats)
+		count++
+	}
+	if i < len(slice) {
+		more = true
+		nextIndex = i
+	}
+	return nextIndex, count, more, statsList
+}
+
+func (db *PolicyEngineDB) resetPolicyObjectStats(objDB *patriciaDB.Trie, statsDB PolicyStatsDB, name string) (err error) {
+	if objDB.Get(patriciaDB.Prefix(name)) == nil {
+		return errors.New("No policy object with this name found")
+	}
+	db.deletePolicyCounters(statsDB, name)
+	return err
+}
+
+func (db *PolicyEngineDB) GetPolicyStats(name string) (PolicyObjectStats, error) {
+	return db.getPolicyObjectStats(db.PolicyDB, db.PolicyStatsDB, name)
+}
+func (db *PolicyEngineDB) GetPolicyStmtStats(name string) (PolicyObjectStats, error) {
+	return db.getPolicyObjectStats(db.PolicyStmtDB, db.PolicyStmtStatsDB, name)
+}
+func (db *PolicyEngineDB) GetPolicyConditionStats(name string) (PolicyObjectStats, error) {
+	return db.getPolicyObjectStats(db.PolicyConditionsDB, db.PolicyConditionStatsDB, name)
+}
+
+func (db *PolicyEngineDB) GetBulkPolicyStats(fromIndex int, rcount int) (nextIndex int, count int, more bool, statsList []PolicyObjectStats) {
+	return db.getBulkPolicyObjectStats(db.LocalPolicyDB, db.PolicyStatsDB, fromIndex, rcount)
+}
+func (db *PolicyEngineDB) GetBulkPolicyStmtStats(fromIndex int, rcount int) (nextIndex int, count int, more bool, statsList []PolicyObjectStats) {
+	return db.getBulkPolicyObjectStats(db.LocalPolicyStmtDB, db.PolicyStmtStatsDB, fromIndex, rcount)
+}
+func (db *PolicyEngineDB) GetBulkPolicyConditionStats(fromIndex int, rcount int) (nextIndex int, count int, more bool, statsList []PolicyObjectStats) {
+	return db.getBulkPolicyObjectStats(db.LocalPolicyConditionsDB, db.PolicyConditionStatsDB, fromIndex, rcount)
+}
+
+func (db *PolicyEngineDB) ResetPolicyStats(name string) error {
+	return db.resetPolicyObjectStats(db.PolicyDB, db.PolicyStatsDB, name)
+}
+func (db *PolicyEngineDB) ResetPolicyStmtStats(name string) error {
+	return db.resetPolicyObjectStats(db.PolicyStmtDB, db.PolicyStmtStatsDB, name)
+}
+func (db *PolicyEngineDB) ResetPolicyConditionStats(name string) error {
+	return db.resetPolicyObjectStats(db.PolicyConditionsDB, db.PolicyConditionStatsDB, name)
+}
+
+//the maps are cleared in place, the evaluation holds on to them outside of the lock
+func (db *PolicyEngineDB) ResetAllPolicyStats() {
+	db.StatsLock.Lock()
+	for _, statsDB := range []PolicyStatsDB{db.PolicyStatsDB, db.PolicyStmtStatsDB, db.PolicyConditionStatsDB} {
+		for name := range statsDB {
+			delete(statsDB, name)
+		}
+	}
+	db.StatsLock.Unlock()
+}
+
+//statements that have never matched any entity since creation or the last reset
+func (db *PolicyEngineDB) GetDeadPolicyStmts() (stmtList []string) {
+	stmtList = make([]string, 0)
+	db.StatsLock.RLock()
+	defer db.StatsLock.RUnlock()
+	for _, localStmt := range *db.LocalPolicyStmtDB {
+		if !localStmt.IsValid {
+			continue
+		}
+		counters, ok := db.PolicyStmtStatsDB[string(localStmt.Prefix)]
+		if !ok || counters.Matches == 0 {
+			stmtList = append(stmtList, string(localStmt.Prefix))
+		}
+	}
+	return stmtList
+}
+
+type policyObjectStatsByMatches []PolicyObjectStats
+
+func (s policyObjectStatsByMatches) Len() int           { return len(s) }
+func (s policyObjectStatsByMatches) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s policyObjectStatsByMatches) Less(i, j int) bool { return s[i].Matches > s[j].Matches }
+
+//top maxCount statements ordered by number of matches
+func (db *PolicyEngineDB) GetHotPolicyStmts(maxCount int) (statsList []PolicyObjectStats) {
+	_, _, _, statsList = db.GetBulkPolicyStmtStats(0, len(*db.LocalPolicyStmtDB))
+	sort.Stable(policyObjectStatsByMatches(statsList))
+	if len(statsList) > maxCount {
+		statsList = statsList[:maxCount]
+	}
+	return statsList
+}
@@ -0,0 +1,202 @@
+//
+//Copyright [2016] [SnapRoute Inc]
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//	 Unless required by applicable law or agreed to in writing, software
+//	 distributed under the License is distributed on an "AS IS" BASIS,
+//	 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	 See the License for the specific language governing permissions and
+//	 limitations under the License.
+//
+// _______  __       __________   ___      _______.____    __    ____  __  .___________.  ______  __    __
+// |   ____||  |     |   ____\  \ /  /     /       |\   \  /  \  /   / |  | |           | /      ||  |  |  |
+// |  |__   |  |     |  |__   \  V  /     |   (----` \   \/    \/   /  |  | `---|  |----`|  ,----'|  |__|  |
+// |   __|  |  |     |   __|   >   <       \   \      \            /   |  |     |  |     |  |     |   __   |
+// |  |     |  `----.|  |____ /  .  \  .----)   |      \    /\    /    |  |     |  |     |  `----.|  |  |  |
+// |__|     |_______||_______/__/ \__\ |_______/        \__/  \__/     |__|     |__|      \______||__|  |__|
+//
+
+package policy
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPolicyStatsCounters(t *testing.T) {
+	db := buildIndexTestPolicyEngineDB(t, 10, "all")
+	entity := indexTestEntities(10)[0]
+	hits := filterStmtHits(db, entity)
+	if len(hits) == 0 {
+		t.Fatal("Expected", entity.DestNetIp, "to match a statement")
+	}
+	policyStats, err := db.GetPolicyStats("policy1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policyStats.Evaluations != 1 || policyStats.Matches != 1 || policyStats.LastMatchTime.IsZero() {
+		t.Fatal("Unexpected policy counters", policyStats)
+	}
+	if policyStats.ActionsApplied != uint64(len(hits)) {
+		t.Fatal("Expected", len(hits), "actions applied, got", policyStats.ActionsApplied)
+	}
+	for _, stmt := range hits {
+		stats, err := db.GetPolicyStmtStats(stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Matches != 1 || stats.ActionsApplied != 1 {
+			t.Fatal("Unexpected counters of the matching statement", stats)
+		}
+	}
+	conditionStats, err := db.GetPolicyConditionStats("proto0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conditionStats.Matches != 1 || conditionStats.Evaluations != 0 {
+		t.Fatal("Expected proto0 to count the match of stmt0 only", conditionStats)
+	}
+	if _, err = db.GetPolicyStmtStats("unknown"); err == nil {
+		t.Fatal("Expected an error for an unknown statement")
+	}
+}
+
+func TestPolicyStatsWithAndWithoutIndex(t *testing.T) {
+	counters := func(db *PolicyEngineDB) map[string]PolicyCounters {
+		all := make(map[string]PolicyCounters)
+		for kind, getBulk := range map[string]func(int, int) (int, int, bool, []PolicyObjectStats){
+			"policy": db.GetBulkPolicyStats, "stmt": db.GetBulkPolicyStmtStats, "condition": db.GetBulkPolicyConditionStats} {
+			_, _, _, statsList := getBulk(0, 1000)
+			for _, stats := range statsList {
+				stats.LastMatchTime = time.Time{}
+				all[kind+" "+stats.Name] = stats.PolicyCounters
+			}
+		}
+		return all
+	}
+	linearDB := buildIndexTestPolicyEngineDB(t, 30, "all")
+	linearDB.SetPolicyIndexEnable(false)
+	indexedDB := buildIndexTestPolicyEngineDB(t, 30, "all")
+	for _, entity := range indexTestEntities(30) {
+		filterStmtHits(linearDB, entity)
+		filterStmtHits(indexedDB, entity)
+	}
+	if linear, indexed := counters(linearDB), counters(indexedDB); !reflect.DeepEqual(linear, indexed) {
+		t.Fatal("Counters differ with the index, linear", linear, "indexed", indexed)
+	}
+}
+
+func TestPolicyStatsGetBulk(t *testing.T) {
+	db := buildIndexTestPolicyEngineDB(t, 10, "all")
+	nextIndex, count, more, statsList := db.GetBulkPolicyStmtStats(0, 4)
+	if count != 4 || len(statsList) != 4 || !more || nextIndex != 4 {
+		t.Fatal("Unexpected first page", nextIndex, count, more, statsList)
+	}
+	names := make(map[string]bool)
+	for fromIndex := 0; ; {
+		nextIndex, _, more, statsList = db.GetBulkPolicyStmtStats(fromIndex, 4)
+		for _, stats := range statsList {
+			names[stats.Name] = true
+		}
+		if !more {
+			break
+		}
+		fromIndex = nextIndex
+	}
+	if len(names) != 10 {
+		t.Fatal("Expected the 10 statements across the pages, got", names)
+	}
+	if _, count, more, _ = db.GetBulkPolicyStmtStats(100, 4); count != 0 || more {
+		t.Fatal("Expected nothing past the end")
+	}
+}
+
+func TestPolicyStatsReset(t *testing.T) {
+	db := buildIndexTestPolicyEngineDB(t, 10, "all")
+	entity := indexTestEntities(10)[0]
+	hits := filterStmtHits(db, entity)
+	if err := db.ResetPolicyStmtStats(hits[0]); err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := db.GetPolicyStmtStats(hits[0]); stats.Matches != 0 {
+		t.Fatal("Expected the statement counters to be reset", stats)
+	}
+	if stats, _ := db.GetPolicyStats("policy1"); stats.Matches != 1 {
+		t.Fatal("Expected the policy counters to be kept", stats)
+	}
+	if err := db.ResetPolicyStats("unknown"); err == nil {
+		t.Fatal("Expected an error for an unknown policy")
+	}
+	db.ResetAllPolicyStats()
+	if stats, _ := db.GetPolicyStats("policy1"); stats.Evaluations != 0 {
+		t.Fatal("Expected all the counters to be reset", stats)
+	}
+	filterStmtHits(db, entity)
+	if stats, _ := db.GetPolicyStats("policy1"); stats.Evaluations != 1 {
+		t.Fatal("Expected counting to resume after the reset", stats)
+	}
+}
+
+func TestPolicyStatsResetDuringEvaluation(t *testing.T) {
+	db := buildIndexTestPolicyEngineDB(t, 10, "all")
+	var wg sync.WaitGroup
+	stopCh := make(chan bool)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+				db.ResetAllPolicyStats()
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	for _, entity := range indexTestEntities(10) {
+		filterStmtHits(db, entity)
+	}
+	close(stopCh)
+	wg.Wait()
+}
+
+func TestDeadAndHotPolicyStmts(t *testing.T) {
+	db := buildIndexTestPolicyEngineDB(t, 10, "all")
+	entities := indexTestEntities(10)
+	hitCount := make(map[string]int)
+	for _, entity := range entities {
+		for _, stmt := range filterStmtHits(db, entity) {
+			hitCount[stmt]++
+		}
+	}
+	dead := db.GetDeadPolicyStmts()
+	if len(dead)+len(hitCount) != 10 {
+		t.Fatal("Expected the statements without hits to be dead, hits", hitCount, "dead", dead)
+	}
+	for _, stmt := range dead {
+		if hitCount[stmt] != 0 {
+			t.Fatal("Statement", stmt, "matched but listed as dead")
+		}
+	}
+	hot := db.GetHotPolicyStmts(2)
+	if len(hot) != 2 {
+		t.Fatal("Expected the 2 hottest statements, got", hot)
+	}
+	counts := make([]int, 0, len(hitCount))
+	for _, count := range hitCount {
+		counts = append(counts, count)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+	if int(hot[0].Matches) != counts[0] || hot[0].Matches < hot[1].Matches {
+		t.Fatal("Expected the statements ordered by matches, got", hot, "hits", hitCount)
+	}
+}
@@ -27,6 +27,8 @@ package policy
 import (
 	"bytes"
 	"errors"
+	"net"
+	"sync"
 	//	"log"
 	//	"log/syslog"
 	//	"os"
@@ -124,7 +126,7 @@ type PolicyEntityMapIndex interface{}
 type GetPolicyEnityMapIndexFunc func(entity PolicyEngineFilterEntityParams, policy string) PolicyEntityMapIndex
 
 type PolicyEngineDB struct {
-	Logger                          *logging.Writer //*log.Logger
+	Logger                          logging.LoggerIntf //*log.Logger
 	PolicyConditionsDB              *patriciaDB.Trie
 	LocalPolicyConditionsDB         *LocalDBSlice
 	PolicyActionsDB                 *patriciaDB.Trie
@@ -133,8 +135,11 @@ type PolicyEngineDB struct {
 	LocalPolicyStmtDB               *LocalDBSlice
 	PolicyDB                        *patriciaDB.Trie
 	LocalPolicyDB                   *LocalDBSlice
+	PolicyPrefixSetDB               *patriciaDB.Trie
+	LocalPolicyPrefixSetDB          *LocalDBSlice
 	PolicyStmtPolicyMapDB           map[string][]string //policies using this statement
-	PrefixPolicyListDB              *patriciaDB.Trie
+	PrefixPolicyListDB              *patriciaDB.Trie    //IPv4 prefixes
+	IPv6PrefixPolicyListDB          *patriciaDB.Trie
 	ProtocolPolicyListDB            map[string][]string //policystmt names assoociated with every protocol type
 	ImportPolicyPrecedenceMap       map[int]string
 	ExportPolicyPrecedenceMap       map[int]string
@@ -152,6 +157,24 @@ type PolicyEngineDB struct {
 	TraverseAndReversePolicyFunc    EntityTraverseAndReversePolicyfunc
 	ValidConditionsForPolicyTypeMap map[string][]int //map of policyType to list of valid conditions
 	ValidActionsForPolicyTypeMap    map[string][]int //map of policyType to list of valid actions
+	PolicyStatsDB                   PolicyStatsDB    //hit counters per policy
+	PolicyStmtStatsDB               PolicyStatsDB    //hit counters per policy statement
+	PolicyConditionStatsDB          PolicyStatsDB    //hit counters per policy condition
+	StatsLock                       sync.RWMutex
+	UsePolicyIndex                  bool //narrow down the stmts to evaluate with the condition index, on unless disabled with SetPolicyIndexEnable
+	IndexLock                       sync.Mutex
+	policyIndex                     *policyIndex
+	AggregateDB                     map[string]*policyAggregateEntry //aggregates by prefix
+	AggregateLock                   sync.RWMutex
+	AggregateCreateFunc             PolicyAggregatefunc
+	AggregateWithdrawFunc           PolicyAggregatefunc
+	AggregateSuppressFunc           PolicyAggregateSuppressfunc
+	SubscriptionLock                sync.RWMutex
+	subscriptions                   []*policySubscription
+	lastSubscriptionId              PolicySubscriptionId
+	PolicyObjectUsesDB              map[PolicyObjectRef]map[PolicyObjectRef]bool //objects used by every object
+	PolicyObjectUsedByDB            map[PolicyObjectRef]map[PolicyObjectRef]bool //objects using every object
+	DependencyLock                  sync.RWMutex
 }
 
 func (db *PolicyEngineDB) buildPolicyConditionCheckfuncMap() {
@@ -168,7 +191,7 @@ func (db *PolicyEngineDB) buildPolicyValidActionsForPolicyTypeMap() {
 	db.ValidActionsForPolicyTypeMap["ALL"] = []int{policyCommonDefs.PolicyActionTypeRouteDisposition, policyCommonDefs.PolicyActionTypeRouteRedistribute}
 	db.ValidActionsForPolicyTypeMap["BGP"] = []int{policyCommonDefs.PolicyActionTypeAggregate}
 }
-func NewPolicyEngineDB(logger *logging.Writer) (policyEngineDB *PolicyEngineDB) {
+func NewPolicyEngineDB(logger logging.LoggerIntf) (policyEngineDB *PolicyEngineDB) {
 	policyEngineDB = &PolicyEngineDB{}
 	/*	if policyEngineDB.Logger == nil {
 		policyEngineDB.Logger = log.New(os.Stdout, "PolicyEngine :", log.Ldate|log.Ltime|log.Lshortfile)
@@ -200,9 +223,15 @@ func NewPolicyEngineDB(logger *logging.Writer) (policyEngineDB *PolicyEngineDB)
 	localPolicySlice := LocalDBSlice(LocalPolicyDB)
 	policyEngineDB.LocalPolicyDB = &localPolicySlice
 
+	policyEngineDB.PolicyPrefixSetDB = patriciaDB.NewTrie()
+	LocalPolicyPrefixSetDB := make([]LocalDB, 0)
+	localPrefixSetSlice := LocalDBSlice(LocalPolicyPrefixSetDB)
+	policyEngineDB.LocalPolicyPrefixSetDB = &localPrefixSetSlice
+
 	policyEngineDB.PolicyStmtPolicyMapDB = make(map[string][]string)
 	policyEngineDB.PolicyEntityMap = make(map[PolicyEntityMapIndex]PolicyStmtMap)
 	policyEngineDB.PrefixPolicyListDB = patriciaDB.NewTrie()
+	policyEngineDB.IPv6PrefixPolicyListDB = patriciaDB.NewTrie()
 	policyEngineDB.ProtocolPolicyListDB = make(map[string][]string)
 	policyEngineDB.ImportPolicyPrecedenceMap = make(map[int]string)
 	policyEngineDB.ExportPolicyPrecedenceMap = make(map[int]string)
@@ -215,6 +244,13 @@ func NewPolicyEngineDB(logger *logging.Writer) (policyEngineDB *PolicyEngineDB)
 	policyEngineDB.buildPolicyValidActionsForPolicyTypeMap()
 	policyEngineDB.ActionfuncMap = make(map[int]Policyfunc)
 	policyEngineDB.UndoActionfuncMap = make(map[int]UndoActionfunc)
+	policyEngineDB.PolicyStatsDB = make(PolicyStatsDB)
+	policyEngineDB.PolicyStmtStatsDB = make(PolicyStatsDB)
+	policyEngineDB.PolicyConditionStatsDB = make(PolicyStatsDB)
+	policyEngineDB.UsePolicyIndex = true
+	policyEngineDB.AggregateDB = make(map[string]*policyAggregateEntry)
+	policyEngineDB.PolicyObjectUsesDB = make(map[PolicyObjectRef]map[PolicyObjectRef]bool)
+	policyEngineDB.PolicyObjectUsedByDB = make(map[PolicyObjectRef]map[PolicyObjectRef]bool)
 	return policyEngineDB
 }
 
@@ -351,6 +387,12 @@ func PolicyActionStrToIntType(action string) (actionType int, err error) {
 	case "Aggregate":
 		actionType = policyCommonDefs.PolicyActionTypeAggregate
 		break
+	case "RIBIn":
+		actionType = policyCommonDefs.PolicyActionTypeRIBIn
+		break
+	case "RIBOut":
+		actionType = policyCommonDefs.PolicyActionTypeRIBOut
+		break
 	default:
 		return -1, errors.New("Unknown ActionType")
 	}
@@ -423,3 +465,18 @@ func (db *PolicyEngineDB) ConditionCheckForPolicyType(conditionName string, poli
 	db.Logger.Info("Condition ", conditionName, " not valid for policyType: ", policyType)
 	return false
 }
+
+//IPv4 and IPv6 prefixes are kept in separate tables, a prefix of one family never matches the other
+func GetPrefixAddressFamily(ipNet *net.IPNet) int {
+	if len(ipNet.Mask) == net.IPv4len {
+		return policyCommonDefs.PolicyAddressFamilyIPv4
+	}
+	return policyCommonDefs.PolicyAddressFamilyIPv6
+}
+
+func (db *PolicyEngineDB) prefixPolicyListDB(addressFamily int) *patriciaDB.Trie {
+	if addressFamily == policyCommonDefs.PolicyAddressFamilyIPv6 {
+		return db.IPv6PrefixPolicyListDB
+	}
+	return db.PrefixPolicyListDB
+}
@@ -36,6 +36,9 @@ const (
 	objDelete
 )
 
+//most state updates written to the DB in one round trip
+const stateBatchSize = 1000
+
 var objOperation = map[uint8]string{
 	objAdd:    "add",
 	objUpdate: "update",
@@ -48,12 +51,12 @@ type objInfo struct {
 }
 
 type FSDBClient struct {
-	logger     *logging.Writer
+	logger     logging.LoggerIntf
 	dbUtil     *dbutils.DBUtil
 	objStateCh chan objInfo
 }
 
-func NewFSDBClient(logger *logging.Writer) *FSDBClient {
+func NewFSDBClient(logger logging.LoggerIntf) *FSDBClient {
 	return &FSDBClient{
 		logger:     logger,
 		dbUtil:     dbutils.NewDBUtil(logger),
@@ -73,87 +76,101 @@ func (fs *FSDBClient) Init() error {
 }
 
 func (fs *FSDBClient) AddObject(obj objects.ConfigObj) error {
-	fs.logger.Info("AddObject object %s", obj.GetKey())
+	logging.With(fs.logger, "object", obj.GetKey()).Info("AddObject")
 	fs.objStateCh <- objInfo{objAdd, obj}
 	return nil
 }
 
 func (fs *FSDBClient) DeleteObject(obj objects.ConfigObj) error {
-	fs.logger.Info("DeleteObject object %s", obj.GetKey())
+	logging.With(fs.logger, "object", obj.GetKey()).Info("DeleteObject")
 	fs.objStateCh <- objInfo{objDelete, obj}
 	return nil
 }
 
 func (fs *FSDBClient) UpdateObject(obj objects.ConfigObj) error {
-	fs.logger.Info("UpdateObject object %s", obj.GetKey())
+	logging.With(fs.logger, "object", obj.GetKey()).Info("UpdateObject")
 	fs.objStateCh <- objInfo{objUpdate, obj}
 	return nil
 }
 
 /* This is done synchronously as we delete all the objects in the state DB when a process comes up */
 func (fs *FSDBClient) DeleteAllObjects(obj objects.ConfigObj) error {
-	fs.logger.Info("DeleteAllObjects object %s", obj.GetKey())
+	logging.With(fs.logger, "object", obj.GetKey()).Info("DeleteAllObjects")
 	objs, err := fs.dbUtil.GetAllObjFromDb(obj)
 	if err != nil {
-		fs.logger.Err("DeleteAllObjects - GetAllObjFromDb failed with error %s", err)
+		logging.With(fs.logger, "object", obj.GetKey()).Err("DeleteAllObjects - GetAllObjFromDb failed", "error", err)
 		return err
 	}
 
-	for idx, _ := range objs {
-		fs.delObjToDB(objs[idx])
-	}
-	return nil
-}
-
-func (fs *FSDBClient) addObjToDB(obj objects.ConfigObj) error {
-	//fs.logger.Info("addObjToDB object %s", obj.GetKey())
-	err := fs.dbUtil.StoreObjectInDb(obj)
+	err = fs.dbUtil.DeleteObjectsFromDb(objs)
 	if err != nil {
-		fs.logger.Err("Failed to add state object %s to DB with error %s", obj.GetKey(), err)
+		logging.With(fs.logger, "object", obj.GetKey(), "count", len(objs)).Err("Failed to delete state objects from DB",
+			"error", err)
 		return err
 	}
-	//fs.logger.Info("Added state object %s to DB", obj.GetKey())
 	return nil
 }
 
-func (fs *FSDBClient) delObjToDB(obj objects.ConfigObj) error {
-	//fs.logger.Info("delObjToDB object %s", obj.GetKey())
-	err := fs.dbUtil.DeleteObjectFromDb(obj)
-	if err != nil {
-		fs.logger.Err("Failed to delete state object %s from DB with error", obj.GetKey(), err)
-		return err
+//queues the operation in the batch, an update replaces the whole object
+func (fs *FSDBClient) batchObj(batch *dbutils.Batch, info objInfo) error {
+	switch info.operation {
+	case objAdd:
+		return batch.StoreObject(info.obj)
+	case objDelete:
+		return batch.DeleteObject(info.obj)
+	case objUpdate:
+		return batch.ReplaceObject(info.obj)
 	}
-	fs.logger.Info("Deleted state object %s from DB", obj.GetKey())
+	logging.With(fs.logger, "object", info.obj.GetKey()).Err("Recieved unknown operation for state object",
+		"operation", info.operation)
 	return nil
 }
 
+//writes the state updates received meanwhile together, each batch is applied atomically
 func (fs *FSDBClient) StartStateObjectReceiver() {
 	fs.logger.Info("Starting the state object receiver")
-	var err error
+	batch := fs.dbUtil.NewBatch(true)
+	infos := make([]objInfo, 0, stateBatchSize)
 
 	for {
-		err = nil
-		select {
-		case info := <-fs.objStateCh:
-			if info.operation == objAdd {
-				err = fs.addObjToDB(info.obj)
-			} else if info.operation == objDelete {
-				err = fs.delObjToDB(info.obj)
-			} else if info.operation == objUpdate {
-				//err = fs.updObjToDB(info.obj)
-				err = fs.delObjToDB(info.obj)
-				if err == nil {
-					err = fs.addObjToDB(info.obj)
-				}
-			} else {
-				fs.logger.Err("Recieved unknown operation %d for state object %s", info.operation,
-					info.obj.GetKey())
+		infos = append(infos[:0], <-fs.objStateCh)
+	drain:
+		for len(infos) < stateBatchSize {
+			select {
+			case info := <-fs.objStateCh:
+				infos = append(infos, info)
+			default:
+				break drain
 			}
-
-			if err != nil {
-				fs.logger.Err("Failed to %s state object %s", objOperation[info.operation],
-					info.obj.GetKey())
+		}
+		for _, info := range infos {
+			fs.batchObjOrLog(batch, info)
+		}
+		if _, err := batch.Flush(); err != nil {
+			logging.With(fs.logger, "object", infos[0].obj.GetKey(), "count", len(infos)).Err(
+				"Failed to update state objects", "error", err)
+			if fs.dbUtil.IsConnected() {
+				fs.updateObjsOneByOne(batch, infos)
 			}
 		}
 	}
 }
+
+func (fs *FSDBClient) batchObjOrLog(batch *dbutils.Batch, info objInfo) {
+	if err := fs.batchObj(batch, info); err != nil {
+		logging.With(fs.logger, "object", info.obj.GetKey()).Err("Failed to update state object",
+			"operation", objOperation[info.operation], "error", err)
+	}
+}
+
+//the objects of a failed batch are written again separately, so that one bad object doesn't
+//hold back the others
+func (fs *FSDBClient) updateObjsOneByOne(batch *dbutils.Batch, infos []objInfo) {
+	for _, info := range infos {
+		fs.batchObjOrLog(batch, info)
+		if _, err := batch.Flush(); err != nil {
+			logging.With(fs.logger, "object", info.obj.GetKey()).Err("Failed to update state object",
+				"operation", objOperation[info.operation], "error", err)
+		}
+	}
+}
@@ -32,7 +32,7 @@ import (
 type OVSDBClient struct {
 }
 
-func NewOVSDBClient(logger *logging.Writer) *OVSDBClient {
+func NewOVSDBClient(logger logging.LoggerIntf) *OVSDBClient {
 	return &OVSDBClient{}
 }
 
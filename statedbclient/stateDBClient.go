@@ -44,7 +44,7 @@ type StateDBClient interface {
 	DeleteAllObjects(obj objects.ConfigObj) error
 }
 
-func NewStateDBClient(plugin string, logger *logging.Writer) (StateDBClient, error) {
+func NewStateDBClient(plugin string, logger logging.LoggerIntf) (StateDBClient, error) {
 	var client StateDBClient
 	if plugin == FlexSwitchPlugin {
 		client = flexswitch.NewFSDBClient(logger)